@@ -0,0 +1,256 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrAPIKeyNotFound is returned when an API key doesn't exist.
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// ErrAPIKeyExists is returned when creating an API key with the same name as an existing one.
+var ErrAPIKeyExists = errors.New("API key already exists")
+
+// ErrAPIKeyExpired is returned by CheckAPIKey when a key has expired.
+var ErrAPIKeyExpired = errors.New("API key expired")
+
+// Scope is something an API key may be used for.
+type Scope string
+
+const (
+	// ScopeRead allows searching for, browsing and downloading books.
+	ScopeRead Scope = "read"
+	// ScopeUpdate allows changing books, shelves and saved searches.
+	ScopeUpdate Scope = "update"
+	// ScopeMerge allows merging books.
+	ScopeMerge Scope = "merge"
+	// ScopeImport allows importing books.
+	ScopeImport Scope = "import"
+)
+
+// Scopes are the scopes accepted by ParseScope.
+var Scopes = []Scope{ScopeRead, ScopeUpdate, ScopeMerge, ScopeImport}
+
+// ParseScope parses a scope by name: read, update, merge, or import.
+func ParseScope(name string) (Scope, error) {
+	s := Scope(strings.ToLower(strings.TrimSpace(name)))
+	for _, scope := range Scopes {
+		if s == scope {
+			return s, nil
+		}
+	}
+	names := make([]string, len(Scopes))
+	for i, scope := range Scopes {
+		names[i] = string(scope)
+	}
+	return "", errors.Errorf("unknown scope %s; scopes are %s", name, strings.Join(names, ", "))
+}
+
+// Role returns the role a user needs for an API key with the scope to be used for it.
+func (s Scope) Role() Role {
+	if s == ScopeRead {
+		return RoleReader
+	}
+	return RoleEditor
+}
+
+// APIKey is a key for calling the web server's API as a user.
+// Only a hash of the key itself is stored, so it can't be shown again after it's created.
+type APIKey struct {
+	ID   int64
+	Name string
+	// User is the name of the user the key belongs to.
+	User   string
+	Scopes []Scope
+	// Expires is when the key stops working, or zero if it doesn't.
+	Expires time.Time
+	// Created is when the key was created, and LastUsed when it was last used, or zero if it hasn't been.
+	// LastUsed is only updated once a minute.
+	Created, LastUsed time.Time
+}
+
+// HasScope returns true if the key may be used for scope.
+func (k APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hashToken returns the hash an API key or session token is stored as.
+// They're long and random, so unlike passwords, they don't need a slow hash.
+func hashToken(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a new random API key or session token.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateAPIKey creates an API key for a user, with the given scopes, which expires at expires, or never if it's zero.
+// It returns the key, which can't be retrieved later.
+// If a key with the same name already exists, ignoring case, ErrAPIKeyExists is returned,
+// and if the user doesn't exist, ErrUserNotFound is returned.
+func (lib *Library) CreateAPIKey(name, user string, scopes []Scope, expires time.Time) (APIKey, string, error) {
+	apiKey := APIKey{Name: strings.TrimSpace(name), Scopes: scopes, Expires: expires}
+	if err := checkName("API key", apiKey.Name); err != nil {
+		return apiKey, "", err
+	}
+	if len(scopes) == 0 {
+		return apiKey, "", errors.New("an API key needs at least one scope")
+	}
+	key, err := newToken()
+	if err != nil {
+		return apiKey, "", errors.Wrap(err, "generate key")
+	}
+
+	ctx := context.Background()
+	tx, err := lib.begin(ctx)
+	if err != nil {
+		return apiKey, "", errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	u, _, err := getUser(ctx, tx, user)
+	if err != nil {
+		return apiKey, "", err
+	}
+	apiKey.User = u.Name
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "select exists (select 1 from api_keys where name=?)", apiKey.Name).Scan(&exists); err != nil {
+		return apiKey, "", errors.Wrap(err, "get API key")
+	} else if exists {
+		return apiKey, "", ErrAPIKeyExists
+	}
+	var expiresOn interface{}
+	if !expires.IsZero() {
+		expiresOn = expires.UTC().Format(dbTimeFormat)
+	}
+	res, err := tx.ExecContext(ctx, "insert into api_keys (name, key_hash, user_id, scopes, expires_on) values(?, ?, ?, ?, ?)",
+		apiKey.Name, hashToken(key), u.ID, joinScopes(scopes), expiresOn)
+	if err != nil {
+		return apiKey, "", errors.Wrap(err, "insert API key")
+	}
+	if apiKey.ID, err = res.LastInsertId(); err != nil {
+		return apiKey, "", errors.Wrap(err, "get API key ID")
+	}
+	if err := tx.Commit(); err != nil {
+		return apiKey, "", errors.Wrap(err, "commit transaction")
+	}
+	return apiKey, key, nil
+}
+
+// joinScopes joins scopes with commas, for storing them.
+func joinScopes(scopes []Scope) string {
+	names := make([]string, len(scopes))
+	for i, s := range scopes {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ",")
+}
+
+// RevokeAPIKey deletes an API key, so it can't be used any more.
+func (lib *Library) RevokeAPIKey(name string) error {
+	res, err := lib.Exec("delete from api_keys where name=?", name)
+	if err != nil {
+		return errors.Wrap(err, "delete API key")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "delete API key")
+	} else if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// apiKeyColumns are the columns scanned by scanAPIKey, from api_keys k joined with users u.
+const apiKeyColumns = "k.id, k.name, u.name, k.scopes, k.expires_on, k.created_on, k.last_used_on"
+
+// GetAPIKeys returns every API key, sorted by name.
+func (lib *Library) GetAPIKeys() ([]APIKey, error) {
+	rows, err := lib.readDB.Query("select " + apiKeyColumns + " from api_keys k join users u on k.user_id=u.id order by k.name")
+	if err != nil {
+		return nil, errors.Wrap(err, "query API keys")
+	}
+	defer rows.Close()
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, errors.Wrap(rows.Err(), "query API keys")
+}
+
+// CheckAPIKeyContext returns an API key, and the user it belongs to, and records that it was used.
+// If the key doesn't exist, ErrAPIKeyNotFound is returned.
+// If it has expired, ErrAPIKeyExpired is returned with the key, so the caller can tell whose it was.
+func (lib *Library) CheckAPIKeyContext(ctx context.Context, key string) (APIKey, User, error) {
+	var apiKey APIKey
+	var user User
+	tx, err := lib.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return apiKey, user, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	apiKey, err = scanAPIKey(tx.QueryRowContext(ctx, "select "+apiKeyColumns+" from api_keys k join users u on k.user_id=u.id where k.key_hash=?", hashToken(key)))
+	if errors.Cause(err) == sql.ErrNoRows {
+		return apiKey, user, ErrAPIKeyNotFound
+	} else if err != nil {
+		return apiKey, user, err
+	}
+	if !apiKey.Expires.IsZero() && !time.Now().Before(apiKey.Expires) {
+		return apiKey, user, ErrAPIKeyExpired
+	}
+	if user, _, err = getUser(ctx, tx, apiKey.User); err != nil {
+		return apiKey, user, err
+	}
+	tx.Rollback()
+
+	// Only update the time once a minute, so that a busy client doesn't write to the library on every call.
+	if _, err := lib.ExecContext(ctx, `update api_keys set last_used_on=datetime()
+	where id=? and (last_used_on is null or last_used_on < datetime('now', '-1 minute'))`, apiKey.ID); err != nil {
+		return apiKey, user, errors.Wrap(err, "update API key")
+	}
+	return apiKey, user, nil
+}
+
+// scanAPIKey scans the columns in apiKeyColumns from row.
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (APIKey, error) {
+	var k APIKey
+	var scopes string
+	var expires, lastUsed sql.NullTime
+	if err := row.Scan(&k.ID, &k.Name, &k.User, &scopes, &expires, &k.Created, &lastUsed); err != nil {
+		return k, errors.Wrap(err, "scan API key")
+	}
+	for _, s := range strings.Split(scopes, ",") {
+		scope, err := ParseScope(s)
+		if err != nil {
+			return k, errors.Wrapf(err, "scopes of API key %s", k.Name)
+		}
+		k.Scopes = append(k.Scopes, scope)
+	}
+	k.Expires = expires.Time
+	k.LastUsed = lastUsed.Time
+	return k, nil
+}
@@ -0,0 +1,128 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// backupRetryDelay is how long Backup waits before retrying when the library is locked.
+const backupRetryDelay = 100 * time.Millisecond
+
+// Backup writes a consistent snapshot of the library database to dest, using SQLite's online backup API.
+// The library may be written to by other connections or processes while the backup runs.
+// dest must not already exist.
+func (lib *Library) Backup(dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return errors.Errorf("%s already exists", dest)
+	}
+	ctx := context.Background()
+	srcConn, err := lib.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get library connection")
+	}
+	defer srcConn.Close()
+
+	destDB, err := sql.Open("sqlite3", sqliteURI(dest, nil))
+	if err != nil {
+		return errors.Wrap(err, "open backup database")
+	}
+	defer destDB.Close()
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get backup connection")
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			d, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			s, ok2 := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("backup requires SQLite connections")
+			}
+			b, err := d.Backup("main", s, "main")
+			if err != nil {
+				return errors.Wrap(err, "start backup")
+			}
+			for {
+				// Copy every page in one step, so the snapshot isn't restarted by writes to the library.
+				done, err := b.Step(-1)
+				if err != nil {
+					b.Finish()
+					return errors.Wrap(err, "back up pages")
+				}
+				if done {
+					break
+				}
+				time.Sleep(backupRetryDelay)
+			}
+			return errors.Wrap(b.Finish(), "finish backup")
+		})
+	})
+}
+
+// BlobPaths gets the path of every file stored in the books root, relative to it.
+// This includes the files of books, and files in the unsorted staging area.
+func (lib *Library) BlobPaths() ([]string, error) {
+	rows, err := lib.Query("select distinct hash from files order by hash")
+	if err != nil {
+		return nil, errors.Wrap(err, "query hashes")
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		bf := BookFile{}
+		if err := rows.Scan(&bf.Hash); err != nil {
+			return nil, errors.Wrap(err, "scan hash")
+		}
+		paths = append(paths, bf.HashPath())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "get hashes")
+	}
+
+	unsorted, err := lib.GetUnsortedFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, uf := range unsorted {
+		paths = append(paths, filepath.ToSlash(uf.StagedPath()))
+	}
+	return paths, nil
+}
+
+// CopyBlobs copies the files at paths, relative to the books root, to the same paths relative to destRoot.
+// Files which already exist in destRoot with the same size are skipped, since files in the books root are named by their hash.
+// The number of files copied is returned.
+func (lib *Library) CopyBlobs(paths []string, destRoot string) (int, error) {
+	copied := 0
+	for _, p := range paths {
+		src := filepath.Join(lib.booksRoot, filepath.FromSlash(p))
+		dst := filepath.Join(destRoot, filepath.FromSlash(p))
+		srcInfo, err := os.Stat(src)
+		if err != nil {
+			return copied, errors.Wrap(err, "stat")
+		}
+		if dstInfo, err := os.Stat(dst); err == nil && dstInfo.Size() == srcInfo.Size() {
+			continue
+		}
+		if err := moveOrCopyFile(src, dst+".tmp", false); err != nil {
+			return copied, errors.Wrapf(err, "copy %s", p)
+		}
+		if err := os.Rename(dst+".tmp", dst); err != nil {
+			return copied, errors.Wrap(err, "rename temporary file")
+		}
+		copied++
+	}
+	return copied, nil
+}
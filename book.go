@@ -12,7 +12,9 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -22,11 +24,36 @@ import (
 
 // Book represents a book in a library.
 type Book struct {
-	ID      int64
+	ID          int64
+	Authors     []string
+	Title       string
+	Series      string
+	SeriesIndex float64
+	// Identifiers maps identifier types, such as isbn, to their values.
+	Identifiers map[string]string
+	// Genres are subjects of the book as a whole, such as Fiction/Fantasy, unlike the tags on its files.
+	// The levels of a hierarchical genre are separated by GenreSeparator.
+	Genres []string
+	Files  []BookFile
+	// Added and Updated are when the book was added to the library, and when it was last changed.
+	Added, Updated time.Time
+	// Highlight shows where the book matched, if it was found by a search.
+	Highlight *Highlight
+}
+
+// HighlightStart and HighlightEnd surround the matching words in a Highlight.
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// Highlight holds the fields of a book found by a search, with matching words surrounded by HighlightStart and HighlightEnd.
+type Highlight struct {
 	Authors []string
 	Title   string
 	Series  string
-	Files   []BookFile
+	// Snippet is a few words around the best match in any field.
+	Snippet string
 }
 
 // BookFile represents a file linked to a book.
@@ -66,12 +93,21 @@ func (bf *BookFile) Filename(tmpl *template.Template, book *Book) (string, error
 }
 
 // CalculateHash calculates the hash of b.OriginalFilename and updates book.Hash.
-// If a value is stored in the user.hash xattr, that value will be used instead of hashing the file's contents.
+// If a value is stored in the user.hash xattr, that value will be used instead of hashing the file's contents,
+// as long as the user.hash.size and user.hash.mtime xattrs show the file hasn't changed since it was hashed.
+// A user.hash xattr without them can't be checked, so the file's contents are hashed instead.
 func (bf *BookFile) CalculateHash() error {
 	if data, err := xattr.Get(bf.OriginalFilename, "user.hash"); err == nil {
-		bf.Hash = string(data)
-		return nil
+		if xattrHashValid(bf.OriginalFilename) {
+			bf.Hash = string(data)
+			return nil
+		}
 	}
+	return bf.HashContents()
+}
+
+// HashContents hashes the contents of b.OriginalFilename and updates book.Hash, ignoring any stored xattrs.
+func (bf *BookFile) HashContents() error {
 	fp, err := os.Open(bf.OriginalFilename)
 	if err != nil {
 		return errors.Wrap(err, "Calculate hash")
@@ -88,6 +124,62 @@ func (bf *BookFile) CalculateHash() error {
 	return nil
 }
 
+// StoreHashXattrs stores bf.Hash in the user.hash xattr of b.OriginalFilename,
+// along with bf.FileSize and bf.FileMtime in user.hash.size and user.hash.mtime,
+// so that CalculateHash can use it the next time the file is hashed.
+// bf.FileSize and bf.FileMtime should be from before the file was hashed,
+// so that a file which changed while it was being hashed won't match them.
+// If the file's filesystem doesn't support xattrs, nothing is stored, and no error is returned.
+func (bf *BookFile) StoreHashXattrs() error {
+	fn := bf.OriginalFilename
+	if err := xattr.Set(fn, "user.hash", []byte(bf.Hash)); err != nil {
+		if xattrUnsupported(err) {
+			return nil
+		}
+		return errors.Wrap(err, "Store hash xattr")
+	}
+	if err := xattr.Set(fn, "user.hash.size", []byte(strconv.FormatInt(bf.FileSize, 10))); err != nil {
+		return errors.Wrap(err, "Store hash size xattr")
+	}
+	if err := xattr.Set(fn, "user.hash.mtime", []byte(bf.FileMtime.Format(time.RFC3339Nano))); err != nil {
+		return errors.Wrap(err, "Store hash mtime xattr")
+	}
+	return nil
+}
+
+// xattrUnsupported returns true if err is from a filesystem which doesn't support xattrs.
+func xattrUnsupported(err error) bool {
+	if xerr, ok := err.(*xattr.Error); ok {
+		err = xerr.Err
+	}
+	return err == syscall.ENOTSUP || err == syscall.EOPNOTSUPP
+}
+
+// xattrHashValid checks the size and modification time recorded alongside the user.hash xattr of fn.
+// The size is stored in user.hash.size as a decimal number of bytes,
+// and the modification time in user.hash.mtime as an RFC 3339 timestamp.
+// If either is missing, the hash can't be checked, so it isn't valid.
+func xattrHashValid(fn string) bool {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return false
+	}
+	data, err := xattr.Get(fn, "user.hash.size")
+	if err != nil {
+		return false
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || size != fi.Size() {
+		return false
+	}
+	data, err = xattr.Get(fn, "user.hash.mtime")
+	if err != nil {
+		return false
+	}
+	mtime, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	return err == nil && mtime.Equal(fi.ModTime())
+}
+
 // HashPath gets the path of a file's hash, relative to books root.
 func (bf *BookFile) HashPath() string {
 	return path.Join(bf.Hash[:2], bf.Hash[2:4], bf.Hash)
@@ -124,6 +216,11 @@ func Escape(filename string) string {
 	return newFilename
 }
 
+// FormatSeriesIndex formats a series index without trailing zeros, so 2 is formatted as 2 and 2.5 as 2.5.
+func FormatSeriesIndex(index float64) string {
+	return strconv.FormatFloat(index, 'f', -1, 64)
+}
+
 // JoinNaturally joins a slice of strings separated by a comma and space,
 // putting the conjunction before the last item.
 // If there are only two items, they will be separated by the conjunction (surrounded by spaces), with no comma.
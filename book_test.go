@@ -0,0 +1,63 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "books-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "book.txt")
+	if err := ioutil.WriteFile(fn, []byte("Dune by Frank Herbert\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bf := BookFile{OriginalFilename: fn, FileSize: fi.Size(), FileMtime: fi.ModTime()}
+	if err := bf.HashContents(); err != nil {
+		t.Fatal(err)
+	}
+	want := bf.Hash
+	// A hash that isn't the file's shows whether CalculateHash read the xattr, or the file.
+	bf.Hash = "stored"
+	if err := bf.StoreHashXattrs(); err != nil {
+		t.Fatal(err)
+	}
+	if !xattrHashValid(fn) {
+		t.Skip("xattrs aren't supported in the temporary directory")
+	}
+
+	bf.Hash = ""
+	if err := bf.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	if bf.Hash != "stored" {
+		t.Errorf("CalculateHash with valid xattrs: hash = %q, want %q", bf.Hash, "stored")
+	}
+
+	mtime := fi.ModTime().Add(time.Second)
+	if err := os.Chtimes(fn, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	bf.Hash = ""
+	if err := bf.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	if bf.Hash != want {
+		t.Errorf("CalculateHash after modification: hash = %q, want %q", bf.Hash, want)
+	}
+}
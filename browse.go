@@ -0,0 +1,154 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NameCount is an author, series or tag, with the number of books which have it.
+type NameCount struct {
+	Name string
+	// SortName is what the name is sorted by: "King, Stephen" for the author Stephen King, and the name itself for series and tags.
+	SortName string
+	Books    int
+}
+
+// GetAuthors returns every author in the library with the number of books they wrote, sorted by their sort names.
+func (lib *Library) GetAuthors() ([]NameCount, error) {
+	return lib.GetAuthorsContext(context.Background())
+}
+
+// GetAuthorsContext is like GetAuthors, but stops if ctx is canceled.
+func (lib *Library) GetAuthorsContext(ctx context.Context) ([]NameCount, error) {
+	return lib.getNameCounts(ctx, `select a.name, ifnull(a.sort_name, a.name), count(distinct ba.book_id)
+	from authors a
+	join books_authors ba on ba.author_id=a.id
+	group by a.id`)
+}
+
+// GetSeries returns every series in the library with the number of books in it, sorted by name.
+func (lib *Library) GetSeries() ([]NameCount, error) {
+	return lib.GetSeriesContext(context.Background())
+}
+
+// GetSeriesContext is like GetSeries, but stops if ctx is canceled.
+func (lib *Library) GetSeriesContext(ctx context.Context) ([]NameCount, error) {
+	return lib.getNameCounts(ctx, `select series, series, count(*)
+	from books
+	where ifnull(series, '') != ''
+	group by series`)
+}
+
+// GetTags returns every tag in the library with the number of books with a file tagged with it, sorted by name.
+func (lib *Library) GetTags() ([]NameCount, error) {
+	return lib.GetTagsContext(context.Background())
+}
+
+// GetTagsContext is like GetTags, but stops if ctx is canceled.
+func (lib *Library) GetTagsContext(ctx context.Context) ([]NameCount, error) {
+	return lib.getNameCounts(ctx, `select t.name, t.name, count(distinct f.book_id)
+	from tags t
+	join files_tags ft on ft.tag_id=t.id
+	join files f on f.id=ft.file_id
+	group by t.id`)
+}
+
+// GetGenres returns every genre in the library with the number of books in it or its subgenres, sorted by name.
+// Genres above a book's genres are included even if no book has them directly,
+// so a library with only Fiction/Fantasy books has both Fiction and Fiction/Fantasy.
+func (lib *Library) GetGenres() ([]NameCount, error) {
+	return lib.GetGenresContext(context.Background())
+}
+
+// GetGenresContext is like GetGenres, but stops if ctx is canceled.
+func (lib *Library) GetGenresContext(ctx context.Context) ([]NameCount, error) {
+	rows, err := lib.readDB.QueryContext(ctx, `select bg.book_id, g.name
+	from books_genres bg
+	join genres g on g.id=bg.genre_id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "query genres")
+	}
+	defer rows.Close()
+	// Genres are compared case insensitively, so the books in each are keyed by the lowercase name.
+	bookIDs := make(map[string]map[int64]bool)
+	names := make(map[string]string)
+	for rows.Next() {
+		var id int64
+		var genre string
+		if err := rows.Scan(&id, &genre); err != nil {
+			return nil, errors.Wrap(err, "scan genres")
+		}
+		for _, g := range genreAncestors(genre) {
+			key := strings.ToLower(g)
+			if bookIDs[key] == nil {
+				bookIDs[key] = make(map[int64]bool)
+				names[key] = g
+			}
+			bookIDs[key][id] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "query genres")
+	}
+
+	var genres []NameCount
+	var keys []string
+	for key, ids := range bookIDs {
+		genres = append(genres, NameCount{Name: names[key], SortName: names[key], Books: len(ids)})
+		keys = append(keys, FoldKey(names[key]))
+	}
+	sort.Sort(byKey{genres, keys})
+	return genres, nil
+}
+
+// getNameCounts runs query, which selects a name, sort name and count,
+// and returns the results sorted by sort name, ignoring case and diacritics.
+func (lib *Library) getNameCounts(ctx context.Context, query string) ([]NameCount, error) {
+	rows, err := lib.readDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "query names")
+	}
+	defer rows.Close()
+	var names []NameCount
+	var keys []string
+	for rows.Next() {
+		var nc NameCount
+		if err := rows.Scan(&nc.Name, &nc.SortName, &nc.Books); err != nil {
+			return nil, errors.Wrap(err, "scan names")
+		}
+		names = append(names, nc)
+		keys = append(keys, FoldKey(nc.SortName))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "query names")
+	}
+	sort.Sort(byKey{names, keys})
+	return names, nil
+}
+
+// byKey sorts names by their keys, and then by the names themselves.
+type byKey struct {
+	names []NameCount
+	keys  []string
+}
+
+func (s byKey) Len() int { return len(s.names) }
+
+func (s byKey) Less(i, j int) bool {
+	if s.keys[i] != s.keys[j] {
+		return s.keys[i] < s.keys[j]
+	}
+	return s.names[i].Name < s.names[j].Name
+}
+
+func (s byKey) Swap(i, j int) {
+	s.names[i], s.names[j] = s.names[j], s.names[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
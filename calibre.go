@@ -0,0 +1,159 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ReadCalibreLibrary reads the books in a Calibre library from its metadata.db.
+// Each book's Files hold one BookFile for every format Calibre stores,
+// with OriginalFilename set to the file's path inside the Calibre library.
+// Calibre's tags are set on every file, and each file's Source is set to calibre: followed by the book's Calibre UUID.
+// Files aren't hashed, and their sizes and modification times aren't set.
+func ReadCalibreLibrary(dir string) ([]Book, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "get absolute path of Calibre library")
+	}
+	// Open read-only, so a running Calibre isn't disturbed.
+	dsn := (&url.URL{Scheme: "file", Path: filepath.Join(dir, "metadata.db"), RawQuery: "mode=ro"}).String()
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "open Calibre database")
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`select b.id, b.title, b.path, coalesce(b.uuid, ''), coalesce(s.name, ''), coalesce(b.series_index, 0)
+	from books b
+	left join books_series_link bsl on bsl.book=b.id
+	left join series s on s.id=bsl.series
+	order by b.id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "query Calibre books")
+	}
+	var bks []Book
+	paths := make(map[int64]string)
+	sources := make(map[int64]string)
+	for rows.Next() {
+		var book Book
+		var bookPath, uuid string
+		if err := rows.Scan(&book.ID, &book.Title, &bookPath, &uuid, &book.Series, &book.SeriesIndex); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "scan Calibre book")
+		}
+		if book.Series == "" {
+			book.SeriesIndex = 0
+		}
+		paths[book.ID] = bookPath
+		sources[book.ID] = "calibre:" + uuid
+		bks = append(bks, book)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "get Calibre books")
+	}
+
+	authors, err := calibreStringsByBook(tx, "select bal.book, a.name from books_authors_link bal join authors a on a.id=bal.author order by bal.id")
+	if err != nil {
+		return nil, errors.Wrap(err, "get Calibre authors")
+	}
+	tags, err := calibreStringsByBook(tx, "select btl.book, t.name from books_tags_link btl join tags t on t.id=btl.tag order by btl.id")
+	if err != nil {
+		return nil, errors.Wrap(err, "get Calibre tags")
+	}
+
+	identifiers := make(map[int64]map[string]string)
+	rows, err = tx.Query("select book, type, val from identifiers")
+	if err != nil {
+		return nil, errors.Wrap(err, "query Calibre identifiers")
+	}
+	for rows.Next() {
+		var id int64
+		var typ, val string
+		if err := rows.Scan(&id, &typ, &val); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "scan Calibre identifier")
+		}
+		if identifiers[id] == nil {
+			identifiers[id] = make(map[string]string)
+		}
+		identifiers[id][typ] = val
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "get Calibre identifiers")
+	}
+
+	files := make(map[int64][]BookFile)
+	rows, err = tx.Query("select book, format, name from data order by id")
+	if err != nil {
+		return nil, errors.Wrap(err, "query Calibre formats")
+	}
+	for rows.Next() {
+		var id int64
+		var format, name string
+		if err := rows.Scan(&id, &format, &name); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "scan Calibre format")
+		}
+		ext := strings.ToLower(format)
+		files[id] = append(files[id], BookFile{
+			Extension:        ext,
+			OriginalFilename: filepath.Join(dir, filepath.FromSlash(paths[id]), name+"."+ext),
+			Source:           sources[id],
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "get Calibre formats")
+	}
+
+	for i := range bks {
+		id := bks[i].ID
+		// Calibre stores commas in author names as |.
+		for _, author := range authors[id] {
+			bks[i].Authors = append(bks[i].Authors, strings.Replace(author, "|", ",", -1))
+		}
+		bks[i].Identifiers = identifiers[id]
+		bks[i].Files = files[id]
+		for j := range bks[i].Files {
+			bks[i].Files[j].Tags = tags[id]
+		}
+		// Calibre's IDs mean nothing to this library.
+		bks[i].ID = 0
+	}
+	return bks, nil
+}
+
+// calibreStringsByBook runs query, which must select a Calibre book ID and a string, and groups the strings by book ID.
+func calibreStringsByBook(tx *sql.Tx, query string) (map[int64][]string, error) {
+	m := make(map[int64][]string)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var s string
+		if err := rows.Scan(&id, &s); err != nil {
+			return nil, err
+		}
+		m[id] = append(m[id], s)
+	}
+	return m, rows.Err()
+}
@@ -5,22 +5,22 @@
 package commands
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 
-	"github.com/foomo/htpasswd"
-	"github.com/howeyc/gopass"
 	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
 )
 
 // addCmd represents the add command
 var addCmd = &cobra.Command{
 	Use:   "add <username>",
-	Short: "Add an authenticated user to the web server",
-	Long: `Add an authenticated user to the web server.
+	Short: "Add a user to the web server, or change their password",
+	Long: `Add a user to the web server, or change the password of an existing user.
 
-You will be prompted to enter a new password. Passwords are encrypted with Bcrypt.`,
+You will be prompted to enter a new password. Passwords are encrypted with Bcrypt.
+New users are readers unless --role is given.
+Changing a user's password logs them out of the web server everywhere.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		addUser(cmd, args)
 	},
@@ -28,6 +28,7 @@ You will be prompted to enter a new password. Passwords are encrypted with Bcryp
 
 func init() {
 	authCmd.AddCommand(addCmd)
+	addCmd.Flags().String("role", "reader", "The user's role: reader, editor or admin")
 }
 
 func addUser(cmd *cobra.Command, args []string) {
@@ -36,27 +37,44 @@ func addUser(cmd *cobra.Command, args []string) {
 		cmd.Usage()
 		os.Exit(1)
 	}
-
-	fmt.Fprintf(os.Stderr, "Password: ")
-	pass, err := gopass.GetPasswdMasked()
+	roleName, _ := cmd.Flags().GetString("role")
+	role, err := books.ParseRole(roleName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Cannot get password: %s\n", err)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
-	fmt.Fprintf(os.Stderr, "Confirm password: ")
-	confirmedPass, err := gopass.GetPasswdMasked()
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Cannot get confirmed password: %s\n", err)
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
 		os.Exit(1)
 	}
-	if !bytes.Equal(pass, confirmedPass) {
-		fmt.Fprintln(os.Stderr, "Passwords do not match")
+	defer lib.Close()
+
+	pass, err := readNewPassword()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 
-	if err := htpasswd.SetPassword(htpasswdFile, args[0], string(pass), htpasswd.HashBCrypt); err != nil {
+	_, err = lib.AddUser(args[0], pass, role)
+	if err == nil {
+		fmt.Printf("Added %s as %s\n", args[0], role)
+		return
+	}
+	if err != books.ErrUserExists {
+		fmt.Fprintf(os.Stderr, "Cannot add user %s: %s\n", args[0], err)
+		os.Exit(1)
+	}
+	if err := lib.SetUserPassword(args[0], pass); err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot set password for user %s: %s\n", args[0], err)
 		os.Exit(1)
 	}
+	if cmd.Flags().Changed("role") {
+		if err := lib.SetUserRole(args[0], role); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot set role for user %s: %s\n", args[0], err)
+			os.Exit(1)
+		}
+	}
 	fmt.Println("Password updated")
 }
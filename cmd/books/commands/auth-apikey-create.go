@@ -0,0 +1,112 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// apikeyCreateCmd represents the auth apikey create command
+var apikeyCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Create an API key",
+	Long: `Create an API key, and print it.
+
+The key is only shown once, so store it somewhere safe.
+--expires takes a date (YYYY-MM-DD), or a number of days (90d) or hours (12h) from now.
+The read scope allows searching and downloading, update allows changing books, shelves and saved searches,
+merge allows merging books, and import allows uploading books to /api/import.
+Every scope except read also needs a user who is at least an editor.
+
+Examples:
+    books auth apikey create scripts --user alice --scope read --scope update
+    books auth apikey create opds --user bob --expires 90d`,
+	Run: CPUProfile(apikeyCreateRun),
+}
+
+func init() {
+	apikeyCmd.AddCommand(apikeyCreateCmd)
+	apikeyCreateCmd.Flags().StringP("user", "u", "", "The user the key belongs to (required)")
+	apikeyCreateCmd.Flags().StringArrayP("scope", "s", []string{"read"}, "What the key may be used for: read, update, merge or import (can be repeated)")
+	apikeyCreateCmd.Flags().StringP("expires", "e", "", "When the key expires; by default it doesn't")
+}
+
+func apikeyCreateRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "A name must be specified.")
+		os.Exit(1)
+	}
+	name := strings.Join(args, " ")
+	user, _ := cmd.Flags().GetString("user")
+	if user == "" {
+		fmt.Fprintln(os.Stderr, "A user must be specified with --user.")
+		os.Exit(1)
+	}
+	var scopes []books.Scope
+	scopeNames, _ := cmd.Flags().GetStringArray("scope")
+	for _, s := range scopeNames {
+		for _, s := range strings.Split(s, ",") {
+			scope, err := books.ParseScope(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			scopes = append(scopes, scope)
+		}
+	}
+	expiresStr, _ := cmd.Flags().GetString("expires")
+	expires, err := parseExpiry(expiresStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	_, key, err := lib.CreateAPIKey(name, user, scopes, expires)
+	if err == books.ErrUserNotFound {
+		fmt.Fprintf(os.Stderr, "There is no user named %s.\n", user)
+		os.Exit(1)
+	} else if err == books.ErrAPIKeyExists {
+		fmt.Fprintf(os.Stderr, "There is already an API key named %s.\n", name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating API key: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(key)
+}
+
+// parseExpiry parses when an API key expires: a date, or a number of days or hours from now.
+// An empty string is the zero time, meaning the key doesn't expire.
+func parseExpiry(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && days > 0 {
+			return time.Now().AddDate(0, 0, days), nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return time.Now().Add(d), nil
+	}
+	return time.Time{}, errors.Errorf("Invalid expiry %s; use YYYY-MM-DD, or a number of days or hours such as 90d or 12h", s)
+}
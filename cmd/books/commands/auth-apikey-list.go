@@ -0,0 +1,66 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// apikeyListCmd represents the auth apikey list command
+var apikeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API keys",
+	Long:  `List API keys, with their users, scopes, and when they expire and were last used.`,
+	Run:   CPUProfile(apikeyListRun),
+}
+
+func init() {
+	apikeyCmd.AddCommand(apikeyListCmd)
+}
+
+func apikeyListRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	keys, err := lib.GetAPIKeys()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting API keys: %s\n", err)
+		os.Exit(1)
+	}
+	if len(keys) == 0 {
+		fmt.Println("No API keys.")
+		return
+	}
+	const timeFormat = "2006-01-02 15:04"
+	for _, k := range keys {
+		scopes := make([]string, len(k.Scopes))
+		for i, s := range k.Scopes {
+			scopes[i] = string(s)
+		}
+		fmt.Printf("%s: user %s, scopes %s, created %s", k.Name, k.User, strings.Join(scopes, ", "), k.Created.Local().Format(timeFormat))
+		if k.Expires.IsZero() {
+			fmt.Print(", never expires")
+		} else if k.Expires.After(time.Now()) {
+			fmt.Printf(", expires %s", k.Expires.Local().Format(timeFormat))
+		} else {
+			fmt.Printf(", expired %s", k.Expires.Local().Format(timeFormat))
+		}
+		if k.LastUsed.IsZero() {
+			fmt.Println(", never used")
+		} else {
+			fmt.Printf(", last used %s\n", k.LastUsed.Local().Format(timeFormat))
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// apikeyRevokeCmd represents the auth apikey revoke command
+var apikeyRevokeCmd = &cobra.Command{
+	Use:   "revoke NAME",
+	Short: "Revoke an API key",
+	Long:  `Revoke an API key, so it can't be used any more.`,
+	Run:   CPUProfile(apikeyRevokeRun),
+}
+
+func init() {
+	apikeyCmd.AddCommand(apikeyRevokeCmd)
+}
+
+func apikeyRevokeRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "A name must be specified.")
+		os.Exit(1)
+	}
+	name := strings.Join(args, " ")
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	err = lib.RevokeAPIKey(name)
+	if err == books.ErrAPIKeyNotFound {
+		fmt.Fprintf(os.Stderr, "There is no API key named %s.\n", name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error revoking API key: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// apikeyCmd represents the auth apikey command
+var apikeyCmd = &cobra.Command{
+	Use:     "apikey",
+	Aliases: []string{"apikeys"},
+	Short:   "Manage API keys",
+	Long: `Create, list and revoke keys for calling the web server's API.
+
+Each key belongs to a user, and is sent in the X-API-Key header. It has one or more scopes:
+    read: search for, browse and download books
+    update: change books, shelves and saved searches
+    merge: merge books
+    import: import books
+A key can only be used for what both its scopes and its user's role allow; update, merge and import need an editor.
+
+Keys are stored hashed, so they're only shown when they're created.`,
+}
+
+func init() {
+	authCmd.AddCommand(apikeyCmd)
+}
@@ -0,0 +1,77 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/foomo/htpasswd"
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// importHtpasswdCmd represents the import-htpasswd command
+var importHtpasswdCmd = &cobra.Command{
+	Use:   "import-htpasswd",
+	Short: "Import users from the htpasswd file",
+	Long: `Import the users in lib_root/htpasswd into the library, keeping their passwords.
+
+Only passwords encrypted with Bcrypt can be imported; other users must be added again.
+Users who already exist in the library are skipped.
+Once the library has users, the htpasswd file is no longer used, and can be deleted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		importHtpasswd(cmd, args)
+	},
+}
+
+func init() {
+	authCmd.AddCommand(importHtpasswdCmd)
+	importHtpasswdCmd.Flags().String("role", "admin", "The role of the imported users: reader, editor or admin")
+}
+
+func importHtpasswd(cmd *cobra.Command, args []string) {
+	roleName, _ := cmd.Flags().GetString("role")
+	role, err := books.ParseRole(roleName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	passwords, err := htpasswd.ParseHtpasswdFile(htpasswdFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read users: %s\n", err)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	var names []string
+	for name := range passwords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	failed := false
+	for _, name := range names {
+		_, err := lib.AddUserWithHash(name, passwords[name], role)
+		if err == books.ErrUserExists {
+			fmt.Printf("Skipped %s, who already exists\n", name)
+			continue
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot import user %s: %s\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Imported %s as %s\n", name, role)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
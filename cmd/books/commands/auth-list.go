@@ -8,14 +8,14 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/foomo/htpasswd"
 	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
 )
 
 // listCmd represents the list command
 var listCmd = &cobra.Command{
 	Use:   "list",
-	Short: "List authenticated users for the web server",
+	Short: "List users of the web server, with their roles",
 	Run: func(cmd *cobra.Command, args []string) {
 		listUsers(cmd, args)
 	},
@@ -26,17 +26,27 @@ func init() {
 }
 
 func listUsers(cmd *cobra.Command, args []string) {
-	if _, err := os.Stat(htpasswdFile); os.IsNotExist(err) {
-		fmt.Fprintln(os.Stderr, "Authentication is disabled. Add a user to enable.")
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
 		os.Exit(1)
 	}
+	defer lib.Close()
 
-	passwords, err := htpasswd.ParseHtpasswdFile(htpasswdFile)
+	users, err := lib.GetUsers()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot list users: %s\n", err)
 		os.Exit(1)
 	}
-	for user := range passwords {
-		fmt.Println(user)
+	if len(users) == 0 {
+		if _, err := os.Stat(htpasswdFile); err == nil {
+			fmt.Fprintln(os.Stderr, "No users. The server is using the htpasswd file; use import-htpasswd to import its users.")
+		} else {
+			fmt.Fprintln(os.Stderr, "Authentication is disabled. Add a user to enable.")
+		}
+		os.Exit(1)
+	}
+	for _, u := range users {
+		fmt.Printf("%s (%s)\n", u.Name, u.Role)
 	}
 }
@@ -8,17 +8,17 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/foomo/htpasswd"
 	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
 )
 
 // removeCmd represents the remove command
 var removeCmd = &cobra.Command{
 	Use:   "remove <username>",
-	Short: "Remove an authenticated user from the web server",
-	Long: `Remove an authenticated user from the web server.
-When the last user is removed, authentication will still be enabled. This means that nobody can access the server until a user is added.
-This is to prevent someone from accidentally making a library public by removing a user.`,
+	Short: "Remove a user from the web server",
+	Long: `Remove a user from the web server, logging them out.
+The last user can't be removed without --force, since without users authentication is disabled,
+unless there's an htpasswd file. This is to prevent someone from accidentally making a library public by removing a user.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		removeUser(cmd, args)
 	},
@@ -26,6 +26,7 @@ This is to prevent someone from accidentally making a library public by removing
 
 func init() {
 	authCmd.AddCommand(removeCmd)
+	removeCmd.Flags().Bool("force", false, "Remove the last user, disabling authentication")
 }
 
 func removeUser(cmd *cobra.Command, args []string) {
@@ -35,12 +36,28 @@ func removeUser(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	if _, err := os.Stat(htpasswdFile); os.IsNotExist(err) {
-		fmt.Fprintln(os.Stderr, "Authentication is disabled. Add a user to enable.")
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
 		os.Exit(1)
 	}
+	defer lib.Close()
 
-	if err := htpasswd.RemoveUser(htpasswdFile, args[0]); err != nil {
+	users, err := lib.GetUsers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot remove user %s: %s\n", args[0], err)
+		os.Exit(1)
+	}
+	if force, _ := cmd.Flags().GetBool("force"); len(users) == 1 && !force {
+		fmt.Fprintln(os.Stderr, "This is the last user. Removing them would disable authentication; use --force to do it anyway.")
+		os.Exit(1)
+	}
+
+	err = lib.RemoveUser(args[0])
+	if err == books.ErrUserNotFound {
+		fmt.Fprintf(os.Stderr, "There is no user named %s.\n", args[0])
+		os.Exit(1)
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot remove user %s: %s\n", args[0], err)
 		os.Exit(1)
 	}
@@ -0,0 +1,56 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// roleCmd represents the role command
+var roleCmd = &cobra.Command{
+	Use:   "role <username> <role>",
+	Short: "Change a user's role",
+	Long:  `Change a user's role to reader, editor or admin.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setRole(cmd, args)
+	},
+}
+
+func init() {
+	authCmd.AddCommand(roleCmd)
+}
+
+func setRole(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "A username and role must be specified")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	role, err := books.ParseRole(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	err = lib.SetUserRole(args[0], role)
+	if err == books.ErrUserNotFound {
+		fmt.Fprintf(os.Stderr, "There is no user named %s.\n", args[0])
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot set role for user %s: %s\n", args[0], err)
+		os.Exit(1)
+	}
+}
@@ -5,19 +5,51 @@
 package commands
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/howeyc/gopass"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
 // authCmd represents the auth command
 var authCmd = &cobra.Command{
 	Use:   "auth",
-	Short: "Manage basic authentication for the web server",
-	Long: `Add, remove, and list authenticated users.
+	Short: "Manage users of the web server",
+	Long: `Add, remove, and list users of the web server, and change their roles.
+
+Users are stored in the library, with their passwords hashed with Bcrypt.
+They log in to the web interface from its login page, and scripts and feed readers log in with basic authentication.
+Each user has a role:
+    reader: search for, browse and download books
+    editor: also change books, shelves and saved searches, and assign unsorted files
+    admin: anything
 
-Authorized users are stored in htpasswd format, in lib_root/htpasswd.
-Delete this file to disable authentication.`,
+If the library has no users, the server falls back to lib_root/htpasswd, whose users are all admins.
+Use import-htpasswd to move them into the library.
+Without either, authentication is disabled.`,
 }
 
 func init() {
 	rootCmd.AddCommand(authCmd)
 }
+
+// readNewPassword prompts for a password twice, and returns it if both match.
+func readNewPassword() (string, error) {
+	fmt.Fprintf(os.Stderr, "Password: ")
+	pass, err := gopass.GetPasswdMasked()
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot get password")
+	}
+	fmt.Fprintf(os.Stderr, "Confirm password: ")
+	confirmedPass, err := gopass.GetPasswdMasked()
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot get confirmed password")
+	}
+	if !bytes.Equal(pass, confirmedPass) {
+		return "", errors.New("Passwords do not match")
+	}
+	return string(pass), nil
+}
@@ -0,0 +1,178 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+var backupManifest bool
+var backupCopyFiles bool
+var backupKeep int
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup <dest>",
+	Short: "Back up the library",
+	Long: `Write a consistent snapshot of the library database to the directory dest.
+
+The snapshot is taken with SQLite's online backup API, so it is safe to run while books serve or books import are writing to the library.
+Each snapshot is named books-YYYYMMDD-HHMMSS.NNNNNNNNN.db, using the current UTC time to the nanosecond.
+
+With --manifest, the path of every file in the books root referenced by the snapshot is written to a .manifest file next to it,
+one per line, suitable for rsync --files-from:
+    rsync -a --files-from=dest/books-YYYYMMDD-HHMMSS.NNNNNNNNN.manifest /path/to/books/root/ remote:books/
+With --copy-files, those files are copied into dest/files instead, skipping files already copied by earlier backups.
+
+With --keep N, only the newest N snapshots (and their manifests) are kept,
+including snapshots named books-YYYYMMDD-HHMMSS.db by earlier versions.`,
+	Run: CPUProfile(backupFunc),
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+
+	backupCmd.Flags().BoolVarP(&backupManifest, "manifest", "m", false, "Write a manifest of the files in the books root")
+	backupCmd.Flags().BoolVarP(&backupCopyFiles, "copy-files", "c", false, "Copy the files in the books root into dest/files")
+	backupCmd.Flags().IntVarP(&backupKeep, "keep", "k", 0, "Number of snapshots to keep, or 0 to keep them all")
+}
+
+func backupFunc(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Specify one destination directory.")
+		os.Exit(1)
+	}
+	if backupKeep < 0 {
+		fmt.Fprintln(os.Stderr, "--keep must not be negative.")
+		os.Exit(1)
+	}
+	destDir := args[0]
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create %s: %s\n", destDir, err)
+		os.Exit(1)
+	}
+
+	library, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer library.Close()
+
+	// Nanoseconds keep the names of backups taken in the same second, such as by cron and by hand, apart.
+	name := "books-" + time.Now().UTC().Format(snapshotTimeFormat+".000000000")
+	snapshotFile := filepath.Join(destDir, name+".db")
+	if err := library.Backup(snapshotFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error backing up library: %s\n", err)
+		os.Exit(1)
+	}
+	log.Printf("Backed up library to %s", snapshotFile)
+
+	if backupManifest || backupCopyFiles {
+		// Read file paths from the snapshot, so they match it even if the library has changed since.
+		// It's opened read-only, so it's never modified after it's taken.
+		snapshot, err := books.OpenLibraryReadOnly(snapshotFile, booksRoot)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot open snapshot: %s\n", err)
+			os.Exit(1)
+		}
+		paths, err := snapshot.BlobPaths()
+		if err == nil && backupManifest {
+			err = writeManifest(filepath.Join(destDir, name+".manifest"), paths)
+		}
+		if err == nil && backupCopyFiles {
+			var copied int
+			copied, err = snapshot.CopyBlobs(paths, filepath.Join(destDir, "files"))
+			log.Printf("Copied %d of %d files", copied, len(paths))
+		}
+		snapshot.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error backing up files: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if backupKeep > 0 {
+		if err := rotateBackups(destDir, backupKeep); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing old backups: %s\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// writeManifest writes one path per line to fn.
+func writeManifest(fn string, paths []string) error {
+	fp, err := os.Create(fn)
+	if err != nil {
+		return errors.Wrap(err, "create manifest")
+	}
+	w := bufio.NewWriter(fp)
+	for _, p := range paths {
+		w.WriteString(p + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		fp.Close()
+		return errors.Wrap(err, "write manifest")
+	}
+	return fp.Close()
+}
+
+// snapshotTimeFormat is the format of the time in snapshot names, after books-.
+// Names also have nanoseconds, but older ones don't, and time.Parse accepts both.
+const snapshotTimeFormat = "20060102-150405"
+
+// snapshotTime returns the time a snapshot was taken, from its filename.
+// ok is false if fn isn't the name of a snapshot.
+func snapshotTime(fn string) (t time.Time, ok bool) {
+	base := filepath.Base(fn)
+	if !strings.HasPrefix(base, "books-") || !strings.HasSuffix(base, ".db") {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(strings.TrimPrefix(base, "books-"), ".db"))
+	return t, err == nil
+}
+
+// rotateBackups removes all but the newest keep snapshots in dir, along with their manifests.
+// Files whose names don't have the time a snapshot was taken are left alone.
+func rotateBackups(dir string, keep int) error {
+	matches, err := filepath.Glob(filepath.Join(dir, "books-*.db"))
+	if err != nil {
+		return err
+	}
+	var snapshots []string
+	times := make(map[string]time.Time)
+	for _, fn := range matches {
+		if t, ok := snapshotTime(fn); ok {
+			snapshots = append(snapshots, fn)
+			times[fn] = t
+		}
+	}
+	if len(snapshots) <= keep {
+		return nil
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return times[snapshots[i]].Before(times[snapshots[j]]) })
+	for _, fn := range snapshots[:len(snapshots)-keep] {
+		if err := os.Remove(fn); err != nil {
+			return err
+		}
+		manifest := strings.TrimSuffix(fn, ".db") + ".manifest"
+		if err := os.Remove(manifest); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Printf("Removed old backup %s", fn)
+	}
+	return nil
+}
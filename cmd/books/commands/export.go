@@ -0,0 +1,84 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+var exportFormat string
+var exportOutput string
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the library's metadata",
+	Long: `Export every book, author, series, file and tag in the library as JSON or CSV,
+along with when each book was added and last changed.
+JSON dumps also include shelves and saved searches, which CSV dumps leave out.
+The dump is a consistent snapshot, even while the library is being changed.
+
+Files are identified by their hash, size, modification time and original filename; their contents aren't exported.
+Together with the books root, a dump can rebuild the library with books restore,
+and doesn't depend on the library's database schema.`,
+	Run: CPUProfile(exportFunc),
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "json", "Format of the dump: json or csv")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "File to write the dump to (default is standard output)")
+}
+
+func exportFunc(cmd *cobra.Command, args []string) {
+	if exportFormat != "json" && exportFormat != "csv" {
+		fmt.Fprintf(os.Stderr, "Unknown format %s. Use json or csv.\n", exportFormat)
+		os.Exit(1)
+	}
+
+	library, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer library.Close()
+
+	var out io.Writer = os.Stdout
+	var fp *os.File
+	if exportOutput != "" {
+		fp, err = os.Create(exportOutput)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create %s: %s\n", exportOutput, err)
+			os.Exit(1)
+		}
+		out = fp
+	}
+	w := bufio.NewWriter(out)
+
+	if exportFormat == "csv" {
+		err = library.ExportCSV(w)
+	} else {
+		err = library.ExportJSON(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if fp != nil {
+		if closeErr := fp.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting library: %s\n", err)
+		os.Exit(1)
+	}
+}
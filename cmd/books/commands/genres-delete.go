@@ -0,0 +1,51 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// genresDeleteCmd represents the genres delete command
+var genresDeleteCmd = &cobra.Command{
+	Use:   "delete GENRE",
+	Short: "Delete a genre",
+	Long:  `Remove a genre, and the genres under it, from every book.`,
+	Run:   CPUProfile(genresDeleteRun),
+}
+
+func init() {
+	genresCmd.AddCommand(genresDeleteCmd)
+}
+
+func genresDeleteRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "A genre must be specified.")
+		os.Exit(1)
+	}
+	name := strings.Join(args, " ")
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	n, err := lib.DeleteGenre(name)
+	if err == books.ErrGenreNotFound {
+		fmt.Fprintf(os.Stderr, "No books are in the genre %s.\n", name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error deleting genre: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Removed the genre from %d books.\n", n)
+}
@@ -0,0 +1,47 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// genresListCmd represents the genres list command
+var genresListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List genres",
+	Long:  `List every genre, with the number of books in it or the genres under it.`,
+	Run:   CPUProfile(genresListRun),
+}
+
+func init() {
+	genresCmd.AddCommand(genresListCmd)
+}
+
+func genresListRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	genres, err := lib.GetGenres()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting genres: %s\n", err)
+		os.Exit(1)
+	}
+	if len(genres) == 0 {
+		fmt.Println("No genres.")
+		return
+	}
+	for _, g := range genres {
+		fmt.Printf("%s (%d)\n", g.Name, g.Books)
+	}
+}
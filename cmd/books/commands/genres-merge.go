@@ -0,0 +1,49 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// genresMergeCmd represents the genres merge command
+var genresMergeCmd = &cobra.Command{
+	Use:   "merge INTO GENRE...",
+	Short: "Merge genres",
+	Long:  `Merges two or more genres, and the genres under them, into the first one specified.`,
+	Run:   CPUProfile(genresMergeRun),
+}
+
+func init() {
+	genresCmd.AddCommand(genresMergeCmd)
+}
+
+func genresMergeRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "At least two genres must be specified.")
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	n, err := lib.MergeGenres(args[1:], args[0])
+	if err == books.ErrGenreNotFound {
+		fmt.Fprintln(os.Stderr, "None of the genres to merge have any books.")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error merging genres: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Moved %d books to %s.\n", n, books.NormalizeGenre(args[0]))
+}
@@ -0,0 +1,52 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// genresRenameCmd represents the genres rename command
+var genresRenameCmd = &cobra.Command{
+	Use:   "rename OLD NEW",
+	Short: "Rename a genre",
+	Long: `Rename a genre, and the genres under it.
+Renaming Fiction to Novels also renames Fiction/Fantasy to Novels/Fantasy.
+
+If a genre named NEW already exists, the two are merged.`,
+	Run: CPUProfile(genresRenameRun),
+}
+
+func init() {
+	genresCmd.AddCommand(genresRenameCmd)
+}
+
+func genresRenameRun(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "The old and new genre names must be specified.")
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	n, err := lib.RenameGenre(args[0], args[1])
+	if err == books.ErrGenreNotFound {
+		fmt.Fprintf(os.Stderr, "No books are in the genre %s.\n", args[0])
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error renaming genre: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Renamed the genre for %d books.\n", n)
+}
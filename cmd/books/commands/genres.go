@@ -0,0 +1,27 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// genresCmd represents the genres command
+var genresCmd = &cobra.Command{
+	Use:     "genres",
+	Aliases: []string{"tags"},
+	Short:   "List and manage genres",
+	Long: `List, rename, merge and delete genres.
+
+Genres, also called book tags, describe what a book is about, such as Fiction/Fantasy/Epic.
+They belong to the book as a whole, unlike file tags, which describe a file, such as retail or v5.0.
+The command is named genres so that its book tags aren't confused with file tags, and books tags is an alias for it.
+Levels of a genre are separated by /, and renaming, merging or deleting a genre does the same to the genres under it.
+A book's genres can be set with the genres command of books edit.`,
+}
+
+func init() {
+	rootCmd.AddCommand(genresCmd)
+}
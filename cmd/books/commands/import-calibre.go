@@ -0,0 +1,167 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+var calibreDryRun bool
+
+// importCalibreCmd represents the import-calibre command
+var importCalibreCmd = &cobra.Command{
+	Use:   "import-calibre <path to Calibre library>",
+	Short: "Import books from a Calibre library",
+	Long: `Import every book in a Calibre library, reading its metadata.db directly.
+
+Each format of a Calibre book is imported as a file of one book,
+keeping its authors, title, series, series index, tags and identifiers.
+Calibre's tags are applied to every file, and each file's source is set to calibre:<uuid>,
+where uuid is the Calibre book's UUID.
+
+The Calibre library is only read from; files are always copied.
+Duplicates and --fast are handled as with books import. Use --dry-run to see what would be imported.
+A dry run doesn't change the library at all, so the library must already exist, and be up to date with this version of books.`,
+	Run: CPUProfile(importCalibreFunc),
+}
+
+func init() {
+	rootCmd.AddCommand(importCalibreCmd)
+
+	importCalibreCmd.Flags().BoolVarP(&calibreDryRun, "dry-run", "n", false, "List the books which would be imported, without importing them")
+	importCalibreCmd.Flags().String("duplicates", "import", "What to do with files whose hash belongs to a different book: import, skip, or attach")
+	importCalibreCmd.Flags().BoolVar(&importFast, "fast", false, "Don't sync the library to disk after each change")
+	importCalibreCmd.Flags().BoolVar(&rehash, "rehash", false, "Hash every file's contents, ignoring cached hashes and the user.hash xattr")
+}
+
+func importCalibreFunc(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Specify the path to one Calibre library.")
+		os.Exit(1)
+	}
+
+	policyName := viper.GetString("duplicate_policy")
+	if cmd.Flags().Changed("duplicates") {
+		policyName, _ = cmd.Flags().GetString("duplicates")
+	}
+	var err error
+	duplicatePolicy, err = books.ParseDuplicatePolicy(policyName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err = template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+
+	calibreBooks, err := books.ReadCalibreLibrary(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read Calibre library: %s\n", err)
+		os.Exit(1)
+	}
+
+	if calibreDryRun {
+		// A dry run doesn't change the library, not even by migrating it.
+		library, err := books.OpenLibraryQueryOnly(libraryFile, booksRoot, importOptions())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening Library: %s\n", err)
+			os.Exit(1)
+		}
+		defer library.Close()
+		for _, book := range calibreBooks {
+			if err := printCalibreBook(library, book); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	library, err := books.OpenLibrary(libraryFile, booksRoot, importOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening Library: %s\n", err)
+		os.Exit(1)
+	}
+	defer library.Close()
+
+	for _, book := range calibreBooks {
+		for _, bf := range book.Files {
+			log.Printf("Importing file %s:\n", bf.OriginalFilename)
+			if err := importCalibreFile(library, book, bf); err != nil {
+				summary.failed++
+				log.Printf("Cannot import book from %s: %s; skipping\n", bf.OriginalFilename, err)
+			}
+		}
+	}
+	printImportSummary()
+}
+
+// printCalibreBook prints the book which would be imported, and whether it would be merged into an existing book.
+func printCalibreBook(library *books.Library, book books.Book) error {
+	var formats []string
+	for _, bf := range book.Files {
+		formats = append(formats, bf.Extension)
+	}
+	fmt.Printf("%s - %s", books.JoinNaturally("and", book.Authors), book.Title)
+	if book.Series != "" {
+		fmt.Printf(" [%s #%s]", book.Series, books.FormatSeriesIndex(book.SeriesIndex))
+	}
+	fmt.Printf(" (%s)", strings.Join(formats, ", "))
+	id, found, err := library.GetBookIDByTitleAndAuthors(book.Title, book.Authors)
+	if err != nil {
+		return errors.Wrap(err, "find existing book")
+	}
+	if found {
+		fmt.Printf(": merge into book %d", id)
+	}
+	fmt.Println()
+	return nil
+}
+
+// importCalibreFile imports one format of a Calibre book.
+func importCalibreFile(library *books.Library, book books.Book, bf books.BookFile) error {
+	fi, err := os.Stat(bf.OriginalFilename)
+	if err != nil {
+		return errors.Wrap(err, "Get file info for book")
+	}
+	hash, unchanged, err := cachedHash(library, bf.OriginalFilename, fi)
+	if err != nil {
+		return err
+	}
+	if unchanged {
+		summary.unchanged++
+		log.Printf("Skipping unchanged file %s, already in library", bf.OriginalFilename)
+		return nil
+	}
+	bf.Hash = hash
+	bf.FileSize = fi.Size()
+	bf.FileMtime = fi.ModTime()
+	if bf.Hash == "" {
+		if err := hashFile(library, &bf, bf.OriginalFilename); err != nil {
+			return err
+		}
+	}
+
+	book.Files = []books.BookFile{bf}
+	result, err := library.ImportBook(book, outputTmpl, false, duplicatePolicy)
+	if err != nil {
+		return errors.Wrap(err, "Import book into library")
+	}
+	recordImportResult(bf.OriginalFilename, result)
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -29,6 +30,39 @@ var recursive bool
 var metadataParsers []string
 var metadataParserMap map[string]books.MetadataParser
 var tagsRegexp = regexp.MustCompile(`^(.*)\(([^)]+)\)\s*$`)
+var importSource string
+var importTags []string
+var rehash bool
+var importFast bool
+var sourceRules []sourceRule
+var tagRules []tagRule
+var duplicatePolicy books.DuplicatePolicy
+var summary importSummary
+
+// importSummary counts what happened to each file during an import.
+type importSummary struct {
+	imported, duplicates, unchanged, skipped, attached, unsorted, failed int
+	collisions                                                           []hashCollision
+}
+
+// hashCollision records a file whose hash was already in the library under a different book.
+type hashCollision struct {
+	filename string
+	bookIDs  []int64
+}
+
+// sourceRule maps files whose absolute path starts with Prefix to Source.
+type sourceRule struct {
+	Prefix string
+	Source string
+}
+
+// tagRule turns the directory components of files below Prefix into tags.
+// If Depth is greater than 0, only that many components, starting from Prefix, are used.
+type tagRule struct {
+	Prefix string
+	Depth  int
+}
 
 // importCmd represents the import command
 var importCmd = &cobra.Command{
@@ -43,7 +77,39 @@ but the books in its subdirectories will not be, unless --recursive is set.
 Each file will be matched against the list of regular expressions in order, and will be imported according to the first match.
 The following named groups will be recognized: author, series, title, and ext.
 Your files will be named according to the output template in the config file,
-or the template override set in the library.`,
+or the template override set in the library.
+
+Each file may be labeled with a source, recording where it came from.
+If --source is not given, the source_rules in the config file are checked,
+and the rule with the longest prefix matching the file's absolute path is used.
+
+Tags are taken from the filename in the form "title (tag1) (tag2).ext",
+from the directories below any matching tag_rules prefix in the config file
+(Fiction/Fantasy/book.epub gives fiction and fantasy),
+and from each --tag flag, in that order.
+
+The size, modification time and hash of each file are cached in the library,
+and files which haven't changed since their hash was cached are skipped without being read
+if a file with that hash is already in the library. Use --rehash to ignore the cache.
+Skipped files are treated like any other duplicate: they're deleted with --move,
+and their tags and source aren't added to the library.
+With --duplicates attach, they're imported as usual, but still aren't read.
+Hashes are also stored in each file's user.hash xattr, with its size and modification time
+(as an RFC 3339 timestamp) in the user.hash.size and user.hash.mtime xattrs.
+A hash in a file's user.hash xattr is only used if those match the file's size and modification time,
+so files which are moved, or copied with their xattrs, don't need to be read again.
+
+If a file has the same hash as a file in a book with a different title or authors,
+--duplicates (or duplicate_policy in the config file) decides what happens:
+import imports it as a new book anyway, skip doesn't import it,
+and attach adds its tags to the existing file.
+Every such collision is listed in the summary printed after the import.
+
+With --fast, the library isn't synced to disk after each change.
+This speeds up large imports, but changes could be lost during a power outage or sudden OS crash.
+
+Files which no metadata parser matches are copied (or moved) into the unsorted staging area.
+Use books unsorted to list them and assign their metadata.`,
 	Run: CPUProfile(importFunc),
 }
 
@@ -53,8 +119,14 @@ func init() {
 	importCmd.Flags().StringSliceP("metadata-parsers", "p", []string{}, "List of metadata parsers to use during import")
 	importCmd.Flags().StringSliceP("regexp", "r", []string{"regexp"}, "List of regular expressions to use during import")
 	importCmd.Flags().BoolP("move", "m", false, "Move files instead of copying them")
+	importCmd.Flags().String("duplicates", "import", "What to do with files whose hash belongs to a different book: import, skip, or attach")
 	importCmd.Flags().BoolVarP(&recursive, "recursive", "R", false, "Recurse into subdirectories")
+	importCmd.Flags().StringVarP(&importSource, "source", "s", "", "Source label to record for each imported file, except duplicates")
+	importCmd.Flags().BoolVar(&rehash, "rehash", false, "Hash every file's contents, ignoring cached hashes and the user.hash xattr")
+	importCmd.Flags().BoolVar(&importFast, "fast", false, "Don't sync the library to disk after each change")
+	importCmd.Flags().StringArrayVarP(&importTags, "tag", "t", []string{}, "Tag to apply to each imported file, except duplicates (can be repeated)")
 	viper.BindPFlag("move", importCmd.Flags().Lookup("move"))
+	viper.BindPFlag("duplicate_policy", importCmd.Flags().Lookup("duplicates"))
 	viper.BindPFlag("default_metadata_parsers", importCmd.Flags().Lookup("metadata-parsers"))
 	viper.BindPFlag("default_regexps", importCmd.Flags().Lookup("regexp"))
 }
@@ -105,15 +177,46 @@ func importFunc(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 	log.Printf("Using metadata parsers: %v\n", metadataParsers)
-	outputTmplSrc := viper.GetString("output_template")
+	if err := viper.UnmarshalKey("source_rules", &sourceRules); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse source_rules: %s\n", err)
+		os.Exit(1)
+	}
+	for i := range sourceRules {
+		if sourceRules[i].Prefix == "" || sourceRules[i].Source == "" {
+			fmt.Fprintf(os.Stderr, "Each source rule must have a prefix and a source.\n")
+			os.Exit(1)
+		}
+		if abs, err := filepath.Abs(sourceRules[i].Prefix); err == nil {
+			sourceRules[i].Prefix = abs
+		}
+	}
+	if err := viper.UnmarshalKey("tag_rules", &tagRules); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse tag_rules: %s\n", err)
+		os.Exit(1)
+	}
+	for i := range tagRules {
+		if tagRules[i].Prefix == "" {
+			fmt.Fprintf(os.Stderr, "Each tag rule must have a prefix.\n")
+			os.Exit(1)
+		}
+		if abs, err := filepath.Abs(tagRules[i].Prefix); err == nil {
+			tagRules[i].Prefix = abs
+		}
+	}
 	var err error
+	duplicatePolicy, err = books.ParseDuplicatePolicy(viper.GetString("duplicate_policy"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	outputTmplSrc := viper.GetString("output_template")
 	outputTmpl, err = template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
 		os.Exit(1)
 	}
 
-	library, err := books.OpenLibrary(libraryFile, booksRoot)
+	library, err := books.OpenLibrary(libraryFile, booksRoot, importOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening Library: %s\n", err)
 		os.Exit(1)
@@ -126,6 +229,24 @@ func importFunc(cmd *cobra.Command, args []string) {
 			continue
 		}
 	}
+	printImportSummary()
+}
+
+// printImportSummary prints the number of files in each outcome, and lists any hash collisions.
+func printImportSummary() {
+	fmt.Printf("Imported: %d, duplicates: %d, unchanged: %d, skipped: %d, attached: %d, unsorted: %d, failed: %d\n",
+		summary.imported, summary.duplicates, summary.unchanged, summary.skipped, summary.attached, summary.unsorted, summary.failed)
+	if len(summary.collisions) == 0 {
+		return
+	}
+	fmt.Println("Files with the same hash as a different book:")
+	for _, c := range summary.collisions {
+		ids := make([]string, len(c.bookIDs))
+		for i, id := range c.bookIDs {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		fmt.Printf("%s: %s\n", c.filename, strings.Join(ids, ", "))
+	}
 }
 
 // importBooks imports one or more books into the library.
@@ -139,6 +260,7 @@ func importBooks(root string, recursive bool, library *books.Library) error {
 		if !info.IsDir() {
 			log.Printf("Importing file %s:\n", path)
 			if err := importBook(path, library); err != nil {
+				summary.failed++
 				log.Printf("Cannot import book from %s: %s; skipping\n", path, err)
 			}
 			return nil
@@ -158,9 +280,43 @@ func importBook(filename string, library *books.Library) error {
 	if err != nil {
 		return errors.Wrap(err, "Get file info for book")
 	}
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return errors.Wrap(err, "Get absolute path of book")
+	}
+
+	// Skip files which haven't changed since they were last hashed, if that hash is already in the library.
+	hash, unchanged, err := cachedHash(library, absFilename, fi)
+	if err != nil {
+		return err
+	}
+	if unchanged {
+		summary.unchanged++
+		log.Printf("Skipping unchanged file %s, already in library", filename)
+		if viper.GetBool("move") {
+			if err := os.Remove(filename); err != nil {
+				log.Printf("Error deleting %s: %v", filename, err)
+			}
+		}
+		return nil
+	}
 
 	tags := splitTags(filename)
+	tags = appendTags(tags, directoryTags(filename)...)
+	tags = appendTags(tags, importTags...)
 	ext := path.Ext(filename)
+	bf := books.BookFile{Tags: tags, OriginalFilename: filename, Hash: hash}
+	bf.FileSize = fi.Size()
+	bf.FileMtime = fi.ModTime()
+	bf.Extension = strings.TrimPrefix(ext, ".")
+	bf.Source = fileSource(filename)
+
+	if bf.Hash == "" {
+		if err := hashFile(library, &bf, absFilename); err != nil {
+			return err
+		}
+	}
+
 	var book books.Book
 	var matched bool
 	for _, parserName := range metadataParsers {
@@ -170,28 +326,167 @@ func importBook(filename string, library *books.Library) error {
 		}
 	}
 	if !matched {
-		return errors.Errorf("No metadata parser matched %s", filename)
+		log.Printf("No metadata parser matched %s", filename)
+		if _, err := library.StageUnsortedFile(bf, viper.GetBool("move")); err != nil {
+			return errors.Wrap(err, "Stage unsorted file")
+		}
+		summary.unsorted++
+		return nil
 	}
 
-	bf := books.BookFile{Tags: tags, OriginalFilename: filename}
-	bf.FileSize = fi.Size()
-	bf.FileMtime = fi.ModTime()
-	bf.Extension = strings.TrimPrefix(ext, ".")
+	book.Files = append(book.Files, bf)
 
-	err = bf.CalculateHash()
+	result, err := library.ImportBook(book, outputTmpl, viper.GetBool("move"), duplicatePolicy)
 	if err != nil {
-		return errors.Wrap(err, "Calculate book hash")
+		return errors.Wrap(err, "Import book into library")
 	}
+	recordImportResult(filename, result)
 
-	book.Files = append(book.Files, bf)
+	return nil
+}
 
-	if err := library.ImportBook(book, outputTmpl, viper.GetBool("move")); err != nil {
-		return errors.Wrap(err, "Import book into library")
+// cachedHash gets the cached hash of a file, if it hasn't changed since it was cached and --rehash isn't set.
+// unchanged will be true if the file also doesn't need to be imported again, because its hash is already in the library.
+// With the attach duplicate policy, files are never unchanged, since their tags may still need attaching to a different book.
+func cachedHash(library *books.Library, absFilename string, fi os.FileInfo) (hash string, unchanged bool, err error) {
+	if rehash {
+		return "", false, nil
+	}
+	hash, found, err := library.GetCachedHash(absFilename, fi.Size(), fi.ModTime())
+	if err != nil {
+		return "", false, errors.Wrap(err, "Get cached hash")
+	}
+	if !found {
+		return "", false, nil
 	}
+	if duplicatePolicy == books.DuplicateAttach {
+		return hash, false, nil
+	}
+	exists, err := library.HashExists(hash)
+	if err != nil {
+		return "", false, errors.Wrap(err, "Check for existing hash")
+	}
+	return hash, exists, nil
+}
 
+// hashFile calculates the hash of bf and caches it, both in the library and in the file's xattrs.
+// If --rehash is set, the file's contents are always read.
+func hashFile(library *books.Library, bf *books.BookFile, absFilename string) error {
+	var err error
+	if rehash {
+		err = bf.HashContents()
+	} else {
+		err = bf.CalculateHash()
+	}
+	if err != nil {
+		return errors.Wrap(err, "Calculate book hash")
+	}
+	// Files on filesystems without xattrs, or which can't be written to, can still be imported.
+	if err := bf.StoreHashXattrs(); err != nil {
+		log.Printf("Not storing hash of %s in xattrs: %v", bf.OriginalFilename, err)
+	}
+	if err := library.CacheHash(absFilename, bf.FileSize, bf.FileMtime, bf.Hash); err != nil {
+		return errors.Wrap(err, "Cache book hash")
+	}
 	return nil
 }
 
+// recordImportResult adds the outcome of importing filename to the import summary.
+func recordImportResult(filename string, result books.ImportResult) {
+	switch result.Status {
+	case books.ImportImported:
+		summary.imported++
+	case books.ImportDuplicate:
+		summary.duplicates++
+	case books.ImportSkipped:
+		summary.skipped++
+	case books.ImportAttached:
+		summary.attached++
+	}
+	if len(result.CollidingBookIDs) > 0 {
+		summary.collisions = append(summary.collisions, hashCollision{filename, result.CollidingBookIDs})
+	}
+}
+
+// fileSource returns the source label for filename.
+// The --source flag takes precedence over the configured source rules.
+func fileSource(filename string) string {
+	if importSource != "" {
+		return importSource
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return ""
+	}
+	var source string
+	longest := -1
+	for _, rule := range sourceRules {
+		if !hasPathPrefix(abs, rule.Prefix) || len(rule.Prefix) <= longest {
+			continue
+		}
+		source = rule.Source
+		longest = len(rule.Prefix)
+	}
+	return source
+}
+
+// directoryTags returns tags made from the directory components of filename
+// below the prefix of each matching tag rule.
+func directoryTags(filename string) []string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+	dir := filepath.Dir(abs)
+	var tags []string
+	for _, rule := range tagRules {
+		if !hasPathPrefix(dir, rule.Prefix) {
+			continue
+		}
+		rel, err := filepath.Rel(rule.Prefix, dir)
+		if err != nil || rel == "." {
+			continue
+		}
+		components := strings.Split(rel, string(filepath.Separator))
+		if rule.Depth > 0 && len(components) > rule.Depth {
+			components = components[:rule.Depth]
+		}
+		for _, c := range components {
+			tags = appendTags(tags, strings.ToLower(c))
+		}
+	}
+	return tags
+}
+
+// appendTags appends each non-empty tag not already in tags.
+func appendTags(tags []string, newTags ...string) []string {
+	for _, t := range newTags {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		found := false
+		for _, existing := range tags {
+			if existing == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// hasPathPrefix reports whether fn is prefix or is inside the directory prefix.
+func hasPathPrefix(fn, prefix string) bool {
+	if fn == prefix {
+		return true
+	}
+	return strings.HasPrefix(fn, strings.TrimSuffix(prefix, string(filepath.Separator))+string(filepath.Separator))
+}
+
 // SplitTags takes an unsplit filename in the form "filename (tag1) (tag2)..."
 // and returns the tags.
 func splitTags(filename string) []string {
@@ -210,3 +505,13 @@ func splitTags(filename string) []string {
 	}
 	return tags
 }
+
+// importOptions returns the options for opening the library during an import.
+// With --fast, synchronous is turned off.
+func importOptions() books.Options {
+	opts := libraryOptions()
+	if importFast {
+		opts.Synchronous = "off"
+	}
+	return opts
+}
@@ -0,0 +1,45 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tspivey/books"
+
+	"github.com/spf13/cobra"
+)
+
+// reindexCmd represents the reindex command
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild the search index",
+	Long: `Renormalize the titles used to find existing books, and rebuild the search index.
+
+Titles and authors are compared regardless of case and diacritics, so "Brontë" and "BRONTE" are the same author.
+Run this after upgrading books if searches or imports don't find books added by an earlier version.`,
+	Run: CPUProfile(reindexFunc),
+}
+
+func init() {
+	rootCmd.AddCommand(reindexCmd)
+}
+
+func reindexFunc(cmd *cobra.Command, args []string) {
+	library, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer library.Close()
+
+	keysUpdated, indexed, err := library.Reindex()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reindexing library: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Indexed %d books, renormalized %d titles.\n", indexed, keysUpdated)
+}
@@ -0,0 +1,145 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+var restoreFormat string
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore <dump>",
+	Short: "Restore the library from a dump",
+	Long: `Rebuild the library from a dump written by books export.
+
+The library must be empty; it will be created if it doesn't exist.
+The contents of each file are taken from the books root by their hash, so the books root must be intact.
+Files whose contents are missing are listed and skipped.
+Books, shelves and saved searches which can't be restored are listed, and the rest are still restored;
+to start over, remove the library and run restore again.
+Shelves and saved searches are restored from JSON dumps; CSV dumps don't include them.
+The format is guessed from the dump's extension, unless --format is given.`,
+	Run: CPUProfile(restoreFunc),
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().StringVarP(&restoreFormat, "format", "f", "", "Format of the dump: json or csv")
+}
+
+func restoreFunc(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Specify one dump to restore.")
+		os.Exit(1)
+	}
+	format := restoreFormat
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(args[0])), ".")
+	}
+	if format != "json" && format != "csv" {
+		fmt.Fprintln(os.Stderr, "Cannot tell the format of the dump. Use --format json or --format csv.")
+		os.Exit(1)
+	}
+
+	fp, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open dump: %s\n", err)
+		os.Exit(1)
+	}
+	var dump books.Dump
+	if format == "csv" {
+		dump, err = books.ReadDumpCSV(fp)
+	} else {
+		dump, err = books.ReadDumpJSON(fp)
+	}
+	fp.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read dump: %s\n", err)
+		os.Exit(1)
+	}
+
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(libraryFile); os.IsNotExist(err) {
+		if err := books.CreateLibrary(libraryFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create library: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	library, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer library.Close()
+	empty, err := library.IsEmpty()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if !empty {
+		fmt.Fprintf(os.Stderr, "The library in %s isn't empty. Restore into a new configuration directory, or remove the library first.\n", libraryFile)
+		os.Exit(1)
+	}
+
+	// Keep going after an error, so that as much of the library as possible is restored.
+	var numFiles, numMissing, numFailed int
+	bookIDs := make(map[int64]int64)
+	for _, book := range dump.Books {
+		id, missing, err := library.RestoreBook(book, outputTmpl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring %s - %s: %s\n", books.JoinNaturally("and", book.Authors), book.Title, err)
+			numFailed++
+			continue
+		}
+		for _, f := range missing {
+			fmt.Fprintf(os.Stderr, "Missing contents of %s (%s) for %s - %s\n", f.OriginalFilename, f.Hash, books.JoinNaturally("and", book.Authors), book.Title)
+		}
+		bookIDs[book.ID] = id
+		numFiles += len(book.Files)
+		numMissing += len(missing)
+	}
+	numShelves := 0
+	for _, shelf := range dump.Shelves {
+		if err := library.RestoreShelf(shelf, bookIDs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring shelf %s: %s\n", shelf.Name, err)
+			numFailed++
+			continue
+		}
+		numShelves++
+	}
+	numSearches := 0
+	for _, search := range dump.SavedSearches {
+		if err := library.RestoreSavedSearch(search); err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring saved search %s: %s\n", search.Name, err)
+			numFailed++
+			continue
+		}
+		numSearches++
+	}
+	fmt.Printf("Restored %d books with %d files, %d shelves and %d saved searches; %d files were missing.\n",
+		len(bookIDs), numFiles-numMissing, numShelves, numSearches, numMissing)
+	if numFailed > 0 {
+		fmt.Fprintf(os.Stderr, "%d books, shelves or saved searches couldn't be restored.\n", numFailed)
+		fmt.Fprintf(os.Stderr, "To restore from the start again, remove %s and run restore again.\n", libraryFile)
+		os.Exit(1)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"runtime/pprof"
 	"strings"
 	"text/template"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -32,6 +33,7 @@ var funcMap = template.FuncMap{
 	"ToUpper":       strings.ToUpper,
 	"join":          strings.Join,
 	"escape":        books.Escape,
+	"seriesIndex":   books.FormatSeriesIndex,
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -88,6 +90,35 @@ func initConfig() {
 
 	viper.SetDefault("root", path.Join(home, "books"))
 	booksRoot = viper.GetString("root")
+	viper.SetDefault("database.journal_mode", books.DefaultOptions.JournalMode)
+	viper.SetDefault("database.synchronous", books.DefaultOptions.Synchronous)
+	viper.SetDefault("database.busy_timeout", books.DefaultOptions.BusyTimeout)
+}
+
+// libraryOptions returns the options for opening the library, from the database section of the config file.
+func libraryOptions() books.Options {
+	return books.Options{
+		JournalMode: viper.GetString("database.journal_mode"),
+		Synchronous: viper.GetString("database.synchronous"),
+		BusyTimeout: configDuration("database.busy_timeout"),
+	}
+}
+
+// configDuration returns a setting from the config file written as a duration with a unit, such as "5s" or "168h".
+// A bare number would be taken as nanoseconds, so it's refused instead.
+func configDuration(key string) time.Duration {
+	switch v := viper.Get(key).(type) {
+	case time.Duration:
+		return v
+	case string:
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s in the config file must be a duration with a unit, such as \"5s\" or \"168h\", not %v.\n", key, viper.Get(key))
+	os.Exit(1)
+	return 0
 }
 
 // CPUProfile wraps a cobra command for CPU profiling.
@@ -0,0 +1,49 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// savedDeleteCmd represents the saved delete command
+var savedDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete a saved search",
+	Run:   CPUProfile(savedDeleteRun),
+}
+
+func init() {
+	savedCmd.AddCommand(savedDeleteCmd)
+}
+
+func savedDeleteRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "A name must be specified.")
+		os.Exit(1)
+	}
+	name := strings.Join(args, " ")
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	err = lib.DeleteSavedSearch(name)
+	if err == books.ErrSavedSearchNotFound {
+		fmt.Fprintf(os.Stderr, "There is no saved search named %s.\n", name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error deleting saved search: %s\n", err)
+		os.Exit(1)
+	}
+}
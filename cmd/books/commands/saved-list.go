@@ -0,0 +1,54 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// savedListCmd represents the saved list command
+var savedListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved searches",
+	Long:  `List saved searches, with their terms and options.`,
+	Run:   CPUProfile(savedListRun),
+}
+
+func init() {
+	savedCmd.AddCommand(savedListCmd)
+}
+
+func savedListRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	searches, err := lib.GetSavedSearches()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting saved searches: %s\n", err)
+		os.Exit(1)
+	}
+	if len(searches) == 0 {
+		fmt.Println("No saved searches.")
+		return
+	}
+	for _, s := range searches {
+		fmt.Printf("%s: %s", s.Name, s.Query)
+		if params := s.Options.Params().Encode(); params != "" {
+			if s.Query != "" {
+				fmt.Print(" ")
+			}
+			fmt.Printf("(%s)", params)
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// savedRunCmd represents the saved run command
+var savedRunCmd = &cobra.Command{
+	Use:   "run NAME",
+	Short: "Run a saved search",
+	Run:   CPUProfile(savedRunRun),
+}
+
+func init() {
+	savedCmd.AddCommand(savedRunCmd)
+}
+
+func savedRunRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "A name must be specified.")
+		os.Exit(1)
+	}
+	name := strings.Join(args, " ")
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	s, err := lib.GetSavedSearch(name)
+	if err == books.ErrSavedSearchNotFound {
+		fmt.Fprintf(os.Stderr, "There is no saved search named %s.\n", name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting saved search: %s\n", err)
+		os.Exit(1)
+	}
+	results, _, err := lib.SearchPaged(s.Query, s.Options, 0, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while searching for books: %s\n", err)
+		os.Exit(1)
+	}
+	printSearchResults(results)
+}
@@ -0,0 +1,59 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// savedSaveCmd represents the saved save command
+var savedSaveCmd = &cobra.Command{
+	Use:   "save NAME [TERMS...]",
+	Short: "Save a search",
+	Long: `Save a search under a name, replacing any saved search with the same name.
+
+The terms and flags are the same as for the search command. Without terms, the search finds every book matching the flags.
+
+Examples:
+    books saved save "Unread fantasy" --genre Fiction/Fantasy --without-tag read
+    books saved save Recent --added-after 2018-06-01 --sort added --reverse
+    books saved save King author:king --sort series`,
+	Run: CPUProfile(savedSaveRun),
+}
+
+func init() {
+	savedCmd.AddCommand(savedSaveCmd)
+	addSearchFlags(savedSaveCmd)
+}
+
+func savedSaveRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "A name must be specified.")
+		os.Exit(1)
+	}
+	name, terms := args[0], strings.Join(args[1:], " ")
+	opts, err := searchOptionsFromFlags(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if _, err := lib.SaveSearch(name, terms, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving search: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// savedCmd represents the saved command
+var savedCmd = &cobra.Command{
+	Use:     "saved",
+	Aliases: []string{"smart"},
+	Short:   "Manage saved searches",
+	Long: `Save, list and run saved searches, also called smart shelves.
+
+A saved search is a named search, with the same terms, filters and sort order as the search command.
+Its books aren't stored: running it searches the library again, so it finds books added since it was saved.
+Saved search names can't contain /.`,
+}
+
+func init() {
+	rootCmd.AddCommand(savedCmd)
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/tspivey/books"
 
@@ -20,29 +21,83 @@ var searchCmd = &cobra.Command{
 	Use:   "search TERMS",
 	Short: "Search the library",
 	Long: `Search the library.
-By default, all fields are searched. This can be overridden with field:value.
-Supported fields: author, series, title, tags, extension.
+Books must match every word. By default, all fields are searched. This can be overridden with field:value.
+Supported fields: author, series, title, tags, extension, filename, source, genres.
+
+"Quoted words" (or words+joined+with+plus) match a phrase, and a word ending in * matches words starting with it.
+OR matches either side, NOT or a leading - excludes books, and parentheses group terms.
+field:"a phrase" and field:(a OR b) limit phrases and groups to a field.
+Case and diacritics are ignored, so bronte finds Brontë.
 
 Examples:
     Wizard's First Rule
-    series:Sword+of+Truth
-    author:Terry+Goodkind title:Phantom`,
+    series:"Sword of Truth" -title:phantom
+    author:"Terry Goodkind" (title:phantom OR title:confessor)
+    title:wiz*
+
+The flags filter and sort the results, and can be used without any terms to list every book they match.
+Dates are given as YYYY-MM-DD, and sizes are in bytes, or with a unit such as 500k or 2M.
+
+Examples:
+    --format epub --without-format pdf
+    --genre Fiction/Fantasy --without-genre Fiction/Fantasy/Epic
+    --added-after 2018-06-01 --sort added --reverse
+    author:king --min-size 1M --sort series`,
 	Run: CPUProfile(searchRun),
 }
 
 func searchRun(cmd *cobra.Command, args []string) {
 	terms := strings.Join(args, " ")
-	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	opts, err := searchOptionsFromFlags(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
 		os.Exit(1)
 	}
 
-	books, err := lib.Search(terms)
+	results, _, err := lib.SearchPaged(terms, opts, 0, 0)
+	if qe, ok := err.(*books.QueryError); ok {
+		fmt.Fprintf(os.Stderr, "Invalid search: %s\n", qe)
+		os.Exit(1)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error while searching for books: %s\n", err)
 		os.Exit(1)
 	}
+	printSearchResults(results)
+}
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+	addSearchFlags(searchCmd)
+}
+
+// addSearchFlags adds the flags read by searchOptionsFromFlags to cmd.
+func addSearchFlags(cmd *cobra.Command) {
+	cmd.Flags().String("added-after", "", "Only show books added on or after this date")
+	cmd.Flags().String("added-before", "", "Only show books added before this date")
+	cmd.Flags().String("min-size", "", "Only show books whose files total at least this size")
+	cmd.Flags().String("max-size", "", "Only show books whose files total at most this size")
+	cmd.Flags().StringArrayP("format", "f", []string{}, "Only show books with a file in this format, such as epub (can be repeated)")
+	cmd.Flags().StringArray("without-format", []string{}, "Only show books without a file in this format (can be repeated)")
+	cmd.Flags().Int("min-files", 0, "Only show books with at least this many files")
+	cmd.Flags().Int("max-files", 0, "Only show books with at most this many files")
+	cmd.Flags().StringArrayP("tag", "t", []string{}, "Only show books with this tag (can be repeated)")
+	cmd.Flags().StringArray("without-tag", []string{}, "Only show books without this tag (can be repeated)")
+	cmd.Flags().StringArrayP("genre", "g", []string{}, "Only show books in this genre or its subgenres (can be repeated)")
+	cmd.Flags().StringArray("without-genre", []string{}, "Only show books not in this genre or its subgenres (can be repeated)")
+	cmd.Flags().StringArrayP("author", "a", []string{}, "Only show books by this author, by their full name (can be repeated)")
+	cmd.Flags().String("series", "", "Only show books in this series")
+	cmd.Flags().StringP("sort", "s", "relevance", "Sort by "+strings.Join(books.SortOrderNames, ", "))
+	cmd.Flags().BoolP("reverse", "r", false, "Reverse the sort order")
+}
+
+// printSearchResults prints one line for each book found by a search.
+func printSearchResults(results []books.Book) {
 	resultTmplSrc := `{{range $i, $v := . -}}
 {{joinNaturally "and" $v.Authors}} - {{$v.Title -}}
 {{if $v.Series}} [{{$v.Series}}]{{end }} ({{ $v.ID }})
@@ -54,13 +109,56 @@ func searchRun(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	err = tmpl.Execute(os.Stdout, books)
+	err = tmpl.Execute(os.Stdout, results)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error executing template: %s\n", err)
 		os.Exit(1)
 	}
 }
 
-func init() {
-	rootCmd.AddCommand(searchCmd)
+// searchOptionsFromFlags reads the filters and sort order given to a command with addSearchFlags.
+func searchOptionsFromFlags(cmd *cobra.Command) (books.SearchOptions, error) {
+	var opts books.SearchOptions
+	flags := cmd.Flags()
+	for _, d := range []struct {
+		flag string
+		t    *time.Time
+	}{{"added-after", &opts.AddedAfter}, {"added-before", &opts.AddedBefore}} {
+		if s, _ := flags.GetString(d.flag); s != "" {
+			t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+			if err != nil {
+				return opts, fmt.Errorf("Invalid date for --%s: %s; use YYYY-MM-DD", d.flag, s)
+			}
+			*d.t = t
+		}
+	}
+	for _, d := range []struct {
+		flag string
+		size *int64
+	}{{"min-size", &opts.MinSize}, {"max-size", &opts.MaxSize}} {
+		if s, _ := flags.GetString(d.flag); s != "" {
+			size, err := books.ParseSize(s)
+			if err != nil {
+				return opts, fmt.Errorf("Invalid size for --%s: %s", d.flag, err)
+			}
+			*d.size = size
+		}
+	}
+	opts.Formats, _ = flags.GetStringArray("format")
+	opts.WithoutFormats, _ = flags.GetStringArray("without-format")
+	opts.MinFiles, _ = flags.GetInt("min-files")
+	opts.MaxFiles, _ = flags.GetInt("max-files")
+	opts.Tags, _ = flags.GetStringArray("tag")
+	opts.WithoutTags, _ = flags.GetStringArray("without-tag")
+	opts.Genres, _ = flags.GetStringArray("genre")
+	opts.WithoutGenres, _ = flags.GetStringArray("without-genre")
+	opts.Authors, _ = flags.GetStringArray("author")
+	opts.Series, _ = flags.GetString("series")
+	opts.Reverse, _ = flags.GetBool("reverse")
+	sortName, _ := flags.GetString("sort")
+	var err error
+	if opts.Sort, err = books.ParseSortOrder(sortName); err != nil {
+		return opts, err
+	}
+	return opts, nil
 }
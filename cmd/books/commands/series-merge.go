@@ -0,0 +1,62 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// seriesMergeCmd represents the series merge command
+var seriesMergeCmd = &cobra.Command{
+	Use:   "merge INTO SERIES...",
+	Short: "Merge series",
+	Long:  `Merges two or more series into the first one specified, keeping their books' indexes.`,
+	Run:   CPUProfile(seriesMergeRun),
+}
+
+func init() {
+	seriesCmd.AddCommand(seriesMergeCmd)
+}
+
+func seriesMergeRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "At least two series must be specified.")
+		os.Exit(1)
+	}
+	if args[0] == "" {
+		fmt.Fprintln(os.Stderr, "The series to merge into must not be empty.")
+		os.Exit(1)
+	}
+
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	n, err := lib.MergeSeries(args[1:], args[0], outputTmpl)
+	if err == books.ErrSeriesNotFound {
+		fmt.Fprintln(os.Stderr, "None of the series to merge have any books.")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error merging series: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Moved %d books to %s.\n", n, args[0])
+}
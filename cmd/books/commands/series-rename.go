@@ -0,0 +1,64 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// seriesRenameCmd represents the series rename command
+var seriesRenameCmd = &cobra.Command{
+	Use:   "rename OLD NEW",
+	Short: "Rename a series",
+	Long: `Rename a series, keeping its books' indexes.
+
+If a series named NEW already exists, the two are merged.`,
+	Run: CPUProfile(seriesRenameRun),
+}
+
+func init() {
+	seriesCmd.AddCommand(seriesRenameCmd)
+}
+
+func seriesRenameRun(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "The old and new series names must be specified.")
+		os.Exit(1)
+	}
+	if args[1] == "" {
+		fmt.Fprintln(os.Stderr, "The new series name must not be empty.")
+		os.Exit(1)
+	}
+
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	n, err := lib.RenameSeries(args[0], args[1], outputTmpl)
+	if err == books.ErrSeriesNotFound {
+		fmt.Fprintf(os.Stderr, "No books are in the series %s.\n", args[0])
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error renaming series: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Moved %d books to %s.\n", n, args[1])
+}
@@ -0,0 +1,78 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// seriesShowCmd represents the series show command
+var seriesShowCmd = &cobra.Command{
+	Use:   "show NAME",
+	Short: "Show the books in a series",
+	Long: `Show the books in a series in reading order, with their formats.
+
+Volumes missing from the series, and books which share an index, are noted.`,
+	Run: CPUProfile(seriesShowRun),
+}
+
+func init() {
+	seriesCmd.AddCommand(seriesShowCmd)
+}
+
+func seriesShowRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "A series name must be specified.")
+		os.Exit(1)
+	}
+	name := strings.Join(args, " ")
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	series, err := lib.GetSeriesByName(name)
+	if err == books.ErrSeriesNotFound {
+		fmt.Fprintf(os.Stderr, "No books are in the series %s.\n", name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting series: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(series.Name)
+	for _, e := range series.Entries {
+		if e.Book == nil {
+			fmt.Printf("#%s missing\n", books.FormatSeriesIndex(e.Index))
+			continue
+		}
+		if e.Index > 0 {
+			fmt.Printf("#%s ", books.FormatSeriesIndex(e.Index))
+		} else {
+			fmt.Print("Unnumbered: ")
+		}
+		fmt.Printf("%s - %s", books.JoinNaturally("and", e.Book.Authors), e.Book.Title)
+		var formats []string
+		for _, f := range e.Book.Files {
+			formats = append(formats, f.Extension)
+		}
+		if len(formats) > 0 {
+			fmt.Printf(" (%s)", strings.Join(formats, ", "))
+		}
+		fmt.Printf(" (%d)", e.Book.ID)
+		if e.Duplicate {
+			fmt.Print(" [duplicate]")
+		}
+		fmt.Println()
+	}
+}
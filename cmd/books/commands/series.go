@@ -0,0 +1,22 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// seriesCmd represents the series command
+var seriesCmd = &cobra.Command{
+	Use:   "series",
+	Short: "Show and manage series",
+	Long: `Show the books in a series in reading order, and rename or merge series.
+
+Renaming or merging a series renames its books' files according to the output template.`,
+}
+
+func init() {
+	rootCmd.AddCommand(seriesCmd)
+}
@@ -7,12 +7,18 @@
 package commands
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -42,6 +48,8 @@ func init() {
 	viper.SetDefault("server.write_timeout", 0)
 	viper.SetDefault("server.idle_timeout", 120)
 	viper.SetDefault("server.items_per_page", 20)
+	viper.SetDefault("server.realm", "Books")
+	viper.SetDefault("server.session_lifetime", 168*time.Hour)
 }
 
 func runServer(cmd *cobra.Command, args []string) {
@@ -51,7 +59,7 @@ func runServer(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 	templatesDir := path.Join(cfgDir, "templates")
-	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening library: %s\n", err)
 		os.Exit(1)
@@ -75,18 +83,80 @@ func runServer(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	duplicatePolicy, err := books.ParseDuplicatePolicy(viper.GetString("duplicate_policy"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	sessionKey, err := loadSessionKey(path.Join(cfgDir, "session_key"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading session key: %s\n", err)
+		os.Exit(1)
+	}
+
 	cfg := &server.Config{
-		Lib:            lib,
-		TemplatesDir:   templatesDir,
-		Converter:      converter,
-		ItemsPerPage:   viper.GetInt("server.items_per_page"),
-		Hsrv:           hsrv,
-		HtpasswdFile:   htpasswdFile,
-		BooksRoot:      booksRoot,
-		OutputTemplate: outputTmpl,
+		Lib:             lib,
+		TemplatesDir:    templatesDir,
+		Converter:       converter,
+		ItemsPerPage:    viper.GetInt("server.items_per_page"),
+		Hsrv:            hsrv,
+		HtpasswdFile:    htpasswdFile,
+		BooksRoot:       booksRoot,
+		OutputTemplate:  outputTmpl,
+		DuplicatePolicy: duplicatePolicy,
+		Realm:           viper.GetString("server.realm"),
+		SessionKey:      sessionKey,
+		SessionLifetime: configDuration("server.session_lifetime"),
+		SecureCookies:   viper.GetBool("server.secure_cookies"),
 	}
 	srv := server.New(cfg)
+
+	// On interrupt, let requests in progress finish, so they don't leave the library half updated.
+	done := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		log.Printf("Received %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down: %v", err)
+		}
+		close(done)
+	}()
+
 	log.Printf("Listening on %s", hsrv.Addr)
 	log.Printf("Read timeout: %d, write timeout: %d, idle timeout: %d seconds", hsrv.ReadTimeout/time.Second, hsrv.WriteTimeout/time.Second, hsrv.IdleTimeout/time.Second)
-	log.Fatal(srv.Start())
+	if err := srv.Start(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	lib.Close()
+}
+
+// loadSessionKey returns the key login sessions are signed with, stored in filename.
+// If the file doesn't exist, a new key is created, so sessions last across restarts.
+// Deleting the file logs everyone out.
+func loadSessionKey(filename string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(key) < 32 {
+			return nil, fmt.Errorf("%s is not a valid session key; delete it to create a new one", filename)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filename, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
 }
@@ -0,0 +1,61 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// shelfAddCmd represents the shelf add command
+var shelfAddCmd = &cobra.Command{
+	Use:   "add SHELF ID...",
+	Short: "Add books to a shelf",
+	Long: `Add books to a shelf, in the order given.
+
+Books are added to the end of the shelf, unless --at gives the position of the first one, starting from 1.
+Books already on the shelf are moved, so add can also reorder a shelf.`,
+	Run: CPUProfile(shelfAddRun),
+}
+
+func init() {
+	shelfCmd.AddCommand(shelfAddCmd)
+	shelfAddCmd.Flags().Int("at", 0, "Position on the shelf to add the books at")
+}
+
+func shelfAddRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "A shelf and at least one book ID must be specified.")
+		os.Exit(1)
+	}
+	ids, err := parseBookIDs(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	position, _ := cmd.Flags().GetInt("at")
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	err = lib.AddToShelf(args[0], ids, position)
+	if err == books.ErrShelfNotFound {
+		fmt.Fprintf(os.Stderr, "There is no shelf named %s.\n", args[0])
+		os.Exit(1)
+	} else if err == books.ErrBookNotFound {
+		fmt.Fprintln(os.Stderr, "All specified book IDs must exist.")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error adding books to shelf: %s\n", err)
+		os.Exit(1)
+	}
+}
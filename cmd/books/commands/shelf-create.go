@@ -0,0 +1,52 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// shelfCreateCmd represents the shelf create command
+var shelfCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Create a shelf",
+	Run:   CPUProfile(shelfCreateRun),
+}
+
+func init() {
+	shelfCmd.AddCommand(shelfCreateCmd)
+	shelfCreateCmd.Flags().StringP("description", "d", "", "Description of the shelf")
+}
+
+func shelfCreateRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "A shelf name must be specified.")
+		os.Exit(1)
+	}
+	name := strings.Join(args, " ")
+	description, _ := cmd.Flags().GetString("description")
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	shelf, err := lib.CreateShelf(name, description)
+	if err == books.ErrShelfExists {
+		fmt.Fprintf(os.Stderr, "A shelf named %s already exists.\n", name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating shelf: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Created shelf %s.\n", shelf.Name)
+}
@@ -0,0 +1,50 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// shelfDeleteCmd represents the shelf delete command
+var shelfDeleteCmd = &cobra.Command{
+	Use:   "delete SHELF",
+	Short: "Delete a shelf",
+	Long:  `Delete a shelf. The books on it stay in the library.`,
+	Run:   CPUProfile(shelfDeleteRun),
+}
+
+func init() {
+	shelfCmd.AddCommand(shelfDeleteCmd)
+}
+
+func shelfDeleteRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "A shelf name must be specified.")
+		os.Exit(1)
+	}
+	name := strings.Join(args, " ")
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	err = lib.DeleteShelf(name)
+	if err == books.ErrShelfNotFound {
+		fmt.Fprintf(os.Stderr, "There is no shelf named %s.\n", name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error deleting shelf: %s\n", err)
+		os.Exit(1)
+	}
+}
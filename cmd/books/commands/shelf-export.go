@@ -0,0 +1,96 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// shelfExportCmd represents the shelf export command
+var shelfExportCmd = &cobra.Command{
+	Use:   "export SHELF",
+	Short: "Export the books on a shelf",
+	Long: `Export the files of the books on a shelf, as a zip file or into a directory such as a reading device.
+
+Each file's name starts with its book's position on the shelf, so the files sort in the shelf's order.
+By default, every file of each book is exported. With --format, only one file is exported for each book,
+in the first of the formats it has; books with none of them are listed and skipped.
+
+Examples:
+    books shelf export "Book club" -o bookclub.zip
+    books shelf export "Book club" --dir /media/reader/books --format epub --format mobi`,
+	Run: CPUProfile(shelfExportRun),
+}
+
+func init() {
+	shelfCmd.AddCommand(shelfExportCmd)
+	shelfExportCmd.Flags().StringP("output", "o", "", "Zip file to write")
+	shelfExportCmd.Flags().StringP("dir", "d", "", "Directory to copy the files into")
+	shelfExportCmd.Flags().StringArrayP("format", "f", []string{}, "Format to export, such as epub (can be repeated, in order of preference)")
+}
+
+func shelfExportRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "A shelf name must be specified.")
+		os.Exit(1)
+	}
+	name := strings.Join(args, " ")
+	output, _ := cmd.Flags().GetString("output")
+	dir, _ := cmd.Flags().GetString("dir")
+	formats, _ := cmd.Flags().GetStringArray("format")
+	if (output == "") == (dir == "") {
+		fmt.Fprintln(os.Stderr, "Either --output or --dir must be specified.")
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	files, missing, err := lib.ShelfFiles(name, formats)
+	if err == books.ErrShelfNotFound {
+		fmt.Fprintf(os.Stderr, "There is no shelf named %s.\n", name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting shelf: %s\n", err)
+		os.Exit(1)
+	}
+	for _, book := range missing {
+		fmt.Fprintf(os.Stderr, "Skipping %s - %s (%d), which isn't in any of the formats.\n", books.JoinNaturally("and", book.Authors), book.Title, book.ID)
+	}
+
+	if dir != "" {
+		err = books.CopyShelfFiles(dir, files)
+	} else {
+		var fp *os.File
+		fp, err = os.Create(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create %s: %s\n", output, err)
+			os.Exit(1)
+		}
+		w := bufio.NewWriter(fp)
+		err = books.WriteShelfZip(w, files)
+		if err == nil {
+			err = w.Flush()
+		}
+		if closeErr := fp.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting shelf: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Exported %d files.\n", len(files))
+}
@@ -0,0 +1,71 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// shelfListCmd represents the shelf list command
+var shelfListCmd = &cobra.Command{
+	Use:   "list [SHELF]",
+	Short: "List shelves, or the books on a shelf",
+	Run:   CPUProfile(shelfListRun),
+}
+
+func init() {
+	shelfCmd.AddCommand(shelfListCmd)
+}
+
+func shelfListRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if len(args) == 0 {
+		shelves, err := lib.GetShelves()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting shelves: %s\n", err)
+			os.Exit(1)
+		}
+		if len(shelves) == 0 {
+			fmt.Println("No shelves.")
+			return
+		}
+		for _, s := range shelves {
+			fmt.Printf("%s (%d books)", s.Name, s.Books)
+			if s.Description != "" {
+				fmt.Printf(": %s", s.Description)
+			}
+			fmt.Println()
+		}
+		return
+	}
+
+	name := strings.Join(args, " ")
+	shelf, bks, err := lib.GetShelf(name)
+	if err == books.ErrShelfNotFound {
+		fmt.Fprintf(os.Stderr, "There is no shelf named %s.\n", name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting shelf: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(shelf.Name)
+	if shelf.Description != "" {
+		fmt.Println(shelf.Description)
+	}
+	for i, book := range bks {
+		fmt.Printf("%d. %s - %s (%d)\n", i+1, books.JoinNaturally("and", book.Authors), book.Title, book.ID)
+	}
+}
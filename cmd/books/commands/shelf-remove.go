@@ -0,0 +1,56 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// shelfRemoveCmd represents the shelf remove command
+var shelfRemoveCmd = &cobra.Command{
+	Use:   "remove SHELF ID...",
+	Short: "Remove books from a shelf",
+	Long:  `Remove books from a shelf. The books stay in the library.`,
+	Run:   CPUProfile(shelfRemoveRun),
+}
+
+func init() {
+	shelfCmd.AddCommand(shelfRemoveCmd)
+}
+
+func shelfRemoveRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "A shelf and at least one book ID must be specified.")
+		os.Exit(1)
+	}
+	ids, err := parseBookIDs(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	n, err := lib.RemoveFromShelf(args[0], ids)
+	if err == books.ErrShelfNotFound {
+		fmt.Fprintf(os.Stderr, "There is no shelf named %s.\n", args[0])
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error removing books from shelf: %s\n", err)
+		os.Exit(1)
+	}
+	if n < len(ids) {
+		fmt.Printf("Removed %d books; the others weren't on the shelf.\n", n)
+	}
+}
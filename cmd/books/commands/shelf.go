@@ -0,0 +1,40 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// shelfCmd represents the shelf command
+var shelfCmd = &cobra.Command{
+	Use:     "shelf",
+	Aliases: []string{"shelves"},
+	Short:   "Manage shelves",
+	Long: `Create, list and export shelves.
+
+A shelf is a named, ordered list of books with a description, such as a reading list.
+Shelf names can't contain /.`,
+}
+
+func init() {
+	rootCmd.AddCommand(shelfCmd)
+}
+
+// parseBookIDs parses book IDs given as arguments.
+func parseBookIDs(args []string) ([]int64, error) {
+	var ids []int64
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, errors.New("Book ID must be a number.")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
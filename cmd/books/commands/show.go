@@ -37,7 +37,7 @@ func showRun(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
 		os.Exit(1)
@@ -58,13 +58,14 @@ func showRun(cmd *cobra.Command, args []string) {
 	}
 
 	bookDetailsTmplSrc := `{{joinNaturally "and" .Authors}} - {{.Title }}
-{{if .Series}}Series: {{.Series}}
+{{if .Series}}Series: {{.Series}}{{if .SeriesIndex}} #{{seriesIndex .SeriesIndex}}{{end}}
+{{end }}{{range $k, $v := .Identifiers}}{{$k}}: {{$v}}
 {{end }}
 {{ if .Files}}{{range .Files -}}
 {{ .Extension -}}
 : {{if .Tags}}({{range $i, $v := .Tags -}}
 {{if $i}}, {{end -}}
-{{ $v }}{{end}}){{end }} ({{ .ID }})
+{{ $v }}{{end}}){{end }}{{if .Source}} [{{ .Source }}]{{end}} ({{ .ID }})
 {{ end -}}
 {{ else }}No files available for this book{{ end }}`
 
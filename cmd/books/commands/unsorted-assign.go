@@ -0,0 +1,85 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+var assignTitle string
+var assignAuthors []string
+var assignSeries string
+
+// unsortedAssignCmd represents the unsorted assign command
+var unsortedAssignCmd = &cobra.Command{
+	Use:   "assign <id> --title <title> --author <author>",
+	Short: "Assign metadata to an unsorted file and import it",
+	Long: `Assign a title, authors and optionally a series to an unsorted file, and finish importing it.
+
+Use --author once for each author. Use books unsorted list to find the file's ID.`,
+	Run: CPUProfile(unsortedAssignRun),
+}
+
+func init() {
+	unsortedCmd.AddCommand(unsortedAssignCmd)
+
+	unsortedAssignCmd.Flags().StringVar(&assignTitle, "title", "", "Title of the book")
+	unsortedAssignCmd.Flags().StringArrayVar(&assignAuthors, "author", []string{}, "Author of the book (can be repeated)")
+	unsortedAssignCmd.Flags().StringVar(&assignSeries, "series", "", "Series of the book")
+}
+
+func unsortedAssignRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "No unsorted file ID specified.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unsorted file ID must be a number.")
+		os.Exit(1)
+	}
+	if assignTitle == "" || len(assignAuthors) == 0 {
+		fmt.Fprintln(os.Stderr, "A title and at least one author must be specified.")
+		os.Exit(1)
+	}
+	policy, err := books.ParseDuplicatePolicy(viper.GetString("duplicate_policy"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	book := books.Book{Authors: assignAuthors, Title: assignTitle, Series: assignSeries}
+	result, err := lib.AssignUnsortedFile(id, book, outputTmpl, policy)
+	if err == books.ErrUnsortedFileNotFound {
+		fmt.Fprintln(os.Stderr, "Unsorted file not found.")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error assigning unsorted file: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported into book %d\n", result.BookID)
+}
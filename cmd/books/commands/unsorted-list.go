@@ -0,0 +1,54 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// unsortedListCmd represents the unsorted list command
+var unsortedListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List unsorted files",
+	Run:   CPUProfile(unsortedListRun),
+}
+
+func init() {
+	unsortedCmd.AddCommand(unsortedListCmd)
+}
+
+func unsortedListRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibrary(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	files, err := lib.GetUnsortedFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting unsorted files: %s\n", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		fmt.Println("No unsorted files.")
+		return
+	}
+	for _, uf := range files {
+		fmt.Printf("%d: %s (%s)", uf.ID, uf.File.OriginalFilename, books.ByteCountSI(uf.File.FileSize))
+		if len(uf.File.Tags) > 0 {
+			fmt.Printf(" (%s)", strings.Join(uf.File.Tags, ", "))
+		}
+		if uf.File.Source != "" {
+			fmt.Printf(" [%s]", uf.File.Source)
+		}
+		fmt.Println()
+	}
+}
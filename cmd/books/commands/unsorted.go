@@ -0,0 +1,23 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// unsortedCmd represents the unsorted command
+var unsortedCmd = &cobra.Command{
+	Use:   "unsorted",
+	Short: "Manage files which couldn't be imported",
+	Long: `List and assign metadata to files in the unsorted staging area.
+
+When no metadata parser matches a file during import, the file is copied into the unsorted staging area instead.
+Once you assign it a title and authors, its import is finished.`,
+}
+
+func init() {
+	rootCmd.AddCommand(unsortedCmd)
+}
@@ -121,6 +121,19 @@ var seriesCmd = &DefaultCommand{
 	},
 }
 
+var genresCmd = &DefaultCommand{
+	Help: "Sets the genres of the currently edited book, separated by commas, or clears them if none are given",
+	Run: func(cmd *DefaultCommand, args string) {
+		cmd.parser.book.Genres = books.NormalizeGenres(strings.Split(args, ","))
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("genres", s) {
+			return []string{}
+		}
+		return []string{"genres " + strings.Join(cmd.parser.book.Genres, ", ")}
+	},
+}
+
 var saveCmd = &DefaultCommand{
 	Help: "Saves the currently edited book",
 	Run: func(cmd *DefaultCommand, args string) {
@@ -168,6 +181,7 @@ var showCmd = &DefaultCommand{
 		fmt.Println("Title: ", cmd.parser.book.Title)
 		fmt.Println("Authors: ", strings.Join(cmd.parser.book.Authors, " & "))
 		fmt.Println("Series: ", cmd.parser.book.Series)
+		fmt.Println("Genres: ", strings.Join(cmd.parser.book.Genres, ", "))
 	},
 	completer: func(cmd *DefaultCommand, s string) []string {
 		if !strings.HasPrefix("show", s) {
@@ -232,6 +246,7 @@ func NewParser(book *books.Book, lib *books.Library, tmpl *template.Template) *P
 	m["authors"] = c(authorsCmd)
 	m["title"] = c(titleCmd)
 	m["series"] = c(seriesCmd)
+	m["genres"] = c(genresCmd)
 	m["save"] = c(saveCmd)
 	m["show"] = c(showCmd)
 	m["help"] = c(helpCmd)
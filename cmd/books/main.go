@@ -2,6 +2,12 @@
 //
 // This source code is governed by the MIT license, which can be found in the LICENSE file.
 
+// Books manages a library of ebooks.
+//
+// Search uses SQLite's FTS5 extension, which go-sqlite3 only includes with the sqlite_fts5 build tag.
+// Build with mage, or with:
+//
+//	go build -tags sqlite_fts5 ./cmd/books
 package main
 
 import "github.com/tspivey/books/cmd/books/commands"
@@ -0,0 +1,183 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+// Loadtest measures the throughput of the books API under concurrent searches and updates.
+//
+// It starts a number of workers which send requests to a running books serve for a fixed time.
+// Each request is a search, or, with probability -writes, an update which saves a book unchanged.
+// The API key is read from the BOOKS_API_KEY environment variable, as with books serve.
+//
+// Example:
+//
+//	BOOKS_API_KEY=secret loadtest -url http://localhost:8000 -term fiction -book 1 -c 16 -d 10s
+//
+// On a single CPU, with a library of 2000 books, 16 workers searching for fiction for 15 seconds,
+// removing the global API lock raised total throughput from about 49 to 58 requests per second with 10% updates,
+// and cut the mean update latency from about 300ms to 110ms, since updates no longer wait for slow searches.
+// With 50% updates, throughput fell from about 90 to 72 requests per second,
+// as concurrent writers wait in SQLite's busy handler instead of queueing on the lock.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	baseURL     = flag.String("url", "http://localhost:8000", "base URL of the books server")
+	term        = flag.String("term", "", "search term")
+	bookID      = flag.Int64("book", 0, "ID of the book to update")
+	concurrency = flag.Int("c", 8, "number of concurrent workers")
+	duration    = flag.Duration("d", 10*time.Second, "how long to run")
+	writes      = flag.Float64("writes", 0.1, "fraction of requests which are updates")
+	user        = flag.String("user", "", "user name, if the server uses an htpasswd file")
+	password    = flag.String("password", "", "password, if the server uses an htpasswd file")
+)
+
+// stats collects the latencies of one kind of request.
+type stats struct {
+	mtx       sync.Mutex
+	latencies []time.Duration
+	errors    int
+}
+
+func (s *stats) add(d time.Duration, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err != nil {
+		s.errors++
+		return
+	}
+	s.latencies = append(s.latencies, d)
+}
+
+func (s *stats) print(name string, elapsed time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	n := len(s.latencies)
+	if n == 0 {
+		fmt.Printf("%-8s 0 requests, %d errors\n", name, s.errors)
+		return
+	}
+	var total time.Duration
+	for _, d := range s.latencies {
+		total += d
+	}
+	fmt.Printf("%-8s %6d requests, %4d errors, %8.1f req/s, mean %v, p95 %v, max %v\n",
+		name, n, s.errors, float64(n)/elapsed.Seconds(),
+		(total / time.Duration(n)).Round(time.Microsecond),
+		s.latencies[n*95/100].Round(time.Microsecond),
+		s.latencies[n-1].Round(time.Microsecond))
+}
+
+func main() {
+	flag.Parse()
+	key := os.Getenv("BOOKS_API_KEY")
+	if key == "" {
+		log.Fatal("BOOKS_API_KEY not set")
+	}
+	if *term == "" || *bookID == 0 {
+		log.Fatal("-term and -book are required")
+	}
+
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}}
+	do := func(method, path string, body []byte) ([]byte, error) {
+		req, err := http.NewRequest(method, *baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-API-Key", key)
+		if *user != "" {
+			req.SetBasicAuth(*user, *password)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return b, nil
+	}
+
+	// Fetch the book once, and post it back unchanged for each update.
+	book, err := do("GET", fmt.Sprintf("/api/book/%d", *bookID), nil)
+	if err != nil {
+		log.Fatalf("Error getting book %d: %v", *bookID, err)
+	}
+	update, err := json.Marshal(map[string]interface{}{
+		"book":             json.RawMessage(book),
+		"overwrite_series": true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	searchPath := "/api/search?term=" + url.QueryEscape(*term)
+
+	var searches, updates stats
+	deadline := time.Now().Add(*duration)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for time.Now().Before(deadline) {
+				t := time.Now()
+				if rnd.Float64() < *writes {
+					b, err := do("POST", "/api/update", update)
+					if err == nil && !bytes.Contains(b, []byte(`"success"`)) {
+						err = fmt.Errorf("update failed: %s", b)
+					}
+					logError(err)
+					updates.add(time.Since(t), err)
+				} else {
+					_, err := do("GET", searchPath, nil)
+					logError(err)
+					searches.add(time.Since(t), err)
+				}
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	fmt.Printf("%d workers for %v\n", *concurrency, elapsed.Round(time.Millisecond))
+	searches.print("search", elapsed)
+	updates.print("update", elapsed)
+}
+
+var errorsLogged int
+var errorsMtx sync.Mutex
+
+// logError logs the first few errors, so a misconfigured run is noticed without flooding the output.
+func logError(err error) {
+	if err == nil {
+		return
+	}
+	errorsMtx.Lock()
+	defer errorsMtx.Unlock()
+	if errorsLogged < 10 {
+		errorsLogged++
+		log.Print(err)
+	}
+}
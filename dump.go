@@ -0,0 +1,534 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Dump is the contents of a library dump: its books, the shelves they're on, and saved searches.
+// Dumps only depend on these types, not on the library's schema, so they can be restored into future versions of the library.
+type Dump struct {
+	Books         []DumpBook        `json:"books"`
+	Shelves       []DumpShelf       `json:"shelves,omitempty"`
+	SavedSearches []DumpSavedSearch `json:"saved_searches,omitempty"`
+}
+
+// DumpBook is a book as stored in a library dump.
+type DumpBook struct {
+	// ID is the book's ID in the library it was exported from, which shelves in the dump refer to it by.
+	ID          int64             `json:"id"`
+	Authors     []string          `json:"authors"`
+	Title       string            `json:"title"`
+	Series      string            `json:"series,omitempty"`
+	SeriesIndex float64           `json:"series_index,omitempty"`
+	Identifiers map[string]string `json:"identifiers,omitempty"`
+	Genres      []string          `json:"genres,omitempty"`
+	// Added and Updated are when the book was added to the library, and last changed.
+	// They're zero in dumps made before they were exported, and the book is then restored as if it was just added.
+	Added   time.Time  `json:"added"`
+	Updated time.Time  `json:"updated"`
+	Files   []DumpFile `json:"files"`
+}
+
+// DumpFile is a file as stored in a library dump.
+// The file's contents aren't included; they are found in the books root by hash when the dump is restored.
+type DumpFile struct {
+	Extension        string    `json:"extension"`
+	Hash             string    `json:"hash"`
+	Size             int64     `json:"size"`
+	Mtime            time.Time `json:"mtime"`
+	OriginalFilename string    `json:"original_filename"`
+	Filename         string    `json:"filename"`
+	Source           string    `json:"source,omitempty"`
+	Tags             []string  `json:"tags,omitempty"`
+}
+
+// DumpShelf is a shelf as stored in a library dump.
+type DumpShelf struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Books are the IDs of the shelf's books in the dump, in order.
+	Books []int64 `json:"books"`
+}
+
+// DumpSavedSearch is a saved search as stored in a library dump.
+type DumpSavedSearch struct {
+	Name  string `json:"name"`
+	Query string `json:"query,omitempty"`
+	// Params are the search's options, as the URL query parameters read by ParseSearchParams.
+	Params string `json:"params,omitempty"`
+}
+
+// csvDumpHeader holds the columns of a CSV dump, which has one row per file.
+// Rows for files of the same book are consecutive, and share the same book column.
+var csvDumpHeader = []string{"book", "title", "authors", "series", "series_index", "identifiers",
+	"extension", "hash", "size", "mtime", "original_filename", "filename", "source", "tags", "genres", "added", "updated"}
+
+// csvOptionalColumns were added to CSV dumps later, so dumps without them can still be read.
+var csvOptionalColumns = map[string]bool{"genres": true, "added": true, "updated": true}
+
+// dumpBatchSize is the number of books loaded from the library at a time while exporting.
+const dumpBatchSize = 500
+
+func bookToDump(book Book) DumpBook {
+	db := DumpBook{
+		ID:          book.ID,
+		Authors:     book.Authors,
+		Title:       book.Title,
+		Series:      book.Series,
+		SeriesIndex: book.SeriesIndex,
+		Identifiers: book.Identifiers,
+		Genres:      book.Genres,
+		Added:       book.Added,
+		Updated:     book.Updated,
+	}
+	for _, f := range book.Files {
+		db.Files = append(db.Files, DumpFile{
+			Extension:        f.Extension,
+			Hash:             f.Hash,
+			Size:             f.FileSize,
+			Mtime:            f.FileMtime,
+			OriginalFilename: f.OriginalFilename,
+			Filename:         f.CurrentFilename,
+			Source:           f.Source,
+			Tags:             f.Tags,
+		})
+	}
+	return db
+}
+
+func dumpToBook(db DumpBook) Book {
+	book := Book{
+		Authors:     db.Authors,
+		Title:       db.Title,
+		Series:      db.Series,
+		SeriesIndex: db.SeriesIndex,
+		Identifiers: db.Identifiers,
+		Genres:      db.Genres,
+		Added:       db.Added,
+		Updated:     db.Updated,
+	}
+	for _, f := range db.Files {
+		book.Files = append(book.Files, BookFile{
+			Extension:        f.Extension,
+			Hash:             f.Hash,
+			FileSize:         f.Size,
+			FileMtime:        f.Mtime,
+			OriginalFilename: f.OriginalFilename,
+			CurrentFilename:  f.Filename,
+			Source:           f.Source,
+			Tags:             f.Tags,
+		})
+	}
+	return book
+}
+
+// beginExport starts the read transaction a dump is exported in, so that it's a consistent snapshot of the library.
+func (lib *Library) beginExport() (*sql.Tx, error) {
+	tx, err := lib.readDB.BeginTx(context.Background(), nil)
+	return tx, errors.Wrap(err, "begin transaction")
+}
+
+// eachBook calls f with every book in the library, in order of ID, reading them in tx.
+func eachBook(tx *sql.Tx, f func(Book) error) error {
+	ctx := context.Background()
+	rows, err := tx.QueryContext(ctx, "select id from books order by id")
+	if err != nil {
+		return errors.Wrap(err, "query book IDs")
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "scan book ID")
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "get book IDs")
+	}
+
+	for len(ids) > 0 {
+		n := dumpBatchSize
+		if n > len(ids) {
+			n = len(ids)
+		}
+		bks, err := getBooksByID(ctx, tx, ids[:n])
+		if err != nil {
+			return err
+		}
+		for _, book := range bks {
+			if err := f(book); err != nil {
+				return err
+			}
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// dumpShelves returns every shelf in the library, reading them in tx.
+func dumpShelves(tx *sql.Tx) ([]DumpShelf, error) {
+	ctx := context.Background()
+	rows, err := tx.QueryContext(ctx, "select id, name, description from shelves order by name")
+	if err != nil {
+		return nil, errors.Wrap(err, "query shelves")
+	}
+	var ids []int64
+	var shelves []DumpShelf
+	for rows.Next() {
+		var id int64
+		var s DumpShelf
+		if err := rows.Scan(&id, &s.Name, &s.Description); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "scan shelves")
+		}
+		ids = append(ids, id)
+		shelves = append(shelves, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "query shelves")
+	}
+	for i, id := range ids {
+		if shelves[i].Books, err = getShelfBookIDs(ctx, tx, id); err != nil {
+			return nil, err
+		}
+		if shelves[i].Books == nil {
+			shelves[i].Books = []int64{}
+		}
+	}
+	return shelves, nil
+}
+
+// dumpSavedSearches returns every saved search in the library, reading them in tx.
+func dumpSavedSearches(tx *sql.Tx) ([]DumpSavedSearch, error) {
+	rows, err := tx.QueryContext(context.Background(), "select name, query, params from saved_searches order by name")
+	if err != nil {
+		return nil, errors.Wrap(err, "query saved searches")
+	}
+	defer rows.Close()
+	var searches []DumpSavedSearch
+	for rows.Next() {
+		var s DumpSavedSearch
+		if err := rows.Scan(&s.Name, &s.Query, &s.Params); err != nil {
+			return nil, errors.Wrap(err, "scan saved searches")
+		}
+		searches = append(searches, s)
+	}
+	return searches, errors.Wrap(rows.Err(), "query saved searches")
+}
+
+// ExportJSON writes the library to w as a JSON Dump.
+func (lib *Library) ExportJSON(w io.Writer) error {
+	tx, err := lib.beginExport()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := io.WriteString(w, "{\"books\": [\n"); err != nil {
+		return err
+	}
+	first := true
+	err = eachBook(tx, func(book Book) error {
+		b, err := json.Marshal(bookToDump(book))
+		if err != nil {
+			return errors.Wrapf(err, "encode book %d", book.ID)
+		}
+		if !first {
+			if _, err := io.WriteString(w, ",\n"); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = w.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	shelves, err := dumpShelves(tx)
+	if err != nil {
+		return err
+	}
+	searches, err := dumpSavedSearches(tx)
+	if err != nil {
+		return err
+	}
+	shelvesJSON, err := json.Marshal(shelves)
+	if err != nil {
+		return errors.Wrap(err, "encode shelves")
+	}
+	searchesJSON, err := json.Marshal(searches)
+	if err != nil {
+		return errors.Wrap(err, "encode saved searches")
+	}
+	_, err = fmt.Fprintf(w, "\n],\n\"shelves\": %s,\n\"saved_searches\": %s\n}\n", shelvesJSON, searchesJSON)
+	return err
+}
+
+// ExportCSV writes every file in the library to w as CSV, with the metadata of its book.
+// Authors, identifiers, tags and genres are encoded as JSON within their columns.
+// CSV dumps only hold books, so shelves and saved searches are left out; use ExportJSON to keep them.
+func (lib *Library) ExportCSV(w io.Writer) error {
+	tx, err := lib.beginExport()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvDumpHeader); err != nil {
+		return err
+	}
+	err = eachBook(tx, func(book Book) error {
+		db := bookToDump(book)
+		authors, err := json.Marshal(db.Authors)
+		if err != nil {
+			return err
+		}
+		identifiers, err := json.Marshal(db.Identifiers)
+		if err != nil {
+			return err
+		}
+		genres, err := json.Marshal(db.Genres)
+		if err != nil {
+			return err
+		}
+		for _, f := range db.Files {
+			tags, err := json.Marshal(f.Tags)
+			if err != nil {
+				return err
+			}
+			err = cw.Write([]string{strconv.FormatInt(book.ID, 10), db.Title, string(authors), db.Series,
+				strconv.FormatFloat(db.SeriesIndex, 'f', -1, 64), string(identifiers),
+				f.Extension, f.Hash, strconv.FormatInt(f.Size, 10), f.Mtime.Format(time.RFC3339Nano),
+				f.OriginalFilename, f.Filename, f.Source, string(tags), string(genres),
+				db.Added.Format(time.RFC3339), db.Updated.Format(time.RFC3339)})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadDumpJSON reads a dump written by ExportJSON.
+// Dumps made before shelves and saved searches were exported, which are a JSON array of books, can also be read.
+func ReadDumpJSON(r io.Reader) (Dump, error) {
+	var dump Dump
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return dump, errors.Wrap(err, "decode JSON dump")
+	}
+	var err error
+	if bytes.HasPrefix(raw, []byte("[")) {
+		err = json.Unmarshal(raw, &dump.Books)
+	} else {
+		err = json.Unmarshal(raw, &dump)
+	}
+	return dump, errors.Wrap(err, "decode JSON dump")
+}
+
+// ReadDumpCSV reads a dump written by ExportCSV, which only has books.
+func ReadDumpCSV(r io.Reader) (Dump, error) {
+	bks, err := readDumpCSV(r)
+	return Dump{Books: bks}, err
+}
+
+func readDumpCSV(r io.Reader) ([]DumpBook, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "read CSV header")
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range csvDumpHeader {
+		if _, ok := columns[name]; !ok && !csvOptionalColumns[name] {
+			return nil, errors.Errorf("CSV dump is missing column %s", name)
+		}
+	}
+
+	var bks []DumpBook
+	lastBook := ""
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "read CSV record")
+		}
+		col := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return record[i]
+			}
+			return ""
+		}
+
+		if col("book") != lastBook || len(bks) == 0 {
+			db := DumpBook{Title: col("title"), Series: col("series")}
+			if db.ID, err = strconv.ParseInt(col("book"), 10, 64); err != nil {
+				return nil, errors.Wrapf(err, "line %d: parse book ID", line)
+			}
+			if err := json.Unmarshal([]byte(col("authors")), &db.Authors); err != nil {
+				return nil, errors.Wrapf(err, "line %d: decode authors", line)
+			}
+			if s := col("identifiers"); s != "" {
+				if err := json.Unmarshal([]byte(s), &db.Identifiers); err != nil {
+					return nil, errors.Wrapf(err, "line %d: decode identifiers", line)
+				}
+			}
+			if s := col("genres"); s != "" {
+				if err := json.Unmarshal([]byte(s), &db.Genres); err != nil {
+					return nil, errors.Wrapf(err, "line %d: decode genres", line)
+				}
+			}
+			if s := col("series_index"); s != "" {
+				if db.SeriesIndex, err = strconv.ParseFloat(s, 64); err != nil {
+					return nil, errors.Wrapf(err, "line %d: parse series index", line)
+				}
+			}
+			for _, d := range []struct {
+				column string
+				t      *time.Time
+			}{{"added", &db.Added}, {"updated", &db.Updated}} {
+				if s := col(d.column); s != "" {
+					if *d.t, err = time.Parse(time.RFC3339, s); err != nil {
+						return nil, errors.Wrapf(err, "line %d: parse %s", line, d.column)
+					}
+				}
+			}
+			bks = append(bks, db)
+			lastBook = col("book")
+		}
+
+		f := DumpFile{
+			Extension:        col("extension"),
+			Hash:             col("hash"),
+			OriginalFilename: col("original_filename"),
+			Filename:         col("filename"),
+			Source:           col("source"),
+		}
+		if f.Size, err = strconv.ParseInt(col("size"), 10, 64); err != nil {
+			return nil, errors.Wrapf(err, "line %d: parse size", line)
+		}
+		if f.Mtime, err = time.Parse(time.RFC3339Nano, col("mtime")); err != nil {
+			return nil, errors.Wrapf(err, "line %d: parse mtime", line)
+		}
+		if s := col("tags"); s != "" {
+			if err := json.Unmarshal([]byte(s), &f.Tags); err != nil {
+				return nil, errors.Wrapf(err, "line %d: decode tags", line)
+			}
+		}
+		last := &bks[len(bks)-1]
+		last.Files = append(last.Files, f)
+	}
+	return bks, nil
+}
+
+// RestoreBook adds a book from a dump to the library, one file at a time, and returns its new ID.
+// The contents of each file must already be in the books root, at the path given by its hash.
+// Files whose contents are missing aren't restored, and are returned in missing;
+// if none of the files are restored, neither is the book, and its ID is 0.
+// The book keeps the times it was added and updated, if the dump has them.
+func (lib *Library) RestoreBook(db DumpBook, tmpl *template.Template) (bookID int64, missing []DumpFile, err error) {
+	book := dumpToBook(db)
+	for i, bf := range book.Files {
+		if len(bf.Hash) < 4 {
+			missing = append(missing, db.Files[i])
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(lib.booksRoot, bf.HashPath())); os.IsNotExist(err) {
+			missing = append(missing, db.Files[i])
+			continue
+		} else if err != nil {
+			return 0, missing, errors.Wrap(err, "stat")
+		}
+		single := book
+		single.Files = []BookFile{bf}
+		result, err := lib.ImportBook(single, tmpl, false, DuplicateImport)
+		if err != nil {
+			return 0, missing, errors.Wrapf(err, "restore file %s", bf.Hash)
+		}
+		bookID = result.BookID
+	}
+	if bookID != 0 && !db.Added.IsZero() {
+		updated := db.Updated
+		if updated.IsZero() {
+			updated = db.Added
+		}
+		if _, err := lib.Exec("update books set created_on=?, updated_on=? where id=?",
+			db.Added.UTC().Format(dbTimeFormat), updated.UTC().Format(dbTimeFormat), bookID); err != nil {
+			return 0, missing, errors.Wrap(err, "restore times")
+		}
+	}
+	return bookID, missing, nil
+}
+
+// RestoreShelf creates a shelf from a dump, and puts its books on it.
+// bookIDs maps the IDs of books in the dump to their IDs in the library, as returned by RestoreBook.
+// Books which weren't restored are left off the shelf.
+func (lib *Library) RestoreShelf(ds DumpShelf, bookIDs map[int64]int64) error {
+	if _, err := lib.CreateShelf(ds.Name, ds.Description); err != nil {
+		return err
+	}
+	var ids []int64
+	for _, id := range ds.Books {
+		if newID, ok := bookIDs[id]; ok && newID != 0 {
+			ids = append(ids, newID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return lib.AddToShelf(ds.Name, ids, 0)
+}
+
+// RestoreSavedSearch saves a search from a dump, replacing any saved search with the same name.
+func (lib *Library) RestoreSavedSearch(ds DumpSavedSearch) error {
+	q, err := url.ParseQuery(ds.Params)
+	if err != nil {
+		return errors.Wrap(err, "parse options")
+	}
+	opts, err := ParseSearchParams(q)
+	if err != nil {
+		return errors.Wrap(err, "parse options")
+	}
+	_, err = lib.SaveSearch(ds.Name, ds.Query, opts)
+	return err
+}
+
+// IsEmpty returns true if the library has no books.
+func (lib *Library) IsEmpty() (bool, error) {
+	var exists bool
+	if err := lib.QueryRow("select exists (select 1 from books)").Scan(&exists); err != nil {
+		return false, errors.Wrap(err, "check for books")
+	}
+	return !exists, nil
+}
@@ -0,0 +1,161 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDumpTestLibrary returns a library with books, shelves and a saved search, to be exported.
+func newDumpTestLibrary(t *testing.T) *Library {
+	t.Helper()
+	lib := newTestLibrary(t)
+	first := importTestBook(t, lib, Book{Authors: []string{"Frank Herbert"}, Title: "Dune", Series: "Dune", SeriesIndex: 1,
+		Identifiers: map[string]string{"isbn": "9780441013593"}, Genres: []string{"Fiction/Science Fiction"}}, 100)
+	second := importTestBook(t, lib, Book{Authors: []string{"Plato"}, Title: "The Republic"}, 100)
+	if _, err := lib.Exec("update books set created_on='2019-03-04 05:06:07', updated_on='2020-01-02 03:04:05'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.CreateShelf("Reading", "Books to read next"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.AddToShelf("Reading", []int64{second, first}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.CreateShelf("Empty", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.SaveSearch("Big epubs", "dune", SearchOptions{Formats: []string{"epub"}, MinSize: 1 << 20, Sort: SortSize}); err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+// restoreTestDump restores dump into a new library, which shares the books root of lib.
+func restoreTestDump(t *testing.T, lib *Library, dump Dump) *Library {
+	t.Helper()
+	restored := openTestLibrary(t, filepath.Join(lib.booksRoot, "restored.db"), lib.booksRoot)
+	bookIDs := make(map[int64]int64)
+	for _, db := range dump.Books {
+		id, missing, err := restored.RestoreBook(db, testTemplate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(missing) > 0 {
+			t.Errorf("Restoring %s returned missing files %v", db.Title, missing)
+		}
+		bookIDs[db.ID] = id
+	}
+	for _, ds := range dump.Shelves {
+		if err := restored.RestoreShelf(ds, bookIDs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ds := range dump.SavedSearches {
+		if err := restored.RestoreSavedSearch(ds); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return restored
+}
+
+// checkRestoredLibrary checks that restored has the books of newDumpTestLibrary,
+// and its shelves and saved searches if withShelves is true.
+func checkRestoredLibrary(t *testing.T, restored *Library, withShelves bool) {
+	t.Helper()
+	bks, _, err := restored.SearchPaged("", SearchOptions{Sort: SortTitle}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bks) != 2 {
+		t.Fatalf("Restored library has %d books, want 2", len(bks))
+	}
+	dune, republic := bks[0], bks[1]
+	if dune.Title != "Dune" || republic.Title != "The Republic" {
+		t.Fatalf("Restored books are %s and %s", dune.Title, republic.Title)
+	}
+	if dune.Series != "Dune" || dune.SeriesIndex != 1 || dune.Identifiers["isbn"] != "9780441013593" ||
+		!reflect.DeepEqual(dune.Genres, []string{"Fiction/Science Fiction"}) || len(dune.Files) != 1 {
+		t.Errorf("Restored book is %+v", dune)
+	}
+	added := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	updated := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, b := range bks {
+		if !b.Added.Equal(added) || !b.Updated.Equal(updated) {
+			t.Errorf("%s was added %v and updated %v, want %v and %v", b.Title, b.Added, b.Updated, added, updated)
+		}
+	}
+
+	shelves, err := restored.GetShelves()
+	if err != nil {
+		t.Fatal(err)
+	}
+	searches, err := restored.GetSavedSearches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !withShelves {
+		if len(shelves) != 0 || len(searches) != 0 {
+			t.Errorf("Restored library has shelves %v and saved searches %v, want none", shelves, searches)
+		}
+		return
+	}
+	wantOpts := SearchOptions{Formats: []string{"epub"}, MinSize: 1 << 20, Sort: SortSize}
+	if len(searches) != 1 || searches[0].Name != "Big epubs" || searches[0].Query != "dune" || !reflect.DeepEqual(searches[0].Options, wantOpts) {
+		t.Errorf("Restored saved searches are %+v", searches)
+	}
+	if len(shelves) != 2 || shelves[0].Name != "Empty" || shelves[1].Name != "Reading" || shelves[1].Description != "Books to read next" {
+		t.Fatalf("Restored shelves are %+v", shelves)
+	}
+	_, onShelf, err := restored.GetShelf("Reading")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onShelf) != 2 || onShelf[0].ID != republic.ID || onShelf[1].ID != dune.ID {
+		t.Errorf("Restored shelf has books %v, want %d and %d", onShelf, republic.ID, dune.ID)
+	}
+}
+
+func TestDumpJSON(t *testing.T) {
+	lib := newDumpTestLibrary(t)
+	var buf bytes.Buffer
+	if err := lib.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dump, err := ReadDumpJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRestoredLibrary(t, restoreTestDump(t, lib, dump), true)
+}
+
+func TestDumpCSV(t *testing.T) {
+	lib := newDumpTestLibrary(t)
+	var buf bytes.Buffer
+	if err := lib.ExportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dump, err := ReadDumpCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRestoredLibrary(t, restoreTestDump(t, lib, dump), false)
+}
+
+func TestReadDumpJSONArray(t *testing.T) {
+	// Dumps were a JSON array of books before shelves were exported.
+	dump, err := ReadDumpJSON(strings.NewReader(` [{"authors": ["Plato"], "title": "The Republic", "files": []}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dump.Books) != 1 || dump.Books[0].Title != "The Republic" || len(dump.Shelves) != 0 || len(dump.SavedSearches) != 0 {
+		t.Errorf("ReadDumpJSON returned %+v", dump)
+	}
+}
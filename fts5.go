@@ -0,0 +1,13 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+//go:build !sqlite_fts5
+// +build !sqlite_fts5
+
+package books
+
+// Search uses SQLite's FTS5 extension, which go-sqlite3 only compiles in with the sqlite_fts5 build tag.
+// Without it, every library fails to open at runtime, so refuse to build instead.
+// Build with mage, or with go build -tags sqlite_fts5.
+var _ = books_must_be_built_with_tags_sqlite_fts5
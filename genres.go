@@ -0,0 +1,292 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GenreSeparator separates the levels of a hierarchical genre, as in Fiction/Fantasy/Epic.
+const GenreSeparator = "/"
+
+// ErrGenreNotFound is returned when no book has the requested genre.
+var ErrGenreNotFound = errors.New("genre not found")
+
+// likeEscaper escapes the wildcards in a LIKE pattern, for use with escape '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// NormalizeGenre tidies the spacing of a genre and each of its levels, and removes empty levels,
+// so " Fiction / Fantasy" and "Fiction//Fantasy" both become "Fiction/Fantasy".
+func NormalizeGenre(genre string) string {
+	var levels []string
+	for _, level := range strings.Split(genre, GenreSeparator) {
+		if level = strings.Join(strings.Fields(level), " "); level != "" {
+			levels = append(levels, level)
+		}
+	}
+	return strings.Join(levels, GenreSeparator)
+}
+
+// NormalizeGenres normalizes each genre with NormalizeGenre,
+// removing empty genres and genres which differ from an earlier one only by case.
+// It never returns nil, so the result can be used to clear a book's genres.
+func NormalizeGenres(genres []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, g := range genres {
+		g = NormalizeGenre(g)
+		key := strings.ToLower(g)
+		if g == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, g)
+	}
+	return normalized
+}
+
+// genreAncestors returns genre and each of the genres above it, starting with the top level:
+// Fiction, Fiction/Fantasy and Fiction/Fantasy/Epic for Fiction/Fantasy/Epic.
+func genreAncestors(genre string) []string {
+	levels := strings.Split(genre, GenreSeparator)
+	ancestors := make([]string, len(levels))
+	for i := range levels {
+		ancestors[i] = strings.Join(levels[:i+1], GenreSeparator)
+	}
+	return ancestors
+}
+
+// genreCondition is true if the genre g is the genre given as the first argument, or one of its subgenres.
+// The second argument must be the pattern returned by subgenrePattern.
+const genreCondition = `(g.name = ? or g.name like ? escape '\')`
+
+// subgenrePattern returns a LIKE pattern matching the subgenres of genre.
+func subgenrePattern(genre string) string {
+	return likeEscaper.Replace(genre) + GenreSeparator + "%"
+}
+
+// getGenresByBookIds gets genres for each book ID.
+func getGenresByBookIds(ctx context.Context, tx *sql.Tx, ids []int64) (map[int64][]string, error) {
+	m := make(map[int64][]string)
+	if len(ids) == 0 {
+		return m, nil
+	}
+
+	query := "SELECT bg.book_id, g.name FROM books_genres bg JOIN genres g ON bg.genre_id = g.id WHERE bg.book_id IN (" + joinInt64s(ids, ",") + ") ORDER BY bg.id"
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		var genre string
+		if err := rows.Scan(&bookID, &genre); err != nil {
+			return nil, err
+		}
+		m[bookID] = append(m[bookID], genre)
+	}
+
+	return m, rows.Err()
+}
+
+// insertGenre adds a genre to a book, creating it if no book has it yet.
+// Genres are compared without regard to case, so the first spelling used is kept.
+func insertGenre(ctx context.Context, tx *sql.Tx, genre string, bookID int64) error {
+	var genreID int64
+	err := tx.QueryRowContext(ctx, "select id from genres where name=?", genre).Scan(&genreID)
+	if err == sql.ErrNoRows {
+		res, err := tx.ExecContext(ctx, "insert into genres (name) values(?)", genre)
+		if err != nil {
+			return err
+		}
+		genreID, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "insert or ignore into books_genres (book_id, genre_id) values(?, ?)", bookID, genreID); err != nil {
+		return errors.Wrap(err, "inserting genre link")
+	}
+	return nil
+}
+
+// setBookGenres replaces the genres of a book.
+func setBookGenres(ctx context.Context, tx *sql.Tx, bookID int64, genres []string) error {
+	if _, err := tx.ExecContext(ctx, "delete from books_genres where book_id=?", bookID); err != nil {
+		return errors.Wrap(err, "delete genres")
+	}
+	for _, g := range genres {
+		if err := insertGenre(ctx, tx, g, bookID); err != nil {
+			return errors.Wrapf(err, "insert genre %s", g)
+		}
+	}
+	return nil
+}
+
+// reindexGenresInSearch updates the genres of books in books_fts.
+func reindexGenresInSearch(ctx context.Context, tx *sql.Tx, ids []int64) error {
+	genreMap, err := getGenresByBookIds(ctx, tx, ids)
+	if err != nil {
+		return errors.Wrap(err, "get genres")
+	}
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, "update books_fts set genres=? where rowid=?", strings.Join(genreMap[id], " "), id); err != nil {
+			return errors.Wrap(err, "update fts")
+		}
+	}
+	return nil
+}
+
+// RenameGenre renames a genre and its subgenres, so renaming Fiction to Novels also renames Fiction/Fantasy to Novels/Fantasy.
+// If the new name is already a genre, the two are merged.
+// It returns the number of books whose genres changed.
+// If no book has the genre or any of its subgenres, ErrGenreNotFound is returned.
+func (lib *Library) RenameGenre(oldName, newName string) (int, error) {
+	return lib.MergeGenres([]string{oldName}, newName)
+}
+
+// MergeGenres renames each of the genres in from, and their subgenres, to into.
+// It returns the number of books whose genres changed.
+// If none of the genres in from are found, ErrGenreNotFound is returned.
+func (lib *Library) MergeGenres(from []string, into string) (int, error) {
+	into = NormalizeGenre(into)
+	if into == "" {
+		return 0, errors.New("genre to merge into must not be empty")
+	}
+	return lib.changeGenres(from, func(ctx context.Context, tx *sql.Tx, genre, name string, id int64) error {
+		// Names are only matched case insensitively for ASCII, so the matching prefix is the same length as genre.
+		return renameGenre(ctx, tx, id, into+name[len(genre):])
+	})
+}
+
+// DeleteGenre removes a genre and its subgenres from every book.
+// It returns the number of books whose genres changed.
+// If no book has the genre or any of its subgenres, ErrGenreNotFound is returned.
+func (lib *Library) DeleteGenre(name string) (int, error) {
+	return lib.changeGenres([]string{name}, func(ctx context.Context, tx *sql.Tx, genre, name string, id int64) error {
+		_, err := tx.ExecContext(ctx, "delete from genres where id=?", id)
+		return errors.Wrap(err, "delete genre")
+	})
+}
+
+// changeGenres calls change with each of the genres in genres, the name and ID of it or one of its subgenres,
+// and updates the search index of the books whose genres changed.
+// It returns the number of those books, or ErrGenreNotFound if there weren't any.
+func (lib *Library) changeGenres(genres []string, change func(ctx context.Context, tx *sql.Tx, genre, name string, id int64) error) (n int, err error) {
+	ctx := context.Background()
+	tx, err := lib.begin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var ids []int64
+	seen := make(map[int64]bool)
+	for _, genre := range genres {
+		genre = NormalizeGenre(genre)
+		if genre == "" {
+			continue
+		}
+		type match struct {
+			id   int64
+			name string
+		}
+		var matches []match
+		rows, err := tx.QueryContext(ctx, "select g.id, g.name from genres g where "+genreCondition, genre, subgenrePattern(genre))
+		if err != nil {
+			return 0, errors.Wrap(err, "get genres")
+		}
+		for rows.Next() {
+			var m match
+			if err := rows.Scan(&m.id, &m.name); err != nil {
+				rows.Close()
+				return 0, errors.Wrap(err, "get genres")
+			}
+			matches = append(matches, m)
+		}
+		if err := rows.Err(); err != nil {
+			return 0, errors.Wrap(err, "get genres")
+		}
+		rows.Close()
+
+		for _, m := range matches {
+			bookIDs, err := getBookIDsByGenreID(ctx, tx, m.id)
+			if err != nil {
+				return 0, err
+			}
+			for _, id := range bookIDs {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+			if err := change(ctx, tx, genre, m.name, m.id); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return 0, ErrGenreNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "update books set updated_on=datetime() where id in ("+joinInt64s(ids, ",")+")"); err != nil {
+		return 0, errors.Wrap(err, "update books")
+	}
+	if err := reindexGenresInSearch(ctx, tx, ids); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "commit transaction")
+	}
+	return len(ids), nil
+}
+
+// getBookIDsByGenreID gets the IDs of the books with a genre.
+func getBookIDsByGenreID(ctx context.Context, tx *sql.Tx, genreID int64) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, "select book_id from books_genres where genre_id=?", genreID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get books with genre")
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "get books with genre")
+		}
+		ids = append(ids, id)
+	}
+	return ids, errors.Wrap(rows.Err(), "get books with genre")
+}
+
+// renameGenre renames the genre with the given ID.
+// If another genre already has the new name, the genre's books are moved to it instead.
+func renameGenre(ctx context.Context, tx *sql.Tx, id int64, newName string) error {
+	var existingID int64
+	err := tx.QueryRowContext(ctx, "select id from genres where name=? and id!=?", newName, id).Scan(&existingID)
+	if err == sql.ErrNoRows {
+		_, err = tx.ExecContext(ctx, "update genres set updated_on=datetime(), name=? where id=?", newName, id)
+		return errors.Wrap(err, "rename genre")
+	} else if err != nil {
+		return errors.Wrap(err, "find genre")
+	}
+	if _, err := tx.ExecContext(ctx, "insert or ignore into books_genres (book_id, genre_id) select book_id, ? from books_genres where genre_id=?", existingID, id); err != nil {
+		return errors.Wrap(err, "move books to genre")
+	}
+	_, err = tx.ExecContext(ctx, "delete from genres where id=?", id)
+	return errors.Wrap(err, "delete genre")
+}
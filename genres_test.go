@@ -0,0 +1,110 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNormalizeGenre(t *testing.T) {
+	tests := []struct {
+		genre, normalized string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"Fiction", "Fiction"},
+		{" Fiction / Fantasy", "Fiction/Fantasy"},
+		{"Fiction//Fantasy", "Fiction/Fantasy"},
+		{"/Fiction/Fantasy/", "Fiction/Fantasy"},
+		{"Science   Fiction/ Hard\tSF ", "Science Fiction/Hard SF"},
+		// Case is kept.
+		{"fiction/FANTASY", "fiction/FANTASY"},
+	}
+	for _, test := range tests {
+		if normalized := NormalizeGenre(test.genre); normalized != test.normalized {
+			t.Errorf("NormalizeGenre(%q) = %q, want %q", test.genre, normalized, test.normalized)
+		}
+	}
+}
+
+func TestNormalizeGenres(t *testing.T) {
+	tests := []struct {
+		genres, normalized []string
+	}{
+		{nil, []string{}},
+		{[]string{"", " / "}, []string{}},
+		{[]string{"Fiction", "fiction", "Fiction/Fantasy", " FICTION / fantasy "}, []string{"Fiction", "Fiction/Fantasy"}},
+		{[]string{"b", "a"}, []string{"b", "a"}},
+	}
+	for _, test := range tests {
+		if normalized := NormalizeGenres(test.genres); !reflect.DeepEqual(normalized, test.normalized) {
+			t.Errorf("NormalizeGenres(%q) = %q, want %q", test.genres, normalized, test.normalized)
+		}
+	}
+}
+
+func TestChangeGenres(t *testing.T) {
+	lib := newTestLibrary(t)
+	first := importTestBook(t, lib, Book{Title: "First", Genres: []string{"Fiction/Fantasy/Epic", "Classics"}}, 0)
+	second := importTestBook(t, lib, Book{Title: "Second", Genres: []string{"Novels", "Fiction"}}, 0)
+	genres := func() map[int64][]string {
+		t.Helper()
+		books, err := lib.GetBooksByID([]int64{first, second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[int64][]string)
+		for _, b := range books {
+			sort.Strings(b.Genres)
+			m[b.ID] = b.Genres
+		}
+		return m
+	}
+
+	steps := []struct {
+		name   string
+		change func() (int, error)
+		n      int
+		genres map[int64][]string
+	}{
+		{"rename", func() (int, error) { return lib.RenameGenre("fiction", "Stories") }, 2, map[int64][]string{
+			first:  {"Classics", "Stories/Fantasy/Epic"},
+			second: {"Novels", "Stories"},
+		}},
+		{"merge", func() (int, error) { return lib.MergeGenres([]string{"Stories/Fantasy", "Novels"}, "Classics") }, 2, map[int64][]string{
+			first:  {"Classics", "Classics/Epic"},
+			second: {"Classics", "Stories"},
+		}},
+		{"delete", func() (int, error) { return lib.DeleteGenre("Classics") }, 2, map[int64][]string{
+			first:  nil,
+			second: {"Stories"},
+		}},
+	}
+	for _, step := range steps {
+		n, err := step.change()
+		if err != nil {
+			t.Fatalf("%s returned error %v", step.name, err)
+		}
+		if n != step.n {
+			t.Errorf("%s changed %d books, want %d", step.name, n, step.n)
+		}
+		if got := genres(); !reflect.DeepEqual(got, step.genres) {
+			t.Errorf("After %s, genres are %q, want %q", step.name, got, step.genres)
+		}
+	}
+
+	if _, err := lib.DeleteGenre("Fiction"); err != ErrGenreNotFound {
+		t.Errorf("Deleting a missing genre returned error %v, want %v", err, ErrGenreNotFound)
+	}
+	books, _, err := lib.SearchPaged("", SearchOptions{Genres: []string{"stories"}}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || books[0].ID != second {
+		t.Errorf("Searching for genre stories returned %v, want book %d", books, second)
+	}
+}
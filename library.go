@@ -5,9 +5,14 @@
 package books
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
-	"database/sql/driver"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
@@ -15,6 +20,7 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
@@ -95,34 +101,418 @@ create virtual table books_fts using fts4 (author, series, title, extension, tag
 create index idx_books_nocase_title on books(title collate nocase);
 `
 
-func init() {
-	// Add a connect hook to set synchronous = off for all connections.
-	// This improves performance, especially during import,
-	// but since changes aren't immediately synced to disk, data could be lost during a power outage or sudden OS crash.
-	sql.Register("sqlite3async",
-		&sqlite3.SQLiteDriver{
-			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
-				conn.Exec("pragma foreign_keys=on", []driver.Value{})
-				conn.Exec("pragma synchronous=off", []driver.Value{})
-				return nil
-			},
-		})
+// migrations are applied in order to bring a library up to date with the current schema.
+// The number of migrations already applied to a library is stored in its user_version pragma,
+// so existing migrations must never be changed or reordered; append new ones to the end.
+var migrations = []func(tx *sql.Tx) error{
+	execMigration(`create table hash_cache (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+path text not null unique,
+file_size integer not null,
+file_mtime timestamp not null,
+hash text not null
+);`),
+	execMigration(`create table unsorted_files (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+extension text not null,
+original_filename text not null,
+file_size integer not null,
+file_mtime timestamp not null,
+hash text not null unique,
+tags text not null default '',
+source text
+);`),
+	execMigration(`alter table books add column series_index real;
+create table identifiers (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+book_id integer not null references books(id) on delete cascade,
+type text not null,
+value text not null,
+unique (book_id, type)
+);`),
+	migrateToFTS5,
+	addTitleKeys,
+	addAuthorSortNames,
+	addGenres,
+	execMigration(`create table shelves (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+name text not null unique collate nocase,
+description text not null default ''
+);
+
+create table shelves_books (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+shelf_id integer not null references shelves(id) on delete cascade,
+book_id integer not null references books(id) on delete cascade,
+position integer not null,
+unique (shelf_id, book_id)
+);
+create index idx_shelves_books_book_id on shelves_books(book_id);`),
+	execMigration(`create table saved_searches (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+name text not null unique collate nocase,
+query text not null default '',
+params text not null default ''
+);`),
+	execMigration(`create table users (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+name text not null unique collate nocase,
+password_hash text not null,
+role text not null
+);`),
+	execMigration(`create table api_keys (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+name text not null unique collate nocase,
+key_hash text not null unique,
+user_id integer not null references users(id) on delete cascade,
+scopes text not null,
+expires_on timestamp,
+last_used_on timestamp
+);
+create index idx_api_keys_user_id on api_keys(user_id);`),
+	execMigration(`create table sessions (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+token_hash text not null unique,
+user_name text not null collate nocase,
+expires_on timestamp not null
+);
+create index idx_sessions_user_name on sessions(user_name);`),
+}
+
+// migrateToFTS5 moves the search index from FTS4 to FTS5, for ranking and highlighting search results.
+func migrateToFTS5(tx *sql.Tx) error {
+	_, err := tx.Exec(`create virtual table books_fts5 using fts5 (author, series, title, extension, tags, filename, source);
+insert into books_fts5 (rowid, author, series, title, extension, tags, filename, source)
+select docid, author, series, title, extension, tags, filename, source from books_fts;
+drop table books_fts;
+alter table books_fts5 rename to books_fts;`)
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		return errors.Wrap(err, "books must be built with -tags sqlite_fts5")
+	}
+	return err
+}
+
+// addTitleKeys adds the title_key column, used to find an existing book regardless of case and diacritics in its title.
+func addTitleKeys(tx *sql.Tx) error {
+	if _, err := tx.Exec(`alter table books add column title_key text;
+drop index idx_books_nocase_title;`); err != nil {
+		return err
+	}
+	if _, err := updateTitleKeys(context.Background(), tx); err != nil {
+		return err
+	}
+	_, err := tx.Exec("create index idx_books_title_key on books(title_key)")
+	return err
+}
+
+// updateTitleKeys sets the title_key of each book whose key doesn't match its title,
+// and returns the number of books updated.
+func updateTitleKeys(ctx context.Context, tx *sql.Tx) (int, error) {
+	rows, err := tx.QueryContext(ctx, "select id, title, ifnull(title_key, '') from books")
+	if err != nil {
+		return 0, errors.Wrap(err, "get titles")
+	}
+	keys := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var title, key string
+		if err := rows.Scan(&id, &title, &key); err != nil {
+			rows.Close()
+			return 0, errors.Wrap(err, "get titles")
+		}
+		if newKey := FoldKey(title); newKey != key {
+			keys[id] = newKey
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, errors.Wrap(err, "get titles")
+	}
+	rows.Close()
+	for id, key := range keys {
+		if _, err := tx.ExecContext(ctx, "update books set title_key=? where id=?", key, id); err != nil {
+			return 0, errors.Wrap(err, "update title key")
+		}
+	}
+	return len(keys), nil
+}
+
+// addAuthorSortNames adds the sort_name column to authors, used to sort books by author.
+func addAuthorSortNames(tx *sql.Tx) error {
+	if _, err := tx.Exec("alter table authors add column sort_name text"); err != nil {
+		return err
+	}
+	rows, err := tx.Query("select id, name from authors")
+	if err != nil {
+		return errors.Wrap(err, "get authors")
+	}
+	sortNames := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "get authors")
+		}
+		sortNames[id] = AuthorSortName(name)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "get authors")
+	}
+	rows.Close()
+	for id, sortName := range sortNames {
+		if _, err := tx.Exec("update authors set sort_name=? where id=?", sortName, id); err != nil {
+			return errors.Wrap(err, "set author sort name")
+		}
+	}
+	return nil
+}
+
+// addGenres adds book-level genres, and a genres column to the search index.
+func addGenres(tx *sql.Tx) error {
+	_, err := tx.Exec(`create table genres (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+name text not null unique collate nocase
+);
+
+create table books_genres (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+book_id integer not null references books(id) on delete cascade,
+genre_id integer not null references genres(id) on delete cascade,
+unique (book_id, genre_id)
+);
+create index idx_books_genres_genre_id on books_genres(genre_id);
+
+create virtual table books_fts_genres using fts5 (author, series, title, extension, tags, filename, source, genres);
+insert into books_fts_genres (rowid, author, series, title, extension, tags, filename, source)
+select rowid, author, series, title, extension, tags, filename, source from books_fts;
+drop table books_fts;
+alter table books_fts_genres rename to books_fts;`)
+	return err
+}
+
+// execMigration returns a migration which executes query.
+func execMigration(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// migrate applies any migrations which haven't been applied to db yet.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("pragma user_version").Scan(&version); err != nil {
+		return errors.Wrap(err, "get schema version")
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "begin migration")
+		}
+		if err := migrations[version](tx); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "migrate schema to version %d", version+1)
+		}
+		// Pragmas can't take bound parameters.
+		if _, err := tx.Exec("pragma user_version=" + strconv.Itoa(version+1)); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "set schema version")
+		}
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err, "commit migration to version %d", version+1)
+		}
+	}
+	return nil
+}
+
+// Options control how the database underlying a library is opened.
+// Empty fields leave the SQLite driver's defaults in place.
+type Options struct {
+	// JournalMode is the SQLite journal mode: wal, delete, truncate, persist, memory, or off.
+	// WAL lets readers continue while another connection, such as an import, is writing.
+	JournalMode string
+	// Synchronous is the SQLite synchronous level: off, normal, full, or extra.
+	// With off, changes aren't immediately synced to disk, so they could be lost during a power outage or sudden OS crash.
+	Synchronous string
+	// BusyTimeout is how long to wait for another connection to release its lock before giving up.
+	BusyTimeout time.Duration
+}
+
+// DefaultOptions are safe for a long-running books serve process sharing the library with imports.
+var DefaultOptions = Options{
+	JournalMode: "wal",
+	Synchronous: "normal",
+	BusyTimeout: 5 * time.Second,
+}
+
+// dsn returns the data source name for opening filename with these options.
+// Connections for writing take the write lock as soon as a transaction begins, so they wait for the busy timeout there,
+// instead of failing partway through a transaction. Read-only connections can't modify the library.
+func (o Options) dsn(filename string, readOnly bool) string {
+	params := url.Values{}
+	params.Set("_foreign_keys", "1")
+	if readOnly {
+		params.Set("_query_only", "1")
+	} else {
+		params.Set("_txlock", "immediate")
+	}
+	if o.JournalMode != "" {
+		params.Set("_journal_mode", o.JournalMode)
+	}
+	if o.Synchronous != "" {
+		params.Set("_synchronous", o.Synchronous)
+	}
+	if o.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(int64(o.BusyTimeout/time.Millisecond), 10))
+	}
+	return sqliteURI(filename, params)
+}
+
+// sqliteURI returns an SQLite URI for opening filename with params.
+// The filename is escaped, so that characters such as ? and # in it aren't taken as the start of the parameters.
+func sqliteURI(filename string, params url.Values) string {
+	uri := "file:" + url.PathEscape(filename)
+	if len(params) > 0 {
+		uri += "?" + params.Encode()
+	}
+	return uri
 }
 
 // Library represents a set of books in persistent storage.
+// The embedded DB is used for writing, and readDB for searching and loading books,
+// so that reads can run alongside a write when the library is in WAL mode.
 type Library struct {
 	*sql.DB
+	readDB    *sql.DB
 	filename  string
 	booksRoot string
 }
 
-// OpenLibrary opens a library stored in a file.
-func OpenLibrary(filename, booksRoot string) (*Library, error) {
-	db, err := sql.Open("sqlite3async", filename)
+// OpenLibrary opens a library stored in a file, using opts to configure its database connections.
+func OpenLibrary(filename, booksRoot string, opts Options) (*Library, error) {
+	db, err := sql.Open("sqlite3", opts.dsn(filename, false))
 	if err != nil {
 		return nil, err
 	}
-	return &Library{db, filename, booksRoot}, nil
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "migrate library")
+	}
+	readDB, err := sql.Open("sqlite3", opts.dsn(filename, true))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Library{db, readDB, filename, booksRoot}, nil
+}
+
+// OpenLibraryReadOnly opens a library without migrating it, and without allowing any changes to its file,
+// such as a snapshot written by Backup.
+// The library must already be at the current schema version.
+// Nothing else may change the library while it's open; use OpenLibraryQueryOnly for a library which is in use.
+func OpenLibraryReadOnly(filename, booksRoot string) (*Library, error) {
+	// The library is immutable, so SQLite doesn't even create its journal files.
+	return openUnmigrated(sqliteURI(filename, url.Values{"mode": {"ro"}, "immutable": {"1"}, "_query_only": {"1"}}), filename, booksRoot)
+}
+
+// OpenLibraryQueryOnly opens an existing library without migrating it, and without allowing any changes through the returned Library,
+// such as for a dry run. Unlike OpenLibraryReadOnly, changes made by other processes while it's open are seen,
+// but SQLite may still create the library's journal files.
+// opts should be the ones the library is usually opened with, since its journal mode is set from them.
+// The library must already be at the current schema version.
+func OpenLibraryQueryOnly(filename, booksRoot string, opts Options) (*Library, error) {
+	// mode=rw doesn't create the library if it doesn't exist.
+	params := url.Values{"mode": {"rw"}, "_query_only": {"1"}}
+	// The driver sets the journal mode of every connection, so it has to be the one the library already uses.
+	if opts.JournalMode != "" {
+		params.Set("_journal_mode", opts.JournalMode)
+	}
+	if opts.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(int64(opts.BusyTimeout/time.Millisecond), 10))
+	}
+	return openUnmigrated(sqliteURI(filename, params), filename, booksRoot)
+}
+
+// openUnmigrated opens a library using dsn for both of its connections, after checking that it's at the current schema version.
+func openUnmigrated(dsn, filename, booksRoot string) (*Library, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	var version int
+	if err := db.QueryRow("pragma user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "get schema version")
+	}
+	if version != len(migrations) {
+		db.Close()
+		return nil, errors.Errorf("library is at schema version %d, not %d", version, len(migrations))
+	}
+	readDB, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Library{db, readDB, filename, booksRoot}, nil
+}
+
+// Close closes the library's database connections.
+func (lib *Library) Close() error {
+	readErr := lib.readDB.Close()
+	if err := lib.DB.Close(); err != nil {
+		return err
+	}
+	return readErr
+}
+
+// beginAttempts is the number of times begin tries to start a transaction while the library is busy.
+const beginAttempts = 3
+
+// begin starts a transaction for writing to the library, which is rolled back if ctx is canceled.
+// If another connection holds the write lock for longer than the busy timeout, it tries again.
+func (lib *Library) begin(ctx context.Context) (*sql.Tx, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var tx *sql.Tx
+		tx, err = lib.BeginTx(ctx, nil)
+		if err == nil || !isBusy(err) || attempt == beginAttempts {
+			return tx, err
+		}
+		log.Printf("Library is busy, retrying (attempt %d of %d)", attempt+1, beginAttempts)
+		select {
+		case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// isBusy returns true if err is an SQLite error caused by another connection holding a lock.
+func isBusy(err error) bool {
+	if sqliteErr, ok := errors.Cause(err).(sqlite3.Error); ok {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
 }
 
 // CreateLibrary initializes a new library in the specified file.
@@ -131,7 +521,7 @@ func OpenLibrary(filename, booksRoot string) (*Library, error) {
 // call OpenLibrary.
 func CreateLibrary(filename string) error {
 	log.Printf("Creating library in %s\n", filename)
-	db, err := sql.Open("sqlite3", filename)
+	db, err := sql.Open("sqlite3", sqliteURI(filename, nil))
 	if err != nil {
 		return errors.Wrap(err, "Create library")
 	}
@@ -141,50 +531,159 @@ func CreateLibrary(filename string) error {
 	if err != nil {
 		return errors.Wrap(err, "Create library")
 	}
+	if err := migrate(db); err != nil {
+		return errors.Wrap(err, "Create library")
+	}
 
 	log.Printf("Library created in %s\n", filename)
 	return nil
 }
 
+// DuplicatePolicy decides what ImportBook does with a file whose hash already belongs to a different book.
+type DuplicatePolicy int
+
+const (
+	// DuplicateImport imports the file into its own book anyway, and reports the collision.
+	DuplicateImport DuplicatePolicy = iota
+	// DuplicateSkip doesn't import the file.
+	DuplicateSkip
+	// DuplicateAttach adds the file's tags to the existing file with the same hash, instead of creating a new book.
+	DuplicateAttach
+)
+
+// ParseDuplicatePolicy parses a duplicate policy by name: import, skip, or attach.
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, error) {
+	switch strings.ToLower(name) {
+	case "import", "":
+		return DuplicateImport, nil
+	case "skip":
+		return DuplicateSkip, nil
+	case "attach":
+		return DuplicateAttach, nil
+	}
+	return DuplicateImport, errors.Errorf("unknown duplicate policy %s", name)
+}
+
+// ImportStatus is the outcome of importing a file with ImportBook.
+type ImportStatus int
+
+const (
+	// ImportImported means the file was added to the library.
+	ImportImported ImportStatus = iota
+	// ImportDuplicate means the book already had a file with the same hash.
+	ImportDuplicate
+	// ImportSkipped means another book already had a file with the same hash, and the file was skipped.
+	ImportSkipped
+	// ImportAttached means another book already had a file with the same hash, and the file was attached to it.
+	ImportAttached
+)
+
+// ImportResult describes what ImportBook did with a file.
+type ImportResult struct {
+	Status ImportStatus
+	// BookID is the ID of the book the file was imported into, attached to, or found in.
+	BookID int64
+	// CollidingBookIDs holds the IDs of other books which already had a file with the same hash.
+	CollidingBookIDs []int64
+}
+
 // ImportBook adds a book to a library.
 // The file referred to by book.OriginalFilename will either be copied or moved to the location referred to by book.CurrentFilename, relative to the configured books root.
-// The book will not be imported if another book already in the library has the same hash.
-func (lib *Library) ImportBook(book Book, tmpl *template.Template, move bool) error {
+// The book will not be imported if the book with the same title and authors already has a file with the same hash.
+// If a different book has a file with the same hash, policy decides what happens, and the collision is reported in the result.
+func (lib *Library) ImportBook(book Book, tmpl *template.Template, move bool, policy DuplicatePolicy) (ImportResult, error) {
+	return lib.ImportBookContext(context.Background(), book, tmpl, move, policy)
+}
+
+// ImportBookContext is like ImportBook, but stops if ctx is canceled before the book is imported.
+func (lib *Library) ImportBookContext(ctx context.Context, book Book, tmpl *template.Template, move bool, policy DuplicatePolicy) (ImportResult, error) {
+	var result ImportResult
 	if len(book.Files) != 1 {
-		return errors.New("Book to import must contain only one file")
+		return result, errors.New("Book to import must contain only one file")
 	}
-	tx, err := lib.Begin()
+	tx, err := lib.begin(ctx)
 	if err != nil {
-		return err
+		return result, err
 	}
 
-	existingBookID, found, err := getBookIDByTitleAndAuthors(tx, book.Title, book.Authors)
+	existingBookID, found, err := getBookIDByTitleAndAuthors(ctx, tx, book.Title, book.Authors)
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "find existing book")
+		return result, errors.Wrap(err, "find existing book")
+	}
+	hashBookIDs, err := getBookIDsByHash(ctx, tx, book.Files[0].Hash)
+	if err != nil {
+		tx.Rollback()
+		return result, errors.Wrap(err, "find books with the same hash")
+	}
+	for _, id := range hashBookIDs {
+		if !found || id != existingBookID {
+			result.CollidingBookIDs = append(result.CollidingBookIDs, id)
+		}
+	}
+	if len(result.CollidingBookIDs) > 0 && len(result.CollidingBookIDs) == len(hashBookIDs) {
+		switch policy {
+		case DuplicateSkip:
+			tx.Commit()
+			log.Printf("Not importing file with the same hash as book %d: authors: %s title: %s", result.CollidingBookIDs[0], book.Authors, book.Title)
+			if move {
+				if err := os.Remove(book.Files[0].OriginalFilename); err != nil {
+					log.Printf("Error deleting %s: %v", book.Files[0].OriginalFilename, err)
+				}
+			}
+			result.Status = ImportSkipped
+			result.BookID = result.CollidingBookIDs[0]
+			return result, nil
+		case DuplicateAttach:
+			result.BookID = result.CollidingBookIDs[0]
+			if err := attachFile(ctx, tx, result.BookID, book.Files[0], tmpl); err != nil {
+				tx.Rollback()
+				return result, errors.Wrap(err, "attach file")
+			}
+			if err := tx.Commit(); err != nil {
+				return result, errors.Wrap(err, "attach file")
+			}
+			log.Printf("Attached file with authors: %s title: %s to book %d with the same hash", book.Authors, book.Title, result.BookID)
+			if move {
+				if err := os.Remove(book.Files[0].OriginalFilename); err != nil {
+					log.Printf("Error deleting %s: %v", book.Files[0].OriginalFilename, err)
+				}
+			}
+			result.Status = ImportAttached
+			return result, nil
+		}
 	}
 	if !found {
-		res, err := tx.Exec("insert into books (series, title) values(?, ?)", book.Series, book.Title)
+		res, err := tx.ExecContext(ctx, "insert into books (series, series_index, title, title_key) values(?, ?, ?, ?)", book.Series, seriesIndexValue(book), book.Title, FoldKey(book.Title))
 		if err != nil {
 			tx.Rollback()
-			return errors.Wrap(err, "Insert new book")
+			return result, errors.Wrap(err, "Insert new book")
 		}
 		book.ID, err = res.LastInsertId()
 		if err != nil {
 			tx.Rollback()
-			return errors.Wrap(err, "sett new book ID")
+			return result, errors.Wrap(err, "sett new book ID")
 		}
 		for _, author := range book.Authors {
-			if err := insertAuthor(tx, author, &book); err != nil {
+			if err := insertAuthor(ctx, tx, author, &book); err != nil {
 				tx.Rollback()
-				return errors.Wrapf(err, "inserting author %s", author)
+				return result, errors.Wrapf(err, "inserting author %s", author)
 			}
 		}
+		if err := insertIdentifiers(ctx, tx, book.ID, book.Identifiers); err != nil {
+			tx.Rollback()
+			return result, errors.Wrap(err, "inserting identifiers")
+		}
+		book.Genres = NormalizeGenres(book.Genres)
+		if err := setBookGenres(ctx, tx, book.ID, book.Genres); err != nil {
+			tx.Rollback()
+			return result, errors.Wrap(err, "inserting genres")
+		}
 
 	} else {
-		existingBooksList, err := getBooksByID(tx, []int64{existingBookID})
+		existingBooksList, err := getBooksByID(ctx, tx, []int64{existingBookID})
 		if err != nil {
-			return errors.Wrap(err, "get existing book")
+			return result, errors.Wrap(err, "get existing book")
 		}
 		existingBook := existingBooksList[0]
 		for _, f := range existingBook.Files {
@@ -194,20 +693,31 @@ func (lib *Library) ImportBook(book Book, tmpl *template.Template, move bool) er
 			tx.Commit()
 			log.Printf("Not importing duplicate file into book with authors: %s title: %s", book.Authors, book.Title)
 			if move {
-				err := os.Remove(book.Files[0].OriginalFilename)
-				log.Printf("Error deleting %s: %v", f.OriginalFilename, err)
+				if err := os.Remove(book.Files[0].OriginalFilename); err != nil {
+					log.Printf("Error deleting %s: %v", book.Files[0].OriginalFilename, err)
+				}
 			}
-			return nil
+			result.Status = ImportDuplicate
+			result.BookID = existingBookID
+			return result, nil
 		}
 		// Update the existing book series only if it's empty
 		existingBook.Series = book.Series
-		err = lib.updateBook(tx, existingBook, tmpl, false)
+		existingBook.SeriesIndex = book.SeriesIndex
+		// Imported genres are added to the existing ones.
+		existingBook.Genres = append(existingBook.Genres, book.Genres...)
+		err = lib.updateBook(ctx, tx, existingBook, tmpl, false)
 		if err != nil {
-			return errors.Wrap(err, "update book")
+			return result, errors.Wrap(err, "update book")
+		}
+		// Existing identifiers take precedence over the imported ones.
+		if err := insertIdentifiers(ctx, tx, existingBookID, book.Identifiers); err != nil {
+			tx.Rollback()
+			return result, errors.Wrap(err, "inserting identifiers")
 		}
-		existingBooksList, err = getBooksByID(tx, []int64{existingBookID})
+		existingBooksList, err = getBooksByID(ctx, tx, []int64{existingBookID})
 		if err != nil {
-			return errors.Wrap(err, "get existing book")
+			return result, errors.Wrap(err, "get existing book")
 		}
 		existingBook = existingBooksList[0]
 		existingBook.Files = append(existingBook.Files, book.Files[0])
@@ -217,54 +727,250 @@ func (lib *Library) ImportBook(book Book, tmpl *template.Template, move bool) er
 	bf := &book.Files[len(book.Files)-1]
 	bf.CurrentFilename, err = bf.Filename(tmpl, &book)
 	if err != nil {
-		return errors.Wrap(err, "get current filename")
+		return result, errors.Wrap(err, "get current filename")
 	}
-	res, err := tx.Exec(`insert into files (book_id, extension, original_filename, filename, file_size, file_mtime, hash, source)
+	res, err := tx.ExecContext(ctx, `insert into files (book_id, extension, original_filename, filename, file_size, file_mtime, hash, source)
 	values (?, ?, ?, ?, ?, ?, ?, ?)`,
 		book.ID, bf.Extension, bf.OriginalFilename, bf.CurrentFilename, bf.FileSize, bf.FileMtime, bf.Hash, bf.Source)
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "Inserting book file into the db")
+		return result, errors.Wrap(err, "Inserting book file into the db")
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "Fetching new book ID")
+		return result, errors.Wrap(err, "Fetching new book ID")
 	}
 	book.Files[len(book.Files)-1].ID = id
 
 	for _, tag := range bf.Tags {
-		if err := insertTag(tx, tag, bf); err != nil {
+		if err := insertTag(ctx, tx, tag, bf); err != nil {
 			tx.Rollback()
-			return errors.Wrapf(err, "inserting tag %s", tag)
+			return result, errors.Wrapf(err, "inserting tag %s", tag)
 		}
 	}
 
-	err = indexBookInSearch(tx, &book, !found)
+	err = indexBookInSearch(ctx, tx, &book, !found)
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "index book in search")
+		return result, errors.Wrap(err, "index book in search")
 	}
 
 	err = lib.insertFile(*bf, move)
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "insert book")
+		return result, errors.Wrap(err, "insert book")
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		return errors.Wrap(err, "import book")
+		return result, errors.Wrap(err, "import book")
 	}
 	log.Printf("Imported book: %s: %s, ID = %d", strings.Join(book.Authors, " & "), book.Title, book.ID)
+	if len(result.CollidingBookIDs) > 0 {
+		log.Printf("File %s has the same hash as a file in book(s) %s", book.Files[len(book.Files)-1].OriginalFilename, joinInt64s(result.CollidingBookIDs, ", "))
+	}
+	result.Status = ImportImported
+	result.BookID = book.ID
+	return result, nil
+}
 
-	return nil
+// ImportReader imports a book whose one file's contents are read from r, such as a file uploaded to the web server.
+// book.Files[0] describes the file, and its hash and size are set from the contents.
+// Its OriginalFilename is only recorded, not read from.
+func (lib *Library) ImportReader(r io.Reader, book Book, tmpl *template.Template, policy DuplicatePolicy) (ImportResult, error) {
+	return lib.ImportReaderContext(context.Background(), r, book, tmpl, policy)
 }
 
-func indexBookInSearch(tx *sql.Tx, book *Book, createNew bool) error {
-	bf := book.Files[len(book.Files)-1]
-	joinedTags := strings.Join(bf.Tags, " ")
+// ImportReaderContext is like ImportReader, but stops if ctx is canceled before the book is imported.
+func (lib *Library) ImportReaderContext(ctx context.Context, r io.Reader, book Book, tmpl *template.Template, policy DuplicatePolicy) (ImportResult, error) {
+	if len(book.Files) != 1 {
+		return ImportResult{}, errors.New("Book to import must contain only one file")
+	}
+	// Write the contents into the books root, so they can be renamed into place once their hash is known.
+	fp, err := ioutil.TempFile(lib.booksRoot, ".import-")
+	if err != nil {
+		return ImportResult{}, errors.Wrap(err, "create temporary file")
+	}
+	tmpName := fp.Name()
+	defer os.Remove(tmpName)
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(fp, hasher), r)
+	if closeErr := fp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return ImportResult{}, errors.Wrap(err, "write file")
+	}
+	bf := &book.Files[0]
+	bf.Hash = fmt.Sprintf("%x", hasher.Sum(nil))
+	bf.FileSize = size
+
+	// Put the file in place first, so ImportBook finds it and doesn't copy from the original filename.
+	hashPath := filepath.Join(lib.booksRoot, bf.HashPath())
+	placed := false
+	if _, err := os.Stat(hashPath); os.IsNotExist(err) {
+		if err := moveOrCopyFile(tmpName, hashPath+".tmp", true); err != nil {
+			return ImportResult{}, errors.Wrap(err, "move file")
+		}
+		if err := os.Rename(hashPath+".tmp", hashPath); err != nil {
+			return ImportResult{}, errors.Wrap(err, "rename temporary file")
+		}
+		placed = true
+	} else if err != nil {
+		return ImportResult{}, errors.Wrap(err, "stat")
+	}
+
+	result, err := lib.ImportBookContext(ctx, book, tmpl, false, policy)
+	if placed && (err != nil || result.Status != ImportImported) {
+		// No file in the library refers to the contents.
+		if err := os.Remove(hashPath); err != nil {
+			log.Printf("Error deleting %s: %v", hashPath, err)
+		}
+	}
+	return result, err
+}
+
+// getBookIDsByHash gets the IDs of books with a file with the given hash.
+func getBookIDsByHash(ctx context.Context, tx *sql.Tx, hash string) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, "select distinct book_id from files where hash=? order by book_id", hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// attachFile adds the tags of bf to each file in a book with the same hash as bf,
+// renames those files according to tmpl, and reindexes the book in search.
+func attachFile(ctx context.Context, tx *sql.Tx, bookID int64, bf BookFile, tmpl *template.Template) error {
+	bks, err := getBooksByID(ctx, tx, []int64{bookID})
+	if err != nil {
+		return errors.Wrap(err, "get book")
+	}
+	if len(bks) == 0 {
+		return ErrBookNotFound
+	}
+	book := bks[0]
+	for _, f := range book.Files {
+		if f.Hash != bf.Hash {
+			continue
+		}
+		for _, tag := range bf.Tags {
+			if stringSliceContains(f.Tags, tag) {
+				continue
+			}
+			if err := insertTag(ctx, tx, tag, &f); err != nil {
+				return errors.Wrapf(err, "inserting tag %s", tag)
+			}
+			f.Tags = append(f.Tags, tag)
+		}
+		newFn, err := f.Filename(tmpl, &book)
+		if err != nil {
+			return errors.Wrap(err, "get filename")
+		}
+		if newFn == f.CurrentFilename {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "update files set updated_on=datetime(), filename=? where id=?", newFn, f.ID); err != nil {
+			return errors.Wrap(err, "update filename")
+		}
+	}
+	return reindexBookInSearch(ctx, tx, bookID)
+}
+
+// reindexBookInSearch replaces a book's entry in books_fts with its current authors, series, title and files.
+func reindexBookInSearch(ctx context.Context, tx *sql.Tx, bookID int64) error {
+	if _, err := tx.ExecContext(ctx, "delete from books_fts where rowid=?", bookID); err != nil {
+		return errors.Wrap(err, "delete book from fts")
+	}
+	bks, err := getBooksByID(ctx, tx, []int64{bookID})
+	if err != nil {
+		return errors.Wrap(err, "get book")
+	}
+	if len(bks) == 0 {
+		return ErrBookNotFound
+	}
+	return indexBookInSearch(ctx, tx, &bks[0], true)
+}
+
+// reindexBatchSize is the number of books loaded at a time while rebuilding the search index.
+const reindexBatchSize = 500
+
+// Reindex renormalizes the title keys used to find existing books, and rebuilds the search index from the library,
+// so that books added before a change to normalization are found the same way as new ones.
+// It returns the number of title keys which changed, and the number of books indexed.
+func (lib *Library) Reindex() (keysUpdated, indexed int, err error) {
+	ctx := context.Background()
+	tx, err := lib.begin(ctx)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	keysUpdated, err = updateTitleKeys(ctx, tx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err = tx.ExecContext(ctx, "delete from books_fts"); err != nil {
+		return 0, 0, errors.Wrap(err, "clear search index")
+	}
+	var ids []int64
+	rows, err := tx.QueryContext(ctx, "select id from books order by id")
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "get book IDs")
+	}
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, errors.Wrap(err, "get book IDs")
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, 0, errors.Wrap(err, "get book IDs")
+	}
+	rows.Close()
+	for start := 0; start < len(ids); start += reindexBatchSize {
+		end := start + reindexBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var bks []Book
+		bks, err = getBooksByID(ctx, tx, ids[start:end])
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "get books")
+		}
+		for i := range bks {
+			if err = indexBookInSearch(ctx, tx, &bks[i], true); err != nil {
+				return 0, 0, errors.Wrapf(err, "index book %d", bks[i].ID)
+			}
+			indexed++
+		}
+	}
+	if _, err = tx.ExecContext(ctx, "insert into books_fts (books_fts) values('optimize')"); err != nil {
+		return 0, 0, errors.Wrap(err, "optimize search index")
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, 0, errors.Wrap(err, "commit transaction")
+	}
+	return keysUpdated, indexed, nil
+}
+
+func indexBookInSearch(ctx context.Context, tx *sql.Tx, book *Book, createNew bool) error {
 	if createNew {
 		// Index book for searching.
 		extensions := []string{}
@@ -276,15 +982,15 @@ func indexBookInSearch(tx *sql.Tx, book *Book, createNew bool) error {
 			sources = append(sources, f.Source)
 		}
 
-		_, err := tx.Exec(`insert into books_fts (docid, author, series, title, extension, tags,  source)
-	values (?, ?, ?, ?, ?, ?, ?)`,
-			book.ID, strings.Join(book.Authors, " & "), book.Series, book.Title, strings.Join(extensions, " "), strings.Join(tags, " "), strings.Join(sources, " "))
+		_, err := tx.ExecContext(ctx, `insert into books_fts (rowid, author, series, title, extension, tags,  source, genres)
+	values (?, ?, ?, ?, ?, ?, ?, ?)`,
+			book.ID, strings.Join(book.Authors, " & "), book.Series, book.Title, strings.Join(extensions, " "), strings.Join(tags, " "), strings.Join(sources, " "), strings.Join(book.Genres, " "))
 		if err != nil {
 			return err
 		}
 		return nil
 	}
-	rows, err := tx.Query("select docid, tags, extension, source from books_fts where docid=?", book.ID)
+	rows, err := tx.QueryContext(ctx, "select rowid, tags, extension, source from books_fts where rowid=?", book.ID)
 	if err != nil {
 		return err
 	}
@@ -295,6 +1001,8 @@ func indexBookInSearch(tx *sql.Tx, book *Book, createNew bool) error {
 		}
 		return errors.Errorf("Existing book %d not found in FTS", book.ID)
 	}
+	bf := book.Files[len(book.Files)-1]
+	joinedTags := strings.Join(bf.Tags, " ")
 	var id int64
 	var tags, extension, source string
 	err = rows.Scan(&id, &tags, &extension, &source)
@@ -303,7 +1011,7 @@ func indexBookInSearch(tx *sql.Tx, book *Book, createNew bool) error {
 	}
 	rows.Close()
 
-	_, err = tx.Exec("update books_fts set tags=?, extension=?, source=?, series=? where docid=?", tags+" "+joinedTags, extension+" "+bf.Extension, source+" "+bf.Source, book.Series, id)
+	_, err = tx.ExecContext(ctx, "update books_fts set tags=?, extension=?, source=?, series=? where rowid=?", tags+" "+joinedTags, extension+" "+bf.Extension, source+" "+bf.Source, book.Series, id)
 	if err != nil {
 		return err
 	}
@@ -311,13 +1019,13 @@ func indexBookInSearch(tx *sql.Tx, book *Book, createNew bool) error {
 }
 
 // insertAuthor inserts an author into the database.
-func insertAuthor(tx *sql.Tx, author string, book *Book) error {
+func insertAuthor(ctx context.Context, tx *sql.Tx, author string, book *Book) error {
 	var authorID int64
-	row := tx.QueryRow("select id from authors where name=?", author)
+	row := tx.QueryRowContext(ctx, "select id from authors where name=?", author)
 	err := row.Scan(&authorID)
 	if err == sql.ErrNoRows {
 		// Insert the author
-		res, err := tx.Exec("insert into authors (name) values(?)", author)
+		res, err := tx.ExecContext(ctx, "insert into authors (name, sort_name) values(?, ?)", author, AuthorSortName(author))
 		if err != nil {
 			return err
 		}
@@ -330,20 +1038,39 @@ func insertAuthor(tx *sql.Tx, author string, book *Book) error {
 	}
 	// Author inserted, insert the link
 	// For two authors in the same book with the same name, only insert one.
-	if _, err := tx.Exec("insert or ignore into books_authors (book_id, author_id) values(?, ?)", book.ID, authorID); err != nil {
+	if _, err := tx.ExecContext(ctx, "insert or ignore into books_authors (book_id, author_id) values(?, ?)", book.ID, authorID); err != nil {
 		return err
 	}
 	return nil
 }
 
+// insertIdentifiers links identifiers, such as ISBNs, to a book.
+// Identifiers whose type the book already has are ignored.
+func insertIdentifiers(ctx context.Context, tx *sql.Tx, bookID int64, identifiers map[string]string) error {
+	for typ, value := range identifiers {
+		if _, err := tx.ExecContext(ctx, "insert or ignore into identifiers (book_id, type, value) values(?, ?, ?)", bookID, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seriesIndexValue returns the value to store in books.series_index: NULL if the book isn't in a series.
+func seriesIndexValue(book Book) interface{} {
+	if book.Series == "" {
+		return nil
+	}
+	return book.SeriesIndex
+}
+
 // insertTag inserts a tag into the database.
-func insertTag(tx *sql.Tx, tag string, bf *BookFile) error {
+func insertTag(ctx context.Context, tx *sql.Tx, tag string, bf *BookFile) error {
 	var tagID int64
-	row := tx.QueryRow("select id from tags where name=?", tag)
+	row := tx.QueryRowContext(ctx, "select id from tags where name=?", tag)
 	err := row.Scan(&tagID)
 	if err == sql.ErrNoRows {
 		// Insert the tag
-		res, err := tx.Exec("insert into tags (name) values(?)", tag)
+		res, err := tx.ExecContext(ctx, "insert into tags (name) values(?)", tag)
 		if err != nil {
 			return err
 		}
@@ -356,71 +1083,79 @@ func insertTag(tx *sql.Tx, tag string, bf *BookFile) error {
 	}
 	// Tag inserted, insert the link
 	// Avoid duplicate tags.
-	if _, err := tx.Exec("insert or ignore into files_tags (file_id, tag_id) values(?, ?)", bf.ID, tagID); err != nil {
+	if _, err := tx.ExecContext(ctx, "insert or ignore into files_tags (file_id, tag_id) values(?, ?)", bf.ID, tagID); err != nil {
 		return errors.Wrap(err, "inserting tag link")
 	}
 	return nil
 }
 
+// searchRank orders search results by relevance, weighting matches in the title over the author, series and tags.
+// The weights are for the columns of books_fts, in order: author, series, title, extension, tags, filename, source, genres.
+const searchRank = "bm25(books_fts, 5.0, 3.0, 10.0, 1.0, 2.0, 1.0, 1.0, 2.0)"
+
 // Search searches the library for books.
 // By default, all fields are searched, but
 // field:terms+to+search will limit to that field only.
-// Fields: author, title, series, extension, tags, filename, source.
+// Fields: author, title, series, extension, tags, filename, source, genres.
 // Example: author:Stephen+King title:Shining
+// See CompileQuery for the full syntax. If terms can't be parsed, the error is a *QueryError.
+// The most relevant books are returned first, with Highlight set to show where they matched.
 func (lib *Library) Search(terms string) ([]Book, error) {
-	books, _, err := lib.SearchPaged(terms, 0, 0, 0)
+	return lib.SearchContext(context.Background(), terms)
+}
+
+// SearchContext is like Search, but stops searching if ctx is canceled.
+func (lib *Library) SearchContext(ctx context.Context, terms string) ([]Book, error) {
+	books, _, err := lib.SearchPagedContext(ctx, terms, SearchOptions{}, 0, 0)
 	return books, err
 }
 
 // SearchPaged implements book searching, both paged and non paged.
+// Results are filtered and sorted according to opts.
+// If terms is empty, every book matching opts is returned.
 // Set limit to 0 to return all results.
-// moreResults will be set to the number of additional results not returned, with a maximum of moreResultsLimit.
-func (lib *Library) SearchPaged(terms string, offset, limit, moreResultsLimit int) (books []Book, moreResults int, err error) {
-	books = []Book{}
-	var query string
-	args := []interface{}{terms}
-	if limit == 0 {
-		query = "select docid from books_fts where books_fts match ?"
-	} else {
-		query = "select docid from books_fts where books_fts match ? LIMIT ? OFFSET ?"
-		args = append(args, limit+moreResultsLimit, offset)
-	}
-
-	rows, err := lib.Query(query, args...)
-	if err != nil {
-		return nil, 0, errors.Wrap(err, "Querying db for search terms")
-	}
+// total is the number of books matching the search, including those on other pages.
+func (lib *Library) SearchPaged(terms string, opts SearchOptions, offset, limit int) (books []Book, total int, err error) {
+	return lib.SearchPagedContext(context.Background(), terms, opts, offset, limit)
+}
 
-	var ids []int64
-	var id int64
-	for rows.Next() {
-		rows.Scan(&id)
-		ids = append(ids, id)
-	}
-	err = rows.Err()
-	if err != nil {
-		return nil, 0, errors.Wrap(err, "Retrieving search results from db")
-	}
+// SearchPagedContext is like SearchPaged, but stops searching if ctx is canceled.
+func (lib *Library) SearchPagedContext(ctx context.Context, terms string, opts SearchOptions, offset, limit int) (books []Book, total int, err error) {
+	books, total, _, err = lib.search(ctx, terms, opts, "", offset, limit)
+	return books, total, err
+}
 
-	if limit > 0 && len(ids) > limit {
-		moreResults = len(ids) - limit
-		ids = ids[:limit]
-	}
-	books, err = lib.GetBooksByID(ids)
+// SearchAfter is like SearchPaged, but returns the page of results after cursor instead of at an offset.
+// An empty cursor starts at the first result. If there are more results, next is the cursor for the following page.
+// Pages don't shift as books are added or removed, as they can with offsets,
+// except when sorting by relevance: a book's relevance depends on every other book in the library,
+// so relevance pages are only stable while the library is unchanged, and can otherwise skip or repeat books.
+// Sort by title, or any other order, to page through results while the library may be changing.
+// If cursor wasn't returned by a search with the same sort order, the error is ErrInvalidCursor.
+func (lib *Library) SearchAfter(terms string, opts SearchOptions, cursor string, limit int) (books []Book, total int, next string, err error) {
+	return lib.SearchAfterContext(context.Background(), terms, opts, cursor, limit)
+}
 
-	return
+// SearchAfterContext is like SearchAfter, but stops searching if ctx is canceled.
+func (lib *Library) SearchAfterContext(ctx context.Context, terms string, opts SearchOptions, cursor string, limit int) (books []Book, total int, next string, err error) {
+	return lib.search(ctx, terms, opts, cursor, 0, limit)
 }
 
 // GetBooksByID retrieves books from the library by their id.
 func (lib *Library) GetBooksByID(ids []int64) ([]Book, error) {
+	return lib.GetBooksByIDContext(context.Background(), ids)
+}
+
+// GetBooksByIDContext is like GetBooksByID, but stops if ctx is canceled.
+func (lib *Library) GetBooksByIDContext(ctx context.Context, ids []int64) ([]Book, error) {
 	if len(ids) == 0 {
 		return nil, nil
 	}
-	tx, err := lib.Begin()
+	tx, err := lib.readDB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "get books by ID")
 	}
-	books, err := getBooksByID(tx, ids)
+	books, err := getBooksByID(ctx, tx, ids)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
@@ -433,53 +1168,74 @@ func (lib *Library) GetBooksByID(ids []int64) ([]Book, error) {
 }
 
 // getBooksByID retrieves books from the library by their id.
-func getBooksByID(tx *sql.Tx, ids []int64) ([]Book, error) {
+func getBooksByID(ctx context.Context, tx *sql.Tx, ids []int64) ([]Book, error) {
 	if len(ids) == 0 {
 		return nil, nil
 	}
 
-	results := []Book{}
-
-	query := "select id, series, title from books where id in (" + joinInt64s(ids, ",") + ")"
-	rows, err := tx.Query(query)
+	bookMap := make(map[int64]*Book, len(ids))
+	query := "select id, series, series_index, title, created_on, updated_on from books where id in (" + joinInt64s(ids, ",") + ")"
+	rows, err := tx.QueryContext(ctx, query)
 	if err != nil {
-		return results, errors.Wrap(err, "fetching books from database by ID")
+		return nil, errors.Wrap(err, "fetching books from database by ID")
 	}
 
 	for rows.Next() {
-		book := Book{}
-		if err := rows.Scan(&book.ID, &book.Series, &book.Title); err != nil {
+		book := &Book{}
+		var seriesIndex sql.NullFloat64
+		if err := rows.Scan(&book.ID, &book.Series, &seriesIndex, &book.Title, &book.Added, &book.Updated); err != nil {
+			rows.Close()
 			return nil, errors.Wrap(err, "scanning rows")
 		}
-
-		results = append(results, book)
+		book.SeriesIndex = seriesIndex.Float64
+		bookMap[book.ID] = book
 	}
 
-	if rows.Err() != nil {
+	if err := rows.Err(); err != nil {
 		return nil, errors.Wrap(err, "querying books by ID")
 	}
 	rows.Close()
 
-	authorMap, err := getAuthorsByBookIds(tx, ids)
+	authorMap, err := getAuthorsByBookIds(ctx, tx, ids)
 	if err != nil {
 		return nil, errors.Wrap(err, "get authors for books")
 	}
 
-	fileMap, err := getFilesByBookIds(tx, ids)
+	fileMap, err := getFilesByBookIds(ctx, tx, ids)
 	if err != nil {
 		return nil, errors.Wrap(err, "get files for books")
 	}
 
-	// Get authors and files
-	for i, book := range results {
-		results[i].Authors = authorMap[book.ID]
-		results[i].Files = fileMap[book.ID]
+	identifierMap, err := getIdentifiersByBookIds(ctx, tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get identifiers for books")
+	}
+
+	genreMap, err := getGenresByBookIds(ctx, tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get genres for books")
+	}
+
+	// Return the books in the order they were requested, with their authors, files, identifiers and genres.
+	results := make([]Book, 0, len(bookMap))
+	for _, id := range ids {
+		book, ok := bookMap[id]
+		if !ok {
+			continue
+		}
+		// Only return each book once, even if its ID was requested more than once.
+		delete(bookMap, id)
+		book.Authors = authorMap[id]
+		book.Files = fileMap[id]
+		book.Identifiers = identifierMap[id]
+		book.Genres = genreMap[id]
+		results = append(results, *book)
 	}
 	return results, nil
 }
 
 // getAuthorsByBookIds gets author names for each book ID.
-func getAuthorsByBookIds(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
+func getAuthorsByBookIds(ctx context.Context, tx *sql.Tx, ids []int64) (map[int64][]string, error) {
 	m := make(map[int64][]string)
 	if len(ids) == 0 {
 		return m, nil
@@ -489,7 +1245,7 @@ func getAuthorsByBookIds(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
 	var authorName string
 
 	query := "SELECT ba.book_id, a.name FROM books_authors ba JOIN authors a ON ba.author_id = a.id WHERE ba.book_id IN (" + joinInt64s(ids, ",") + ") ORDER BY ba.id"
-	rows, err := tx.Query(query)
+	rows, err := tx.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -507,8 +1263,37 @@ func getAuthorsByBookIds(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
 	return m, nil
 }
 
+// getIdentifiersByBookIds gets identifiers, keyed by type, for each book ID.
+func getIdentifiersByBookIds(ctx context.Context, tx *sql.Tx, ids []int64) (map[int64]map[string]string, error) {
+	m := make(map[int64]map[string]string)
+	if len(ids) == 0 {
+		return m, nil
+	}
+
+	query := "SELECT book_id, type, value FROM identifiers WHERE book_id IN (" + joinInt64s(ids, ",") + ")"
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		var typ, value string
+		if err := rows.Scan(&bookID, &typ, &value); err != nil {
+			return nil, err
+		}
+		if m[bookID] == nil {
+			m[bookID] = make(map[string]string)
+		}
+		m[bookID][typ] = value
+	}
+
+	return m, rows.Err()
+}
+
 // getTagsByFileIds gets tag names for each book ID.
-func getTagsByFileIds(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
+func getTagsByFileIds(ctx context.Context, tx *sql.Tx, ids []int64) (map[int64][]string, error) {
 	tagsMap := make(map[int64][]string)
 	if len(ids) == 0 {
 		return nil, nil
@@ -518,7 +1303,7 @@ func getTagsByFileIds(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
 	var tag string
 
 	query := "SELECT ft.file_id, t.name FROM files_tags ft JOIN tags t ON ft.tag_id = t.id WHERE ft.file_id IN (" + joinInt64s(ids, ",") + ") ORDER BY ft.id"
-	rows, err := tx.Query(query)
+	rows, err := tx.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -535,53 +1320,82 @@ func getTagsByFileIds(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
 	return tagsMap, nil
 }
 
-// getFilesByBookIds gets files for each book ID.
-func getFilesByBookIds(tx *sql.Tx, ids []int64) (fileMap map[int64][]BookFile, err error) {
+// getTagsByBookIds gets tag names for each file of each book ID, keyed by file ID.
+func getTagsByBookIds(ctx context.Context, tx *sql.Tx, ids []int64) (map[int64][]string, error) {
+	tagsMap := make(map[int64][]string)
 	if len(ids) == 0 {
-		return nil, nil
+		return tagsMap, nil
 	}
-	fileIDMap := make(map[int64][]int64)
-	fileMap = make(map[int64][]BookFile)
 
-	query := "select id, book_id from files where book_id in (" + joinInt64s(ids, ",") + ")"
-	rows, err := tx.Query(query)
+	query := "SELECT ft.file_id, t.name FROM files f JOIN files_tags ft ON ft.file_id = f.id JOIN tags t ON ft.tag_id = t.id WHERE f.book_id IN (" + joinInt64s(ids, ",") + ") ORDER BY ft.id"
+	rows, err := tx.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var bookID, fileID int64
 	for rows.Next() {
-		err := rows.Scan(&fileID, &bookID)
-		if err != nil {
+		var fileID int64
+		var tag string
+		if err := rows.Scan(&fileID, &tag); err != nil {
 			return nil, err
 		}
-		fileIDMap[bookID] = append(fileIDMap[bookID], fileID)
+		tagsMap[fileID] = append(tagsMap[fileID], tag)
+	}
+
+	return tagsMap, rows.Err()
+}
+
+// getFilesByBookIds gets files for each book ID.
+func getFilesByBookIds(ctx context.Context, tx *sql.Tx, ids []int64) (fileMap map[int64][]BookFile, err error) {
+	if len(ids) == 0 {
+		return nil, nil
 	}
+	fileMap = make(map[int64][]BookFile)
 
-	for bookID, fileIDs := range fileIDMap {
-		files, err := getFilesByID(tx, fileIDs)
+	tagMap, err := getTagsByBookIds(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "select id, book_id, extension, original_filename, filename, file_size, file_mtime, hash, source from files where book_id in (" + joinInt64s(ids, ",") + ") order by id"
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookID int64
+	for rows.Next() {
+		bf := BookFile{}
+		err := rows.Scan(&bf.ID, &bookID, &bf.Extension, &bf.OriginalFilename, &bf.CurrentFilename, &bf.FileSize, &bf.FileMtime, &bf.Hash, &bf.Source)
 		if err != nil {
 			return nil, err
 		}
-		fileMap[bookID] = files
+		bf.Tags = tagMap[bf.ID]
+		fileMap[bookID] = append(fileMap[bookID], bf)
 	}
 
-	return fileMap, nil
+	return fileMap, rows.Err()
 }
 
 // GetFilesByID gets files for each ID.
 func (lib *Library) GetFilesByID(ids []int64) ([]BookFile, error) {
+	return lib.GetFilesByIDContext(context.Background(), ids)
+}
+
+// GetFilesByIDContext is like GetFilesByID, but stops if ctx is canceled.
+func (lib *Library) GetFilesByIDContext(ctx context.Context, ids []int64) ([]BookFile, error) {
 	if len(ids) == 0 {
 		return nil, nil
 	}
 
-	tx, err := lib.Begin()
+	tx, err := lib.readDB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	files, err := getFilesByID(tx, ids)
+	files, err := getFilesByID(ctx, tx, ids)
 	if err != nil {
 		tx.Rollback()
 	} else {
@@ -592,17 +1406,17 @@ func (lib *Library) GetFilesByID(ids []int64) ([]BookFile, error) {
 }
 
 // GetFilesById gets files for each ID.
-func getFilesByID(tx *sql.Tx, ids []int64) ([]BookFile, error) {
+func getFilesByID(ctx context.Context, tx *sql.Tx, ids []int64) ([]BookFile, error) {
 	if len(ids) == 0 {
 		return nil, nil
 	}
 	files := []BookFile{}
-	tagMap, err := getTagsByFileIds(tx, ids)
+	tagMap, err := getTagsByFileIds(ctx, tx, ids)
 	if err != nil {
 		return nil, err
 	}
 	query := "select id, extension, original_filename, filename, file_size, file_mtime, hash, source from files where id in (" + joinInt64s(ids, ",") + ")"
-	rows, err := tx.Query(query)
+	rows, err := tx.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -623,10 +1437,15 @@ func getFilesByID(tx *sql.Tx, ids []int64) ([]BookFile, error) {
 // This depends on ebook-convert, which takes the original filename, and the new filename, in that order.
 // the file's hash, with the extension .epub, will be the name of the cached file.
 func (lib *Library) ConvertToEpub(file BookFile) error {
+	return lib.ConvertToEpubContext(context.Background(), file)
+}
+
+// ConvertToEpubContext is like ConvertToEpub, but kills ebook-convert if ctx is canceled before it finishes.
+func (lib *Library) ConvertToEpubContext(ctx context.Context, file BookFile) error {
 	filename := path.Join(lib.booksRoot, file.CurrentFilename)
 	cacheDir := path.Join(path.Dir(lib.filename), "cache")
 	newFile := path.Join(cacheDir, file.Hash+".epub")
-	cmd := exec.Command("ebook-convert", filename, newFile)
+	cmd := exec.CommandContext(ctx, "ebook-convert", filename, newFile)
 	if err := cmd.Run(); err != nil {
 		return err
 	}
@@ -637,11 +1456,16 @@ func (lib *Library) ConvertToEpub(file BookFile) error {
 // UpdateBook updates the authors and title of an existing book in the database, specified by book.ID.
 // If the existing book's series is not empty, it will not be updated unless overwriteSeries is true.
 func (lib *Library) UpdateBook(book Book, tmpl *template.Template, overwriteSeries bool) error {
-	tx, err := lib.Begin()
+	return lib.UpdateBookContext(context.Background(), book, tmpl, overwriteSeries)
+}
+
+// UpdateBookContext is like UpdateBook, but stops if ctx is canceled before the book is updated.
+func (lib *Library) UpdateBookContext(ctx context.Context, book Book, tmpl *template.Template, overwriteSeries bool) error {
+	tx, err := lib.begin(ctx)
 	if err != nil {
 		return errors.Wrap(err, "get transaction")
 	}
-	err = lib.updateBook(tx, book, tmpl, overwriteSeries)
+	err = lib.updateBook(ctx, tx, book, tmpl, overwriteSeries)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -653,8 +1477,8 @@ func (lib *Library) UpdateBook(book Book, tmpl *template.Template, overwriteSeri
 	return nil
 }
 
-func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, overwriteSeries bool) (err error) {
-	existingBooks, err := getBooksByID(tx, []int64{book.ID})
+func (lib *Library) updateBook(ctx context.Context, tx *sql.Tx, book Book, tmpl *template.Template, overwriteSeries bool) (err error) {
+	existingBooks, err := getBooksByID(ctx, tx, []int64{book.ID})
 	if err != nil {
 		return errors.Wrap(err, "get books by ID")
 	}
@@ -666,9 +1490,19 @@ func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, o
 	// We should update the series in the database only if it is empty, unless overwriteSeries is true.
 	if existingBook.Series != "" && !overwriteSeries {
 		book.Series = existingBook.Series
+		book.SeriesIndex = existingBook.SeriesIndex
+	} else if book.Series == existingBook.Series && book.SeriesIndex == 0 {
+		// Callers which don't know about series indexes shouldn't clear them.
+		book.SeriesIndex = existingBook.SeriesIndex
+	}
+
+	if book.Genres == nil {
+		// Callers which don't know about genres shouldn't clear them.
+		book.Genres = existingBook.Genres
 	}
+	book.Genres = NormalizeGenres(book.Genres)
 
-	existingBookID, found, err := getBookIDByTitleAndAuthors(tx, book.Title, book.Authors)
+	existingBookID, found, err := getBookIDByTitleAndAuthors(ctx, tx, book.Title, book.Authors)
 	if err != nil {
 		return errors.Wrap(err, "find existing book")
 	}
@@ -677,23 +1511,29 @@ func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, o
 	}
 
 	if book.Title != existingBook.Title ||
-		book.Series != existingBook.Series {
-		_, err = tx.Exec("update books set updated_on=datetime(), title=?, series=? where id=?", book.Title, book.Series, book.ID)
+		book.Series != existingBook.Series ||
+		book.SeriesIndex != existingBook.SeriesIndex {
+		_, err = tx.ExecContext(ctx, "update books set updated_on=datetime(), title=?, title_key=?, series=?, series_index=? where id=?", book.Title, FoldKey(book.Title), book.Series, seriesIndexValue(book), book.ID)
 		if err != nil {
 			return errors.Wrap(err, "update book")
 		}
 	}
-	if !stringSlicesEqual(existingBook.Authors, book.Authors, false) {
-		_, err := tx.Exec("delete from books_authors where book_id=?", book.ID)
+	if !stringSlicesEqual(existingBook.Authors, book.Authors) {
+		_, err := tx.ExecContext(ctx, "delete from books_authors where book_id=?", book.ID)
 		if err != nil {
 			return errors.Wrap(err, "delete authors")
 		}
 		for _, author := range book.Authors {
-			if err := insertAuthor(tx, author, &book); err != nil {
+			if err := insertAuthor(ctx, tx, author, &book); err != nil {
 				return errors.Wrap(err, "insert author")
 			}
 		}
 	}
+	if !stringSlicesEqual(existingBook.Genres, book.Genres) {
+		if err := setBookGenres(ctx, tx, book.ID, book.Genres); err != nil {
+			return errors.Wrap(err, "set genres")
+		}
+	}
 	var tags []string
 	for i, f := range book.Files {
 		if f.ID != existingBook.Files[i].ID {
@@ -703,21 +1543,21 @@ func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, o
 		for _, t := range f.Tags {
 			tags = append(tags, t)
 		}
-		if stringSlicesEqual(existingBook.Files[i].Tags, f.Tags, false) {
+		if stringSlicesEqual(existingBook.Files[i].Tags, f.Tags) {
 			continue
 		}
-		_, err = tx.Exec("delete from files_tags where file_id=?", f.ID)
+		_, err = tx.ExecContext(ctx, "delete from files_tags where file_id=?", f.ID)
 		if err != nil {
 			return errors.Wrap(err, "delete existing file tags")
 		}
 		for _, t := range f.Tags {
-			err := insertTag(tx, t, &f)
+			err := insertTag(ctx, tx, t, &f)
 			if err != nil {
 				return errors.Wrap(err, "insert tag")
 			}
 		}
 	}
-	_, err = tx.Exec("update books_fts set title=?, author=?, series=?, tags=? where docid=?", book.Title, strings.Join(book.Authors, " & "), book.Series, strings.Join(tags, " "), book.ID)
+	_, err = tx.ExecContext(ctx, "update books_fts set title=?, author=?, series=?, tags=?, genres=? where rowid=?", book.Title, strings.Join(book.Authors, " & "), book.Series, strings.Join(tags, " "), strings.Join(book.Genres, " "), book.ID)
 	if err != nil {
 		return errors.Wrap(err, "update fts")
 	}
@@ -729,7 +1569,7 @@ func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, o
 		if bf.CurrentFilename == newFn {
 			continue
 		}
-		if _, err := tx.Exec("update files set updated_on=datetime(), filename=? where id=?", newFn, bf.ID); err != nil {
+		if _, err := tx.ExecContext(ctx, "update files set updated_on=datetime(), filename=? where id=?", newFn, bf.ID); err != nil {
 			return errors.Wrap(err, "update file")
 		}
 	}
@@ -738,17 +1578,18 @@ func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, o
 }
 
 // GetBookIDByTitleAndAuthors gets an existing book ID with the given title and authors.
+// Titles and authors are compared by FoldKey, so they match regardless of case and diacritics.
 func (lib *Library) GetBookIDByTitleAndAuthors(title string, authors []string) (int64, bool, error) {
-	tx, err := lib.Begin()
+	tx, err := lib.readDB.Begin()
 	if err != nil {
 		return 0, false, errors.Wrap(err, "get transaction")
 	}
 	defer tx.Rollback()
-	return getBookIDByTitleAndAuthors(tx, title, authors)
+	return getBookIDByTitleAndAuthors(context.Background(), tx, title, authors)
 }
 
-func getBookIDByTitleAndAuthors(tx *sql.Tx, title string, authors []string) (int64, bool, error) {
-	rows, err := tx.Query("SELECT id FROM books WHERE title = ? COLLATE NOCASE", title)
+func getBookIDByTitleAndAuthors(ctx context.Context, tx *sql.Tx, title string, authors []string) (int64, bool, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM books WHERE title_key = ?", FoldKey(title))
 	if err != nil {
 		return 0, false, errors.Wrap(err, "get book by title")
 	}
@@ -765,13 +1606,13 @@ func getBookIDByTitleAndAuthors(tx *sql.Tx, title string, authors []string) (int
 	}
 	rows.Close()
 
-	authorMap, err := getAuthorsByBookIds(tx, ids)
+	authorMap, err := getAuthorsByBookIds(ctx, tx, ids)
 	if err != nil {
 		return 0, false, errors.Wrap(err, "get authors for books")
 	}
 
 	for bookID, authorNames := range authorMap {
-		if stringSlicesEqual(authors, authorNames, true) {
+		if foldedSlicesEqual(authors, authorNames) {
 			return bookID, true, nil
 		}
 	}
@@ -782,7 +1623,7 @@ func getBookIDByTitleAndAuthors(tx *sql.Tx, title string, authors []string) (int
 // GetBooksByHash retrieves books from the library by the hash of one of their files.
 func (lib *Library) GetBooksByHash(hash string) ([]Book, error) {
 	bks := []Book{}
-	tx, err := lib.Begin()
+	tx, err := lib.readDB.Begin()
 	if err != nil {
 		return bks, errors.Wrap(err, "begin transaction")
 	}
@@ -802,7 +1643,7 @@ func (lib *Library) GetBooksByHash(hash string) ([]Book, error) {
 		return bks, errors.Wrap(err, "get rows")
 	}
 	rows.Close()
-	bks, err = getBooksByID(tx, ids)
+	bks, err = getBooksByID(context.Background(), tx, ids)
 	if err != nil {
 		tx.Commit()
 		return bks, errors.Wrap(err, "get books by ID")
@@ -813,11 +1654,16 @@ func (lib *Library) GetBooksByHash(hash string) ([]Book, error) {
 
 // MergeBooks merges all of the files from ids into the first one.
 func (lib *Library) MergeBooks(ids []int64, tmpl *template.Template) error {
-	tx, err := lib.Begin()
+	return lib.MergeBooksContext(context.Background(), ids, tmpl)
+}
+
+// MergeBooksContext is like MergeBooks, but stops if ctx is canceled before the books are merged.
+func (lib *Library) MergeBooksContext(ctx context.Context, ids []int64, tmpl *template.Template) error {
+	tx, err := lib.begin(ctx)
 	if err != nil {
 		return errors.Wrap(err, "create transaction")
 	}
-	if err := lib.mergeBooks(tx, ids, tmpl); err != nil {
+	if err := lib.mergeBooks(ctx, tx, ids, tmpl); err != nil {
 		tx.Rollback()
 		return errors.Wrap(err, "merge books")
 	}
@@ -827,27 +1673,36 @@ func (lib *Library) MergeBooks(ids []int64, tmpl *template.Template) error {
 	return nil
 }
 
-func (lib *Library) mergeBooks(tx *sql.Tx, ids []int64, tmpl *template.Template) error {
-	_, err := tx.Exec("delete from files where book_id in ("+joinInt64s(ids[1:], ",")+") and hash in (select hash from files where book_id=?)", ids[0])
+func (lib *Library) mergeBooks(ctx context.Context, tx *sql.Tx, ids []int64, tmpl *template.Template) error {
+	_, err := tx.ExecContext(ctx, "delete from files where book_id in ("+joinInt64s(ids[1:], ",")+") and hash in (select hash from files where book_id=?)", ids[0])
 	if err != nil {
 		return errors.Wrap(err, "delete duplicate files")
 	}
-	_, err = tx.Exec("update files set updated_on=datetime(), book_id=? where book_id in ("+joinInt64s(ids[1:], ",")+")", ids[0])
+	_, err = tx.ExecContext(ctx, "update files set updated_on=datetime(), book_id=? where book_id in ("+joinInt64s(ids[1:], ",")+")", ids[0])
 	if err != nil {
 		return errors.Wrap(err, "merge books")
 	}
-	if _, err = tx.Exec("delete from books where id in (" + joinInt64s(ids[1:], ",") + ")"); err != nil {
+	_, err = tx.ExecContext(ctx, "insert or ignore into books_genres (book_id, genre_id) select ?, genre_id from books_genres where book_id in ("+joinInt64s(ids[1:], ",")+") order by id", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge genres")
+	}
+	// The merged book takes the place of the others on shelves, unless it's already there.
+	_, err = tx.ExecContext(ctx, "update or ignore shelves_books set updated_on=datetime(), book_id=? where book_id in ("+joinInt64s(ids[1:], ",")+")", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge shelves")
+	}
+	if _, err = tx.ExecContext(ctx, "delete from books where id in ("+joinInt64s(ids[1:], ",")+")"); err != nil {
 		return errors.Wrap(err, "delete book")
 	}
-	if _, err = tx.Exec("delete from books_fts where docid in (" + joinInt64s(ids[1:], ",") + ")"); err != nil {
+	if _, err = tx.ExecContext(ctx, "delete from books_fts where rowid in ("+joinInt64s(ids[1:], ",")+")"); err != nil {
 		return errors.Wrap(err, "delete from books_fts")
 	}
 	// Reindex the book in search
-	_, err = tx.Exec("delete from books_fts where docid=?", ids[0])
+	_, err = tx.ExecContext(ctx, "delete from books_fts where rowid=?", ids[0])
 	if err != nil {
 		return errors.Wrap(err, "delete original book from fts")
 	}
-	books, err := getBooksByID(tx, []int64{ids[0]})
+	books, err := getBooksByID(ctx, tx, []int64{ids[0]})
 	if err != nil {
 		return errors.Wrap(err, "get original book")
 	}
@@ -862,20 +1717,57 @@ func (lib *Library) mergeBooks(tx *sql.Tx, ids []int64, tmpl *template.Template)
 		if newFn == f.CurrentFilename {
 			continue
 		}
-		_, err = tx.Exec("update files set updated_on=datetime(), filename=? where id=?", newFn, f.ID)
+		_, err = tx.ExecContext(ctx, "update files set updated_on=datetime(), filename=? where id=?", newFn, f.ID)
 		if err != nil {
 			return errors.Wrap(err, "update filename")
 		}
 	}
-	if err := indexBookInSearch(tx, &books[0], true); err != nil {
+	if err := indexBookInSearch(ctx, tx, &books[0], true); err != nil {
 		return errors.Wrap(err, "index book in search")
 	}
 	return nil
 }
 
+// GetCachedHash returns the hash recorded for the file at path by CacheHash.
+// found will be false if no hash was recorded, or if the file's size or modification time has changed since.
+func (lib *Library) GetCachedHash(path string, size int64, mtime time.Time) (hash string, found bool, err error) {
+	var cachedSize int64
+	var cachedMtime time.Time
+	err = lib.QueryRow("select file_size, file_mtime, hash from hash_cache where path=?", path).Scan(&cachedSize, &cachedMtime, &hash)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, errors.Wrap(err, "get cached hash")
+	}
+	if cachedSize != size || !cachedMtime.Equal(mtime) {
+		return "", false, nil
+	}
+	return hash, true, nil
+}
+
+// CacheHash records the hash of the file at path, along with its size and modification time.
+func (lib *Library) CacheHash(path string, size int64, mtime time.Time, hash string) error {
+	_, err := lib.Exec(`insert into hash_cache (path, file_size, file_mtime, hash) values (?, ?, ?, ?)
+	on conflict (path) do update set updated_on=datetime(), file_size=excluded.file_size, file_mtime=excluded.file_mtime, hash=excluded.hash`,
+		path, size, mtime, hash)
+	if err != nil {
+		return errors.Wrap(err, "cache hash")
+	}
+	return nil
+}
+
+// HashExists returns true if any file in the library has the given hash.
+func (lib *Library) HashExists(hash string) (bool, error) {
+	var exists bool
+	if err := lib.QueryRow("select exists (select 1 from files where hash=?)", hash).Scan(&exists); err != nil {
+		return false, errors.Wrap(err, "check for hash")
+	}
+	return exists, nil
+}
+
 // GetBookIDByFilename returns a book ID given a filename relative to books root.
 func (lib *Library) GetBookIDByFilename(fn string) (int64, error) {
-	tx, err := lib.Begin()
+	tx, err := lib.readDB.Begin()
 	if err != nil {
 		return 0, err
 	}
@@ -917,22 +1809,28 @@ func (lib *Library) insertFile(file BookFile, deleteOriginal bool) error {
 	return nil
 }
 
-func stringSlicesEqual(a, b []string, ignoreCase bool) bool {
+func stringSlicesEqual(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
 	}
 	for i := range a {
-		author1, author2 := a[i], b[i]
-		if ignoreCase {
-			author1, author2 = strings.ToLower(author1), strings.ToLower(author2)
-		}
-		if author1 != author2 {
+		if a[i] != b[i] {
 			return false
 		}
 	}
 	return true
 }
 
+// stringSliceContains returns true if s is in items.
+func stringSliceContains(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 // joinInt64s is like strings.Join, but for slices of int64.
 // SQLite limits the number of variables that can be passed to a bound query.
 // Pass int64s directly to IN (…) as a work-around.
@@ -0,0 +1,221 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"text/template"
+)
+
+// newTestLibrary creates an empty library in a temporary directory, which is removed when the test finishes.
+func newTestLibrary(t *testing.T) *Library {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "books-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return openTestLibrary(t, filepath.Join(dir, "books.db"), dir)
+}
+
+// openTestLibrary creates a library in filename, and opens it with the default options.
+func openTestLibrary(t *testing.T, filename, booksRoot string) *Library {
+	t.Helper()
+	if err := CreateLibrary(filename); err != nil {
+		t.Fatal(err)
+	}
+	lib, err := OpenLibrary(filename, booksRoot, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lib.Close() })
+	return lib
+}
+
+// testTemplate names the files of books imported by tests.
+var testTemplate = template.Must(template.New("filename").Parse("{{.Book.ID}} {{.Hash}}.{{.Extension}}"))
+
+// importTestBook imports book with one file of at least size bytes, and returns its ID.
+func importTestBook(t *testing.T, lib *Library, book Book, size int) int64 {
+	t.Helper()
+	contents := []byte(fmt.Sprintf("%s by %s\n", book.Title, book.Authors))
+	if len(contents) < size {
+		contents = append(contents, make([]byte, size-len(contents))...)
+	}
+	result, err := importTestFile(t, lib, book, contents, true, DuplicateImport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.BookID
+}
+
+// importTestFile imports book with one file containing contents, from import.txt in the books root.
+// The file's tags are taken from book.Files[0], if there is one.
+func importTestFile(t *testing.T, lib *Library, book Book, contents []byte, move bool, policy DuplicatePolicy) (ImportResult, error) {
+	t.Helper()
+	fn := filepath.Join(lib.booksRoot, "import.txt")
+	if err := ioutil.WriteFile(fn, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	if len(book.Files) > 0 {
+		tags = book.Files[0].Tags
+	}
+	book.Files = []BookFile{{
+		Extension:        "txt",
+		Hash:             fmt.Sprintf("%x", sha256.Sum256(contents)),
+		OriginalFilename: fn,
+		FileSize:         int64(len(contents)),
+		Tags:             tags,
+	}}
+	return lib.ImportBook(book, testTemplate, move, policy)
+}
+
+func TestImportDuplicatePolicies(t *testing.T) {
+	dune := Book{Title: "Dune", Authors: []string{"Frank Herbert"}}
+	messiah := Book{Title: "Dune Messiah", Authors: []string{"Frank Herbert"}}
+	contents := []byte("The same contents\n")
+	tests := []struct {
+		name   string
+		book   Book
+		policy DuplicatePolicy
+		status ImportStatus
+		// newBook is true if the file should be imported into a new book, instead of into Dune.
+		newBook   bool
+		colliding bool
+		tags      []string
+	}{
+		{"import into another book", messiah, DuplicateImport, ImportImported, true, true, nil},
+		{"skip", messiah, DuplicateSkip, ImportSkipped, false, true, nil},
+		{"attach", messiah, DuplicateAttach, ImportAttached, false, true, []string{"dune", "attached"}},
+		{"same book, import", dune, DuplicateImport, ImportDuplicate, false, false, []string{"dune"}},
+		{"same book, skip", dune, DuplicateSkip, ImportDuplicate, false, false, []string{"dune"}},
+		{"same book, attach", dune, DuplicateAttach, ImportDuplicate, false, false, []string{"dune"}},
+	}
+	for _, test := range tests {
+		for _, move := range []bool{false, true} {
+			name := fmt.Sprintf("%s, move=%v", test.name, move)
+			lib := newTestLibrary(t)
+			dune.Files = []BookFile{{Tags: []string{"dune"}}}
+			first, err := importTestFile(t, lib, dune, contents, true, DuplicateImport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			book := test.book
+			book.Files = []BookFile{{Tags: []string{"attached"}}}
+			result, err := importTestFile(t, lib, book, contents, move, test.policy)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if result.Status != test.status {
+				t.Errorf("%s: status = %d, want %d", name, result.Status, test.status)
+			}
+			if test.newBook && result.BookID == first.BookID {
+				t.Errorf("%s: book ID = %d, want a new book", name, result.BookID)
+			} else if !test.newBook && result.BookID != first.BookID {
+				t.Errorf("%s: book ID = %d, want %d", name, result.BookID, first.BookID)
+			}
+			var colliding []int64
+			if test.colliding {
+				colliding = []int64{first.BookID}
+			}
+			if !reflect.DeepEqual(result.CollidingBookIDs, colliding) {
+				t.Errorf("%s: colliding book IDs = %v, want %v", name, result.CollidingBookIDs, colliding)
+			}
+
+			var count int
+			if err := lib.QueryRow("select count(*) from books").Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if wantCount := map[bool]int{false: 1, true: 2}[test.newBook]; count != wantCount {
+				t.Errorf("%s: %d books in library, want %d", name, count, wantCount)
+			}
+			if test.tags != nil {
+				bks, err := lib.GetBooksByID([]int64{first.BookID})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tags := bks[0].Files[0].Tags; !reflect.DeepEqual(tags, test.tags) {
+					t.Errorf("%s: tags = %v, want %v", name, tags, test.tags)
+				}
+			}
+
+			_, err = os.Stat(filepath.Join(lib.booksRoot, "import.txt"))
+			if move && !os.IsNotExist(err) {
+				t.Errorf("%s: original file wasn't deleted: %v", name, err)
+			} else if !move && err != nil {
+				t.Errorf("%s: original file was deleted: %v", name, err)
+			}
+			hashPath := filepath.Join(lib.booksRoot, (&BookFile{Hash: fmt.Sprintf("%x", sha256.Sum256(contents))}).HashPath())
+			if _, err := os.Stat(hashPath); err != nil {
+				t.Errorf("%s: library file is missing: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestOpenLibraryEscapesFilename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "books-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"books?mode=memory.db", "books#1.db", "100% books.db"} {
+		fn := filepath.Join(dir, name)
+		lib := openTestLibrary(t, fn, dir)
+		if _, err := lib.Exec("insert into books (title) values('A book')"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := os.Stat(fn); err != nil {
+			t.Errorf("%s wasn't created: %v", name, err)
+		}
+		var journalMode string
+		if err := lib.QueryRow("pragma journal_mode").Scan(&journalMode); err != nil {
+			t.Fatal(err)
+		}
+		if journalMode != DefaultOptions.JournalMode {
+			t.Errorf("%s: journal mode is %s, want %s", name, journalMode, DefaultOptions.JournalMode)
+		}
+	}
+}
+
+func TestOpenLibraryQueryOnly(t *testing.T) {
+	lib := newTestLibrary(t)
+	id := importTestBook(t, lib, Book{Title: "Dune", Authors: []string{"Frank Herbert"}}, 0)
+
+	qlib, err := OpenLibraryQueryOnly(lib.filename, lib.booksRoot, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qlib.Close()
+	// The book is still in the WAL, since lib is open.
+	gotID, found, err := qlib.GetBookIDByTitleAndAuthors("Dune", []string{"Frank Herbert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || gotID != id {
+		t.Errorf("GetBookIDByTitleAndAuthors = %d, %v, want %d, true", gotID, found, id)
+	}
+	if _, err := qlib.Exec("insert into books (title) values('A book')"); err == nil {
+		t.Error("Inserted a book into a query-only library")
+	}
+
+	missing := filepath.Join(lib.booksRoot, "missing.db")
+	if qlib, err := OpenLibraryQueryOnly(missing, lib.booksRoot, DefaultOptions); err == nil {
+		qlib.Close()
+		t.Error("Opened a library which doesn't exist")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("Opening a missing library created it: %v", err)
+	}
+}
@@ -14,6 +14,8 @@ const (
 	packageName = "github.com/tspivey/books/cmd/books"
 	ldflags     = "-X " + packageName + "/commands.Version=$VERSION"
 	outDir      = "bin"
+	// Search uses SQLite's FTS5 extension, which go-sqlite3 only includes with this tag.
+	buildTags = "sqlite_fts5"
 )
 
 var Default = Build
@@ -35,12 +37,12 @@ func init() {
 // Build builds Books.
 func Build() error {
 	mg.Deps(mkBin)
-	return sh.RunWith(getVars(), goexe, "build", "-ldflags", ldflags, "-o", path.Join(outDir, "$BIN_NAME"), packageName)
+	return sh.RunWith(getVars(), goexe, "build", "-tags", buildTags, "-ldflags", ldflags, "-o", path.Join(outDir, "$BIN_NAME"), packageName)
 }
 
 // Install installs Books.
 func Install() error {
-	return sh.RunWith(getVars(), goexe, "install", "-ldflags", ldflags, packageName)
+	return sh.RunWith(getVars(), goexe, "install", "-tags", buildTags, "-ldflags", ldflags, packageName)
 }
 
 // Clean removes all files and directories created by mage targets.
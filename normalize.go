@@ -0,0 +1,73 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// unaccentedLetters spells letters which don't decompose into a base letter and a diacritic the way they're usually written without one.
+var unaccentedLetters = strings.NewReplacer(
+	"ł", "l",
+	"ø", "o",
+	"đ", "d",
+	"ð", "d",
+	"ħ", "h",
+	"ı", "i",
+	"æ", "ae",
+	"œ", "oe",
+)
+
+// FoldKey normalizes s so that titles and author names can be compared regardless of case, diacritics and spacing.
+// s is decomposed with NFKD, diacritics are removed, and the result is case folded,
+// so "Brontë", "BRONTE" and "bronte" all have the same key.
+func FoldKey(s string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), cases.Fold())
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		// Only invalid UTF-8 can get here, so fall back to folding what we can.
+		folded = strings.ToLower(s)
+	}
+	folded = unaccentedLetters.Replace(folded)
+	return strings.Join(strings.Fields(folded), " ")
+}
+
+// foldedSlicesEqual returns true if a and b have the same items in the same order, as compared by FoldKey.
+func foldedSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if FoldKey(a[i]) != FoldKey(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// nameSuffixes are words which stay at the end of a name when it's turned into a sort name.
+var nameSuffixes = []string{"jr", "jr.", "sr", "sr.", "ii", "iii", "iv"}
+
+// AuthorSortName returns the name an author is sorted by, with their last name first: "Stephen King" becomes "King, Stephen".
+// Names which already have a comma, and single names, are returned unchanged, and suffixes such as Jr. are kept at the end.
+func AuthorSortName(name string) string {
+	words := strings.Fields(name)
+	if len(words) < 2 || strings.Contains(name, ",") {
+		return strings.Join(words, " ")
+	}
+	last := len(words) - 1
+	suffix := ""
+	if last >= 2 && stringSliceContains(nameSuffixes, strings.ToLower(words[last])) {
+		suffix = ", " + words[last]
+		last--
+	}
+	return words[last] + ", " + strings.Join(words[:last], " ") + suffix
+}
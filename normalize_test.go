@@ -0,0 +1,73 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import "testing"
+
+func TestFoldKey(t *testing.T) {
+	tests := []struct {
+		in, key string
+	}{
+		{"", ""},
+		{"Dune", "dune"},
+		{"BRONTË", "bronte"},
+		{"Brontë", "bronte"},
+		{"  The   Left Hand\tof Darkness ", "the left hand of darkness"},
+		{"Les Misérables", "les miserables"},
+		{"Stanisław Lem", "stanislaw lem"},
+		{"Jens Bjørneboe", "jens bjorneboe"},
+		{"Ægir", "aegir"},
+		{"Straße", "strasse"},
+		{"ﬁre", "fire"},
+		{"Ｗｉｄｅ", "wide"},
+		{"Σίσυφος", "σισυφοσ"},
+	}
+	for _, test := range tests {
+		if key := FoldKey(test.in); key != test.key {
+			t.Errorf("FoldKey(%q) = %q, want %q", test.in, key, test.key)
+		}
+	}
+}
+
+func TestFoldedSlicesEqual(t *testing.T) {
+	tests := []struct {
+		a, b  []string
+		equal bool
+	}{
+		{nil, nil, true},
+		{[]string{"Émile Zola"}, []string{"emile  ZOLA"}, true},
+		{[]string{"a", "b"}, []string{"A", "B"}, true},
+		{[]string{"a", "b"}, []string{"b", "a"}, false},
+		{[]string{"a"}, []string{"a", "b"}, false},
+	}
+	for _, test := range tests {
+		if equal := foldedSlicesEqual(test.a, test.b); equal != test.equal {
+			t.Errorf("foldedSlicesEqual(%q, %q) = %v, want %v", test.a, test.b, equal, test.equal)
+		}
+	}
+}
+
+func TestAuthorSortName(t *testing.T) {
+	tests := []struct {
+		name, sortName string
+	}{
+		{"", ""},
+		{"Plato", "Plato"},
+		{"Stephen King", "King, Stephen"},
+		{"  Ursula K.  Le Guin ", "Guin, Ursula K. Le"},
+		{"King, Stephen", "King, Stephen"},
+		{"Martin Luther King Jr.", "King, Martin Luther, Jr."},
+		{"Harry Connick jr", "Connick, Harry, jr"},
+		{"Henry Ford II", "Ford, Henry, II"},
+		// A suffix needs a first and last name before it.
+		{"Malcolm X", "X, Malcolm"},
+		{"John Jr.", "Jr., John"},
+	}
+	for _, test := range tests {
+		if sortName := AuthorSortName(test.name); sortName != test.sortName {
+			t.Errorf("AuthorSortName(%q) = %q, want %q", test.name, sortName, test.sortName)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SearchFields are the fields which can be searched with field:terms.
+var SearchFields = []string{"author", "title", "series", "extension", "tags", "filename", "source", "genres"}
+
+// QueryError is returned when a search query can't be parsed.
+type QueryError struct {
+	Msg string
+	Pos int // Position in the query where the error was found, counting from 1, or 0 if it applies to the whole query
+}
+
+func (e *QueryError) Error() string {
+	if e.Pos == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s at character %d", e.Msg, e.Pos)
+}
+
+// queryNode is a node in a parsed search query: a *termNode, *notNode, *andNode or *orNode.
+type queryNode interface{}
+
+// termNode matches a word, or a phrase of words in order.
+type termNode struct {
+	field  string
+	words  []string
+	prefix bool // The last word matches any word it's a prefix of
+}
+
+// notNode excludes books matching its child.
+type notNode struct {
+	child queryNode
+	pos   int
+}
+
+// andNode matches books matching all of its children.
+type andNode struct {
+	children []queryNode
+}
+
+// orNode matches books matching any of its children.
+type orNode struct {
+	children []queryNode
+}
+
+// CompileQuery parses a search query, and compiles it into an FTS MATCH expression.
+//
+// Words are separated by spaces, and books must match all of them.
+// "Quoted words" match a phrase, as do words joined by +.
+// A word ending in * matches any word starting with it.
+// OR matches books matching either side, and NOT or a leading - excludes books matching what follows it.
+// Parentheses group terms, and AND may be given explicitly.
+// field:term, field:"quoted phrase" or field:(terms) limits the search to one of SearchFields.
+// Only uppercase AND, OR and NOT are operators.
+// Case and diacritics are ignored, as the search index's unicode61 tokenizer removes them from both the index and the query.
+//
+// Example: author:"Stephen King" (title:shining OR title:it) -series:"dark tower"
+//
+// If the query can't be parsed, the error is a *QueryError.
+func CompileQuery(query string) (string, error) {
+	p := &queryParser{src: query}
+	n, err := p.parseOr()
+	if err != nil {
+		return "", err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		if p.src[p.pos] == ')' {
+			return "", p.errorf(p.pos, "unmatched closing parenthesis")
+		}
+		return "", p.errorf(p.pos, "unexpected %q", p.src[p.pos:])
+	}
+	if n == nil {
+		return "", &QueryError{Msg: "nothing to search for"}
+	}
+	match, err := compileQueryNode(n)
+	if err != nil {
+		return "", err
+	}
+	if match == "" {
+		return "", &QueryError{Msg: "nothing to search for"}
+	}
+	return match, nil
+}
+
+// queryParser is a recursive descent parser for search queries.
+type queryParser struct {
+	src string
+	pos int
+}
+
+func (p *queryParser) errorf(pos int, format string, args ...interface{}) *QueryError {
+	return &QueryError{Msg: fmt.Sprintf(format, args...), Pos: pos + 1}
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.src) && isQuerySpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// keyword consumes kw and returns true if it's the next word in the query.
+func (p *queryParser) keyword(kw string) bool {
+	p.skipSpace()
+	end := p.pos + len(kw)
+	if !strings.HasPrefix(p.src[p.pos:], kw) {
+		return false
+	}
+	if end < len(p.src) && !isQuerySpace(p.src[end]) && p.src[end] != '(' && p.src[end] != '"' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+// parseOr parses terms separated by OR.
+func (p *queryParser) parseOr() (queryNode, error) {
+	start := p.pos
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := &orNode{children: []queryNode{left}}
+	for {
+		opPos := p.pos
+		if !p.keyword("OR") {
+			break
+		}
+		if left == nil {
+			return nil, p.errorf(start, "OR must come between two terms")
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if right == nil {
+			return nil, p.errorf(opPos, "OR must come between two terms")
+		}
+		or.children = append(or.children, right)
+	}
+	if len(or.children) == 1 {
+		return left, nil
+	}
+	return or, nil
+}
+
+// parseAnd parses terms separated by spaces or AND.
+// It returns nil if there are no terms before the end of the query, a closing parenthesis or OR.
+func (p *queryParser) parseAnd() (queryNode, error) {
+	and := &andNode{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] == ')' {
+			break
+		}
+		save := p.pos
+		if p.keyword("OR") {
+			p.pos = save
+			break
+		}
+		opPos := p.pos
+		explicitAnd := p.keyword("AND")
+		if explicitAnd && len(and.children) == 0 {
+			return nil, p.errorf(opPos, "AND must come between two terms")
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if n == nil {
+			if explicitAnd {
+				return nil, p.errorf(opPos, "AND must come between two terms")
+			}
+			break
+		}
+		and.children = append(and.children, n)
+	}
+	switch len(and.children) {
+	case 0:
+		return nil, nil
+	case 1:
+		return and.children[0], nil
+	}
+	return and, nil
+}
+
+// parseUnary parses a term, optionally preceded by NOT or -.
+func (p *queryParser) parseUnary() (queryNode, error) {
+	p.skipSpace()
+	opPos := p.pos
+	negated := p.keyword("NOT")
+	if !negated && p.pos+1 < len(p.src) && p.src[p.pos] == '-' && !isQuerySpace(p.src[p.pos+1]) {
+		negated = true
+		p.pos++
+	}
+	if !negated {
+		return p.parseTerm("")
+	}
+	n, err := p.parseTerm("")
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, p.errorf(opPos, "nothing to exclude after %s", strings.TrimSpace(p.src[opPos:p.pos]))
+	}
+	return &notNode{child: n, pos: opPos + 1}, nil
+}
+
+// parseTerm parses a word, a quoted phrase, or a group in parentheses, which may be preceded by field:.
+func (p *queryParser) parseTerm(field string) (queryNode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, nil
+	}
+	start := p.pos
+	switch p.src[p.pos] {
+	case ')':
+		return nil, nil
+	case '(':
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return nil, p.errorf(start, "missing closing parenthesis")
+		}
+		p.pos++
+		if n == nil {
+			return nil, p.errorf(start, "nothing to search for in parentheses")
+		}
+		if field != "" {
+			n = withField(n, field)
+		}
+		return n, nil
+	case '"':
+		p.pos++
+		end := strings.IndexByte(p.src[p.pos:], '"')
+		if end == -1 {
+			return nil, p.errorf(start, "missing closing quote")
+		}
+		t := &termNode{field: field, words: strings.Fields(p.src[p.pos : p.pos+end])}
+		p.pos += end + 1
+		if p.pos < len(p.src) && p.src[p.pos] == '*' {
+			t.prefix = true
+			p.pos++
+		}
+		return t, nil
+	}
+
+	end := p.pos
+	for end < len(p.src) && !isQuerySpace(p.src[end]) && !strings.ContainsRune(`()"`, rune(p.src[end])) {
+		end++
+	}
+	word := p.src[p.pos:end]
+	// A word ending in a colon, as in "Dune: Messiah", isn't a field, unless a phrase or group follows it.
+	followed := end < len(p.src) && (p.src[end] == '"' || p.src[end] == '(')
+	if i := strings.IndexByte(word, ':'); i > 0 && (i < len(word)-1 || followed) && field == "" {
+		name := strings.ToLower(word[:i])
+		if !isSearchField(name) {
+			return nil, p.errorf(start, "unknown field %s; fields are %s", word[:i], strings.Join(SearchFields, ", "))
+		}
+		p.pos += i + 1
+		return p.parseTerm(name)
+	}
+	p.pos = end
+	t := &termNode{field: field}
+	if strings.HasSuffix(word, "*") {
+		t.prefix = true
+		word = strings.TrimRight(word, "*")
+	}
+	// Words joined with + are a phrase, as in field:words+in+a+phrase.
+	t.words = strings.FieldsFunc(word, func(r rune) bool { return r == '+' })
+	return t, nil
+}
+
+// withField limits every term in n to field, unless the term already has a field.
+func withField(n queryNode, field string) queryNode {
+	switch n := n.(type) {
+	case *termNode:
+		if n.field == "" {
+			n.field = field
+		}
+	case *notNode:
+		withField(n.child, field)
+	case *andNode:
+		for _, c := range n.children {
+			withField(c, field)
+		}
+	case *orNode:
+		for _, c := range n.children {
+			withField(c, field)
+		}
+	}
+	return n
+}
+
+// compileQueryNode compiles n into an FTS MATCH expression.
+// Terms without any letters or digits are dropped, and an empty string is returned if nothing is left.
+func compileQueryNode(n queryNode) (string, error) {
+	switch n := n.(type) {
+	case *termNode:
+		// Split words into the tokens they'll be indexed as, so punctuation never reaches FTS.
+		var tokens []string
+		for _, w := range n.words {
+			tokens = append(tokens, strings.FieldsFunc(strings.ToLower(w), func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})...)
+		}
+		if len(tokens) == 0 {
+			return "", nil
+		}
+		phrase := `"` + strings.Join(tokens, " ") + `"`
+		if n.prefix {
+			phrase += " *"
+		}
+		if n.field != "" {
+			phrase = n.field + " : " + phrase
+		}
+		return phrase, nil
+	case *notNode:
+		return "", &QueryError{Msg: "nothing to search for besides what's excluded", Pos: n.pos}
+	case *andNode:
+		var include, exclude []string
+		for _, c := range n.children {
+			if not, ok := c.(*notNode); ok {
+				s, err := compileQueryNode(not.child)
+				if err != nil {
+					return "", err
+				}
+				if s != "" {
+					exclude = append(exclude, s)
+				}
+				continue
+			}
+			s, err := compileQueryNode(c)
+			if err != nil {
+				return "", err
+			}
+			if s != "" {
+				include = append(include, s)
+			}
+		}
+		if len(include) == 0 {
+			if len(exclude) > 0 {
+				return "", &QueryError{Msg: "nothing to search for besides what's excluded", Pos: firstNotPos(n)}
+			}
+			return "", nil
+		}
+		s := strings.Join(include, " AND ")
+		if len(include) > 1 {
+			s = "(" + s + ")"
+		}
+		for _, e := range exclude {
+			s += " NOT " + e
+		}
+		if len(exclude) > 0 {
+			s = "(" + s + ")"
+		}
+		return s, nil
+	case *orNode:
+		var parts []string
+		for _, c := range n.children {
+			s, err := compileQueryNode(c)
+			if err != nil {
+				return "", err
+			}
+			if s != "" {
+				parts = append(parts, s)
+			}
+		}
+		if len(parts) <= 1 {
+			return strings.Join(parts, ""), nil
+		}
+		return "(" + strings.Join(parts, " OR ") + ")", nil
+	}
+	return "", fmt.Errorf("unknown query node %T", n)
+}
+
+// firstNotPos returns the position of the first NOT in n's children.
+func firstNotPos(n *andNode) int {
+	for _, c := range n.children {
+		if not, ok := c.(*notNode); ok {
+			return not.pos
+		}
+	}
+	return 0
+}
+
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isSearchField(name string) bool {
+	for _, f := range SearchFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
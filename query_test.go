@@ -0,0 +1,107 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"strings"
+	"testing"
+)
+
+var compileQueryTests = []struct {
+	query, match string
+}{
+	{`dune`, `"dune"`},
+	{`Frank Herbert`, `("frank" AND "herbert")`},
+	{`"dune messiah"`, `"dune messiah"`},
+	{`dune+messiah`, `"dune messiah"`},
+	{`dun*`, `"dun" *`},
+	{`"dune mess"*`, `"dune mess" *`},
+	{`dune OR foundation`, `("dune" OR "foundation")`},
+	{`dune AND herbert`, `("dune" AND "herbert")`},
+	{`dune -herbert`, `("dune" NOT "herbert")`},
+	{`dune NOT herbert`, `("dune" NOT "herbert")`},
+	{`-a b -c`, `("b" NOT "a" NOT "c")`},
+	{`(a OR b) c`, `(("a" OR "b") AND "c")`},
+	{`author:"Stephen King"`, `author : "stephen king"`},
+	{`Author:king`, `author : "king"`},
+	{`title:(shining OR it)`, `(title : "shining" OR title : "it")`},
+	{`title:(a author:b)`, `(title : "a" AND author : "b")`},
+	// Lowercase operators are words.
+	{`or and not`, `("or" AND "and" AND "not")`},
+	// Punctuation splits words into the tokens they're indexed as, and never reaches FTS.
+	{`don't`, `"don t"`},
+	{`o'brien-smith`, `"o brien smith"`},
+	{`Dune: Messiah`, `("dune" AND "messiah")`},
+	{`éclair`, `"éclair"`},
+	{`a -`, `"a"`},
+	{`"a" "b"`, `("a" AND "b")`},
+}
+
+func TestCompileQuery(t *testing.T) {
+	for _, test := range compileQueryTests {
+		match, err := CompileQuery(test.query)
+		if err != nil {
+			t.Errorf("CompileQuery(%q) returned error %v", test.query, err)
+			continue
+		}
+		if match != test.match {
+			t.Errorf("CompileQuery(%q) = %s, want %s", test.query, match, test.match)
+		}
+	}
+}
+
+func TestCompileQueryErrors(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		msg   string
+		pos   int
+	}{
+		{``, "nothing to search for", 0},
+		{`   `, "nothing to search for", 0},
+		{`!!!`, "nothing to search for", 0},
+		{`"unclosed`, "missing closing quote", 1},
+		{`(a b`, "missing closing parenthesis", 1},
+		{`a)`, "unmatched closing parenthesis", 2},
+		{`()`, "nothing to search for in parentheses", 1},
+		{`OR a`, "OR must come between two terms", 1},
+		{`a OR`, "OR must come between two terms", 3},
+		{`a OR OR b`, "OR must come between two terms", 3},
+		{`AND a`, "AND must come between two terms", 1},
+		{`a AND`, "AND must come between two terms", 3},
+		{`-dune`, "nothing to search for besides what's excluded", 1},
+		{`NOT dune`, "nothing to search for besides what's excluded", 1},
+		{`NOT`, "nothing to exclude after NOT", 1},
+		{`bogus:x`, "unknown field bogus; fields are " + strings.Join(SearchFields, ", "), 1},
+	} {
+		_, err := CompileQuery(test.query)
+		qe, ok := err.(*QueryError)
+		if !ok {
+			t.Errorf("CompileQuery(%q) returned %v, want a *QueryError", test.query, err)
+			continue
+		}
+		if qe.Msg != test.msg || qe.Pos != test.pos {
+			t.Errorf("CompileQuery(%q) returned error %q at %d, want %q at %d", test.query, qe.Msg, qe.Pos, test.msg, test.pos)
+		}
+	}
+}
+
+func TestQueryErrorMessage(t *testing.T) {
+	if s := (&QueryError{Msg: "missing closing quote", Pos: 5}).Error(); s != "missing closing quote at character 5" {
+		t.Errorf("got %q", s)
+	}
+	if s := (&QueryError{Msg: "nothing to search for"}).Error(); s != "nothing to search for" {
+		t.Errorf("got %q", s)
+	}
+}
+
+// TestCompiledQueriesMatch checks that every compiled query is accepted by the search index.
+func TestCompiledQueriesMatch(t *testing.T) {
+	lib := newTestLibrary(t)
+	for _, test := range compileQueryTests {
+		if _, _, err := lib.SearchPaged(test.query, SearchOptions{}, 0, 10); err != nil {
+			t.Errorf("SearchPaged(%q) returned error %v", test.query, err)
+		}
+	}
+}
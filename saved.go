@@ -0,0 +1,132 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSavedSearchNotFound is returned when a saved search doesn't exist.
+var ErrSavedSearchNotFound = errors.New("saved search not found")
+
+// SavedSearch is a named search, also called a smart shelf.
+// Unlike a shelf, its books aren't stored: they're whatever the search finds when it's run.
+type SavedSearch struct {
+	ID   int64
+	Name string
+	// Query is the search terms, as accepted by SearchPaged. It may be empty, to find every book matching Options.
+	Query   string
+	Options SearchOptions
+}
+
+// SaveSearch saves a search under name, replacing any saved search with the same name, ignoring case.
+// If query can't be parsed, the error is a *QueryError.
+func (lib *Library) SaveSearch(name, query string, opts SearchOptions) (SavedSearch, error) {
+	s := SavedSearch{Name: strings.TrimSpace(name), Query: strings.TrimSpace(query), Options: opts}
+	if err := checkName("saved search", s.Name); err != nil {
+		return s, err
+	}
+	if s.Query != "" {
+		if _, err := CompileQuery(s.Query); err != nil {
+			return s, err
+		}
+	}
+	params := opts.Params().Encode()
+	ctx := context.Background()
+	tx, err := lib.begin(ctx)
+	if err != nil {
+		return s, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, "select id from saved_searches where name=?", s.Name).Scan(&s.ID)
+	if err == sql.ErrNoRows {
+		res, err := tx.ExecContext(ctx, "insert into saved_searches (name, query, params) values(?, ?, ?)", s.Name, s.Query, params)
+		if err != nil {
+			return s, errors.Wrap(err, "insert saved search")
+		}
+		if s.ID, err = res.LastInsertId(); err != nil {
+			return s, errors.Wrap(err, "get saved search ID")
+		}
+	} else if err != nil {
+		return s, errors.Wrap(err, "get saved search")
+	} else if _, err := tx.ExecContext(ctx, "update saved_searches set updated_on=datetime(), name=?, query=?, params=? where id=?", s.Name, s.Query, params, s.ID); err != nil {
+		return s, errors.Wrap(err, "update saved search")
+	}
+	return s, errors.Wrap(tx.Commit(), "commit transaction")
+}
+
+// DeleteSavedSearch deletes a saved search.
+func (lib *Library) DeleteSavedSearch(name string) error {
+	res, err := lib.Exec("delete from saved_searches where name=?", name)
+	if err != nil {
+		return errors.Wrap(err, "delete saved search")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "delete saved search")
+	} else if n == 0 {
+		return ErrSavedSearchNotFound
+	}
+	return nil
+}
+
+// GetSavedSearches returns every saved search, sorted by name.
+func (lib *Library) GetSavedSearches() ([]SavedSearch, error) {
+	return lib.GetSavedSearchesContext(context.Background())
+}
+
+// GetSavedSearchesContext is like GetSavedSearches, but stops if ctx is canceled.
+func (lib *Library) GetSavedSearchesContext(ctx context.Context) ([]SavedSearch, error) {
+	rows, err := lib.readDB.QueryContext(ctx, "select id, name, query, params from saved_searches order by name")
+	if err != nil {
+		return nil, errors.Wrap(err, "query saved searches")
+	}
+	defer rows.Close()
+	var searches []SavedSearch
+	for rows.Next() {
+		s, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, s)
+	}
+	return searches, errors.Wrap(rows.Err(), "query saved searches")
+}
+
+// GetSavedSearch returns a saved search by name, ignoring case.
+// If it doesn't exist, ErrSavedSearchNotFound is returned.
+func (lib *Library) GetSavedSearch(name string) (SavedSearch, error) {
+	return lib.GetSavedSearchContext(context.Background(), name)
+}
+
+// GetSavedSearchContext is like GetSavedSearch, but stops if ctx is canceled.
+func (lib *Library) GetSavedSearchContext(ctx context.Context, name string) (SavedSearch, error) {
+	s, err := scanSavedSearch(lib.readDB.QueryRowContext(ctx, "select id, name, query, params from saved_searches where name=?", name))
+	if errors.Cause(err) == sql.ErrNoRows {
+		return s, ErrSavedSearchNotFound
+	}
+	return s, err
+}
+
+// scanSavedSearch scans a saved search's ID, name, query and params from row.
+func scanSavedSearch(row interface{ Scan(...interface{}) error }) (SavedSearch, error) {
+	var s SavedSearch
+	var params string
+	if err := row.Scan(&s.ID, &s.Name, &s.Query, &params); err != nil {
+		return s, errors.Wrap(err, "scan saved search")
+	}
+	q, err := url.ParseQuery(params)
+	if err != nil {
+		return s, errors.Wrapf(err, "parse options of saved search %s", s.Name)
+	}
+	if s.Options, err = ParseSearchParams(q); err != nil {
+		return s, errors.Wrapf(err, "parse options of saved search %s", s.Name)
+	}
+	return s, nil
+}
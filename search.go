@@ -0,0 +1,562 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SortOrder is the order search results are returned in.
+type SortOrder int
+
+const (
+	// SortRelevance returns the books which best match the search terms first.
+	// Without search terms, books are sorted by title.
+	// Relevance changes as books are imported and edited, so cursors from SearchAfter aren't stable with this order.
+	SortRelevance SortOrder = iota
+	// SortTitle sorts books by title, ignoring case and diacritics.
+	SortTitle
+	// SortAuthor sorts books by the sort name of their first author, such as "King, Stephen".
+	SortAuthor
+	// SortSeries sorts books by series, then by their index in the series. Books without a series come last.
+	SortSeries
+	// SortAdded sorts books by when they were added to the library, oldest first.
+	SortAdded
+	// SortSize sorts books by the total size of their files, smallest first.
+	SortSize
+)
+
+// SortOrderNames are the names accepted by ParseSortOrder.
+var SortOrderNames = []string{"relevance", "title", "author", "series", "added", "size"}
+
+// ParseSortOrder parses a sort order by name: relevance, title, author, series, added, or size.
+func ParseSortOrder(name string) (SortOrder, error) {
+	name = strings.ToLower(name)
+	if name == "" {
+		return SortRelevance, nil
+	}
+	for i, n := range SortOrderNames {
+		if n == name {
+			return SortOrder(i), nil
+		}
+	}
+	return SortRelevance, errors.Errorf("unknown sort order %s; orders are %s", name, strings.Join(SortOrderNames, ", "))
+}
+
+// String returns the name of the sort order.
+func (s SortOrder) String() string {
+	if s < 0 || int(s) >= len(SortOrderNames) {
+		return "unknown"
+	}
+	return SortOrderNames[s]
+}
+
+// SearchOptions filter and sort search results.
+// The zero value doesn't filter, and sorts by relevance.
+type SearchOptions struct {
+	// AddedAfter and AddedBefore limit results to books added at or after, and before, the given times.
+	// Zero times don't limit results.
+	AddedAfter, AddedBefore time.Time
+	// MinSize and MaxSize limit results by the total size of a book's files, in bytes. 0 doesn't limit results.
+	MinSize, MaxSize int64
+	// Formats are extensions, such as epub, which a book must have a file for,
+	// and WithoutFormats are extensions it must not.
+	Formats, WithoutFormats []string
+	// MinFiles and MaxFiles limit results by the number of files a book has. 0 doesn't limit results.
+	MinFiles, MaxFiles int
+	// Tags must all be on at least one of a book's files, and WithoutTags must not be on any of them.
+	Tags, WithoutTags []string
+	// Genres must all be genres of a book, and WithoutGenres must not be.
+	// A genre also matches its subgenres, so Fiction matches a book in Fiction/Fantasy.
+	Genres, WithoutGenres []string
+	// Authors must all have written a book, by their full names.
+	Authors []string
+	// Series limits results to books in the series with this name.
+	Series string
+	// Sort is the order results are returned in.
+	Sort SortOrder
+	// Reverse reverses the sort order.
+	Reverse bool
+}
+
+// Filtered returns true if any of the filters are set.
+func (o SearchOptions) Filtered() bool {
+	return !o.AddedAfter.IsZero() || !o.AddedBefore.IsZero() ||
+		o.MinSize > 0 || o.MaxSize > 0 ||
+		len(o.Formats) > 0 || len(o.WithoutFormats) > 0 ||
+		o.MinFiles > 0 || o.MaxFiles > 0 ||
+		len(o.Tags) > 0 || len(o.WithoutTags) > 0 ||
+		len(o.Genres) > 0 || len(o.WithoutGenres) > 0 ||
+		len(o.Authors) > 0 || o.Series != ""
+}
+
+// dbTimeFormat is the format SQLite's datetime() stores times in, always in UTC.
+const dbTimeFormat = "2006-01-02 15:04:05"
+
+// bookSizeExpr is the total size of the files of the book b.
+const bookSizeExpr = "(select ifnull(sum(file_size), 0) from files where book_id=b.id)"
+
+// filterSQL returns the conditions on the book b required by o, joined with and, with their arguments.
+// It returns an empty string if o doesn't filter.
+func (o SearchOptions) filterSQL() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, condArgs ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	if !o.AddedAfter.IsZero() {
+		add("b.created_on >= ?", o.AddedAfter.UTC().Format(dbTimeFormat))
+	}
+	if !o.AddedBefore.IsZero() {
+		add("b.created_on < ?", o.AddedBefore.UTC().Format(dbTimeFormat))
+	}
+	if o.MinSize > 0 {
+		add(bookSizeExpr+" >= ?", o.MinSize)
+	}
+	if o.MaxSize > 0 {
+		add(bookSizeExpr+" <= ?", o.MaxSize)
+	}
+	for _, f := range o.Formats {
+		add("exists (select 1 from files where book_id=b.id and extension=? collate nocase)", strings.TrimPrefix(f, "."))
+	}
+	for _, f := range o.WithoutFormats {
+		add("not exists (select 1 from files where book_id=b.id and extension=? collate nocase)", strings.TrimPrefix(f, "."))
+	}
+	if o.MinFiles > 0 {
+		add("(select count(*) from files where book_id=b.id) >= ?", o.MinFiles)
+	}
+	if o.MaxFiles > 0 {
+		add("(select count(*) from files where book_id=b.id) <= ?", o.MaxFiles)
+	}
+	const hasTag = `exists (select 1 from files f
+	join files_tags ft on ft.file_id=f.id
+	join tags t on t.id=ft.tag_id
+	where f.book_id=b.id and t.name=? collate nocase)`
+	for _, t := range o.Tags {
+		add(hasTag, t)
+	}
+	for _, t := range o.WithoutTags {
+		add("not "+hasTag, t)
+	}
+	const hasGenre = `exists (select 1 from books_genres bg
+	join genres g on g.id=bg.genre_id
+	where bg.book_id=b.id and ` + genreCondition + `)`
+	for _, g := range o.Genres {
+		g = NormalizeGenre(g)
+		add(hasGenre, g, subgenrePattern(g))
+	}
+	for _, g := range o.WithoutGenres {
+		g = NormalizeGenre(g)
+		add("not "+hasGenre, g, subgenrePattern(g))
+	}
+	for _, a := range o.Authors {
+		add("exists (select 1 from books_authors ba join authors a on a.id=ba.author_id where ba.book_id=b.id and a.name=?)", a)
+	}
+	if o.Series != "" {
+		add("b.series = ?", o.Series)
+	}
+	return strings.Join(conds, " and "), args
+}
+
+// sortKey is an expression search results are sorted by.
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+// sortKeys returns the expressions results are sorted by for o, most significant first.
+// ranked is true if the query matches books_fts, so that books can be sorted by relevance.
+// The last key is the book ID, so books are always returned in the same order.
+func (o SearchOptions) sortKeys(ranked bool) []sortKey {
+	var exprs []string
+	switch {
+	case o.Sort == SortRelevance && ranked:
+		exprs = []string{searchRank}
+	case o.Sort == SortAuthor:
+		exprs = []string{`ifnull((select a.sort_name from books_authors ba join authors a on a.id=ba.author_id
+		where ba.book_id=b.id order by ba.id limit 1), '') collate nocase`, "ifnull(b.title_key, '')"}
+	case o.Sort == SortSeries:
+		exprs = []string{"ifnull(b.series, '') collate nocase", "ifnull(b.series_index, 0)", "ifnull(b.title_key, '')"}
+	case o.Sort == SortAdded:
+		exprs = []string{"b.created_on"}
+	case o.Sort == SortSize:
+		exprs = []string{bookSizeExpr}
+	default:
+		exprs = []string{"ifnull(b.title_key, '')"}
+	}
+	var keys []sortKey
+	if o.Sort == SortSeries {
+		// Books without a series come last, whichever way the series are sorted.
+		keys = append(keys, sortKey{expr: "(ifnull(b.series, '') = '')"})
+	}
+	for _, e := range append(exprs, "b.id") {
+		keys = append(keys, sortKey{expr: e, desc: o.Reverse})
+	}
+	return keys
+}
+
+// orderSQL returns the order by clause for keys, without the order by keywords.
+func orderSQL(keys []sortKey) string {
+	var terms []string
+	for _, k := range keys {
+		if k.desc {
+			terms = append(terms, k.expr+" desc")
+		} else {
+			terms = append(terms, k.expr+" asc")
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+// afterSQL returns a condition matching the books which sort after a book whose sort keys have the given values, with its arguments.
+func afterSQL(keys []sortKey, values []interface{}) (string, []interface{}) {
+	var alternatives []string
+	var args []interface{}
+	for i, k := range keys {
+		var conds []string
+		for j := 0; j < i; j++ {
+			conds = append(conds, keys[j].expr+" = ?")
+			args = append(args, values[j])
+		}
+		if k.desc {
+			conds = append(conds, k.expr+" < ?")
+		} else {
+			conds = append(conds, k.expr+" > ?")
+		}
+		args = append(args, values[i])
+		alternatives = append(alternatives, "("+strings.Join(conds, " and ")+")")
+	}
+	return "(" + strings.Join(alternatives, " or ") + ")", args
+}
+
+// ErrInvalidCursor is returned by SearchAfter if a cursor can't be used.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// searchCursor is the position after the last book on a page of search results.
+type searchCursor struct {
+	Sort    SortOrder     `json:"s"`
+	Reverse bool          `json:"r"`
+	Values  []interface{} `json:"v"`
+}
+
+func (c searchCursor) encode() (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor decodes a cursor returned by a search with opts, whose results are sorted by keys.
+func decodeCursor(s string, opts SearchOptions, keys []sortKey) (searchCursor, error) {
+	var c searchCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.Sort != opts.Sort || c.Reverse != opts.Reverse || len(c.Values) != len(keys) {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// search returns the books matching terms and opts, starting at offset, or after cursor if it isn't empty.
+// It returns the total number of matching books, and the cursor for the next page if there is one.
+func (lib *Library) search(ctx context.Context, terms string, opts SearchOptions, cursor string, offset, limit int) (books []Book, total int, next string, err error) {
+	var match string
+	if strings.TrimSpace(terms) != "" {
+		match, err = CompileQuery(terms)
+		if err != nil {
+			return nil, 0, "", err
+		}
+	}
+	keys := opts.sortKeys(match != "")
+
+	// Books which match, without the cursor's condition, for counting them.
+	var from string
+	var conds []string
+	var args []interface{}
+	filter, filterArgs := opts.filterSQL()
+	if match != "" {
+		from = "books_fts join books b on b.id=books_fts.rowid"
+		conds = append(conds, "books_fts match ?")
+		args = append(args, match)
+	} else {
+		from = "books b"
+	}
+	if filter != "" {
+		conds = append(conds, filter)
+		args = append(args, filterArgs...)
+	}
+	if len(conds) == 0 {
+		conds = append(conds, "1")
+	}
+	pageConds := conds
+	pageArgs := args
+	if cursor != "" {
+		c, err := decodeCursor(cursor, opts, keys)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		after, afterArgs := afterSQL(keys, c.Values)
+		pageConds = append(pageConds[:len(pageConds):len(pageConds)], after)
+		pageArgs = append(pageArgs[:len(pageArgs):len(pageArgs)], afterArgs...)
+	}
+
+	columns := "b.id, '', '', '', ''"
+	if match != "" {
+		columns = `b.id, highlight(books_fts, 0, char(2), char(3)), highlight(books_fts, 1, char(2), char(3)), highlight(books_fts, 2, char(2), char(3)),
+	snippet(books_fts, -1, char(2), char(3), '…', 12)`
+	}
+	for _, k := range keys {
+		columns += ", " + k.expr
+	}
+	query := "select " + columns + " from " + from + " where " + strings.Join(pageConds, " and ") + " order by " + orderSQL(keys)
+	if limit > 0 {
+		// Fetch one more than a page, to know whether there's another page after it.
+		query += " limit ? offset ?"
+		pageArgs = append(pageArgs, limit+1, offset)
+	}
+
+	// Count and fetch the results in one transaction, so they agree with each other.
+	tx, err := lib.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, "", errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, query, pageArgs...)
+	if err != nil {
+		return nil, 0, "", errors.Wrap(err, "Querying db for search terms")
+	}
+	var ids []int64
+	var lastValues []interface{}
+	more := false
+	highlights := make(map[int64]*Highlight)
+	for rows.Next() {
+		var id int64
+		var authors string
+		h := &Highlight{}
+		values := make([]interface{}, len(keys))
+		dest := []interface{}{&id, &authors, &h.Series, &h.Title, &h.Snippet}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return nil, 0, "", errors.Wrap(err, "Retrieving search results from db")
+		}
+		if limit > 0 && len(ids) == limit {
+			// This is the first book of the next page.
+			more = true
+			break
+		}
+		h.Authors = strings.Split(authors, " & ")
+		ids = append(ids, id)
+		highlights[id] = h
+		lastValues = values
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, "", errors.Wrap(err, "Retrieving search results from db")
+	}
+
+	switch {
+	case limit == 0:
+		total = len(ids)
+	case !more && cursor == "" && (len(ids) > 0 || offset == 0):
+		// This is the last page, so there's no need to count the ones before it.
+		total = offset + len(ids)
+	default:
+		query := "select count(*) from " + from + " where " + strings.Join(conds, " and ")
+		if err := tx.QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+			return nil, 0, "", errors.Wrap(err, "count search results")
+		}
+	}
+
+	if more {
+		for i, v := range lastValues {
+			// Keep values in the form SQLite compares them in.
+			switch v := v.(type) {
+			case time.Time:
+				lastValues[i] = v.UTC().Format(dbTimeFormat)
+			case []byte:
+				lastValues[i] = string(v)
+			}
+		}
+		next, err = searchCursor{Sort: opts.Sort, Reverse: opts.Reverse, Values: lastValues}.encode()
+		if err != nil {
+			return nil, 0, "", errors.Wrap(err, "encode cursor")
+		}
+	}
+
+	books, err = getBooksByID(ctx, tx, ids)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if books == nil {
+		books = []Book{}
+	}
+	if match != "" {
+		for i := range books {
+			books[i].Highlight = highlights[books[i].ID]
+		}
+	}
+	return books, total, next, nil
+}
+
+// ParseSearchParams reads search filters and a sort order from URL query parameters, as returned by Params.
+// Parameters which can be repeated, such as format, may also hold comma-separated lists.
+func ParseSearchParams(q url.Values) (SearchOptions, error) {
+	var opts SearchOptions
+	for _, d := range []struct {
+		param string
+		t     *time.Time
+	}{{"added_after", &opts.AddedAfter}, {"added_before", &opts.AddedBefore}} {
+		if s := strings.TrimSpace(q.Get(d.param)); s != "" {
+			t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+			if err != nil {
+				return opts, errors.Errorf("Invalid date for %s: %s; use YYYY-MM-DD", d.param, s)
+			}
+			*d.t = t
+		}
+	}
+	for _, d := range []struct {
+		param string
+		size  *int64
+	}{{"min_size", &opts.MinSize}, {"max_size", &opts.MaxSize}} {
+		if s := strings.TrimSpace(q.Get(d.param)); s != "" {
+			size, err := ParseSize(s)
+			if err != nil {
+				return opts, errors.Errorf("Invalid size for %s: %s", d.param, s)
+			}
+			*d.size = size
+		}
+	}
+	for _, d := range []struct {
+		param string
+		n     *int
+	}{{"min_files", &opts.MinFiles}, {"max_files", &opts.MaxFiles}} {
+		if s := strings.TrimSpace(q.Get(d.param)); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return opts, errors.Errorf("Invalid number of files for %s: %s", d.param, s)
+			}
+			*d.n = n
+		}
+	}
+	opts.Formats = ParamList(q, "format")
+	opts.WithoutFormats = ParamList(q, "without_format")
+	opts.Tags = ParamList(q, "tag")
+	opts.WithoutTags = ParamList(q, "without_tag")
+	opts.Genres = ParamList(q, "genre")
+	opts.WithoutGenres = ParamList(q, "without_genre")
+	// Author names can have commas in them, so they aren't split.
+	opts.Authors = q["author"]
+	opts.Series = q.Get("series")
+	opts.Reverse = q.Get("reverse") != ""
+	var err error
+	opts.Sort, err = ParseSortOrder(q.Get("sort"))
+	if err != nil {
+		return opts, errors.Errorf("Invalid sort order %s", q.Get("sort"))
+	}
+	return opts, nil
+}
+
+// ParamList returns the values of a query parameter, splitting any comma-separated lists.
+func ParamList(q url.Values, param string) []string {
+	var items []string
+	for _, v := range q[param] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// Params returns the URL query parameters which ParseSearchParams reads o from.
+// Dates are given in local time.
+func (o SearchOptions) Params() url.Values {
+	q := url.Values{}
+	if !o.AddedAfter.IsZero() {
+		q.Set("added_after", o.AddedAfter.Local().Format("2006-01-02"))
+	}
+	if !o.AddedBefore.IsZero() {
+		q.Set("added_before", o.AddedBefore.Local().Format("2006-01-02"))
+	}
+	for _, d := range []struct {
+		param string
+		n     int64
+	}{{"min_size", o.MinSize}, {"max_size", o.MaxSize}, {"min_files", int64(o.MinFiles)}, {"max_files", int64(o.MaxFiles)}} {
+		if d.n > 0 {
+			q.Set(d.param, strconv.FormatInt(d.n, 10))
+		}
+	}
+	for _, d := range []struct {
+		param string
+		items []string
+	}{
+		{"format", o.Formats}, {"without_format", o.WithoutFormats},
+		{"tag", o.Tags}, {"without_tag", o.WithoutTags},
+		{"genre", o.Genres}, {"without_genre", o.WithoutGenres},
+		{"author", o.Authors},
+	} {
+		for _, item := range d.items {
+			q.Add(d.param, item)
+		}
+	}
+	if o.Series != "" {
+		q.Set("series", o.Series)
+	}
+	if o.Sort != SortRelevance {
+		q.Set("sort", o.Sort.String())
+	}
+	if o.Reverse {
+		q.Set("reverse", "1")
+	}
+	return q
+}
+
+// ParseSize parses a size in bytes, optionally followed by a unit: k, M, G or T, with or without B.
+// Units are powers of 1024, so 2M is 2097152 bytes.
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimSpace(s)
+	num = strings.TrimSuffix(strings.TrimSuffix(num, "B"), "b")
+	multiplier := 1.0
+	if num != "" {
+		switch num[len(num)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		case 't', 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			num = num[:len(num)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || !(n >= 0) || math.IsInf(n, 0) {
+		return 0, errors.Errorf("invalid size %s", s)
+	}
+	return int64(n * multiplier), nil
+}
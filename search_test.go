@@ -0,0 +1,162 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		size int64
+	}{
+		{"0", 0},
+		{"100", 100},
+		{"100b", 100},
+		{"100B", 100},
+		{"1k", 1 << 10},
+		{"1KB", 1 << 10},
+		{"1.5m", 3 << 19},
+		{"2M", 2 << 20},
+		{" 2 mb ", 2 << 20},
+		{"1g", 1 << 30},
+		{"1T", 1 << 40},
+		{"0.5k", 512},
+	}
+	for _, test := range tests {
+		size, err := ParseSize(test.s)
+		if err != nil {
+			t.Errorf("ParseSize(%q) returned error %v", test.s, err)
+			continue
+		}
+		if size != test.size {
+			t.Errorf("ParseSize(%q) = %d, want %d", test.s, size, test.size)
+		}
+	}
+}
+
+func TestParseSizeErrors(t *testing.T) {
+	for _, s := range []string{"", "b", "k", "-1", "-1m", "1x", "1kk", "abc", "NaN", "Inf", "1e400"} {
+		if size, err := ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", s, size)
+		}
+	}
+}
+
+// newSortTestLibrary returns a library with books that have ties in every sort order.
+func newSortTestLibrary(t *testing.T) *Library {
+	t.Helper()
+	lib := newTestLibrary(t)
+	books := []struct {
+		book  Book
+		size  int
+		added string
+	}{
+		{Book{Authors: []string{"Frank Herbert"}, Title: "Dune", Series: "Dune", SeriesIndex: 1}, 300, "2020-01-01 00:00:00"},
+		{Book{Authors: []string{"Frank Herbert"}, Title: "Dune Messiah", Series: "Dune", SeriesIndex: 2}, 200, "2020-01-01 00:00:00"},
+		{Book{Authors: []string{"Frank Herbert"}, Title: "Children of Dune", Series: "Dune", SeriesIndex: 3}, 200, "2020-02-01 00:00:00"},
+		{Book{Authors: []string{"Brian Herbert", "Kevin J. Anderson"}, Title: "Hunters of Dune"}, 400, "2020-02-01 00:00:00"},
+		{Book{Authors: []string{"Anne Other"}, Title: "dune"}, 300, "2019-06-01 00:00:00"},
+		{Book{Authors: []string{"Isaac Asimov"}, Title: "Foundation", Series: "Foundation", SeriesIndex: 1}, 500, "2021-01-01 00:00:00"},
+		{Book{Authors: []string{"Isaac Asimov"}, Title: "Foundation and Empire", Series: "Foundation", SeriesIndex: 2}, 500, "2021-01-01 00:00:00"},
+		{Book{Authors: []string{"Plato"}, Title: "The Republic"}, 100, "2018-01-01 00:00:00"},
+		{Book{Title: "Anonymous"}, 100, "2021-01-01 00:00:00"},
+	}
+	for _, b := range books {
+		id := importTestBook(t, lib, b.book, b.size)
+		if _, err := lib.Exec("update books set created_on=? where id=?", b.added, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lib
+}
+
+func TestSearchAfter(t *testing.T) {
+	lib := newSortTestLibrary(t)
+	for _, terms := range []string{"", "dune"} {
+		for sort := range SortOrderNames {
+			for _, reverse := range []bool{false, true} {
+				opts := SearchOptions{Sort: SortOrder(sort), Reverse: reverse}
+				all, total, err := lib.SearchPaged(terms, opts, 0, 0)
+				if err != nil {
+					t.Fatalf("SearchPaged(%q, %+v) returned error %v", terms, opts, err)
+				}
+				var want []int64
+				for _, b := range all {
+					want = append(want, b.ID)
+				}
+
+				// Page through every book, and check the books are the same as returned in one page.
+				var got []int64
+				cursor := ""
+				for page := 0; page < len(want); page++ {
+					books, pageTotal, next, err := lib.SearchAfter(terms, opts, cursor, 2)
+					if err != nil {
+						t.Fatalf("SearchAfter(%q, %+v) returned error %v on page %d", terms, opts, err, page)
+					}
+					if pageTotal != total {
+						t.Errorf("SearchAfter(%q, %+v) returned total %d on page %d, want %d", terms, opts, pageTotal, page, total)
+					}
+					for _, b := range books {
+						got = append(got, b.ID)
+					}
+					if next == "" {
+						break
+					}
+					cursor = next
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Paging through %q sorted by %v, reverse %v returned books %v, want %v", terms, opts.Sort, reverse, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSearchAfterInvalidCursor(t *testing.T) {
+	lib := newSortTestLibrary(t)
+	_, _, next, err := lib.SearchAfter("", SearchOptions{Sort: SortTitle}, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		opts   SearchOptions
+		cursor string
+	}{
+		{SearchOptions{Sort: SortTitle}, "not a cursor"},
+		{SearchOptions{Sort: SortTitle}, "e30"},
+		{SearchOptions{Sort: SortAuthor}, next},
+		{SearchOptions{Sort: SortTitle, Reverse: true}, next},
+	} {
+		if _, _, _, err := lib.SearchAfter("", test.opts, test.cursor, 2); err != ErrInvalidCursor {
+			t.Errorf("SearchAfter with cursor %q and %+v returned error %v, want %v", test.cursor, test.opts, err, ErrInvalidCursor)
+		}
+	}
+}
+
+func TestParamList(t *testing.T) {
+	tests := []struct {
+		query string
+		items []string
+	}{
+		{"", nil},
+		{"format=", nil},
+		{"format=epub", []string{"epub"}},
+		{"format=epub,+mobi&format=pdf", []string{"epub", "mobi", "pdf"}},
+		{"format=,epub,,&tag=retail", []string{"epub"}},
+	}
+	for _, test := range tests {
+		q, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if items := ParamList(q, "format"); !reflect.DeepEqual(items, test.items) {
+			t.Errorf("ParamList(%q, format) = %q, want %q", test.query, items, test.items)
+		}
+	}
+}
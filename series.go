@@ -0,0 +1,179 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSeriesNotFound is returned when no book is in the requested series.
+var ErrSeriesNotFound = errors.New("series not found")
+
+// Series is the books in a series, in reading order.
+type Series struct {
+	Name string
+	// Entries are the series' books in order of their index, with entries for missing volumes in between.
+	// Books without an index come last.
+	Entries []SeriesEntry
+	// Missing and Duplicates are the indexes which no book, and more than one book, has.
+	Missing, Duplicates []float64
+}
+
+// SeriesEntry is a book in a series, or a volume which is missing from the library.
+type SeriesEntry struct {
+	// Index is the book's position in the series, or 0 if it doesn't have one.
+	Index float64
+	// Book is nil if the library doesn't have a book with this index.
+	Book *Book
+	// Duplicate is true if another book in the series has the same index.
+	Duplicate bool
+}
+
+// GetSeriesByName returns the books in a series in reading order, noting missing volumes and duplicate indexes.
+// Volumes are considered missing if no book has a whole number index between 1 and the highest index in the series.
+// If the series has no books, ErrSeriesNotFound is returned.
+func (lib *Library) GetSeriesByName(name string) (Series, error) {
+	return lib.GetSeriesByNameContext(context.Background(), name)
+}
+
+// GetSeriesByNameContext is like GetSeriesByName, but stops if ctx is canceled.
+func (lib *Library) GetSeriesByNameContext(ctx context.Context, name string) (Series, error) {
+	series := Series{Name: name}
+	bks, _, err := lib.SearchPagedContext(ctx, "", SearchOptions{Series: name, Sort: SortSeries}, 0, 0)
+	if err != nil {
+		return series, err
+	}
+	if len(bks) == 0 {
+		return series, ErrSeriesNotFound
+	}
+
+	var unnumbered []SeriesEntry
+	next := 1.0 // The next whole number index expected
+	for i := range bks {
+		b := &bks[i]
+		if b.SeriesIndex <= 0 {
+			unnumbered = append(unnumbered, SeriesEntry{Book: b})
+			continue
+		}
+		for ; next < b.SeriesIndex; next++ {
+			series.Entries = append(series.Entries, SeriesEntry{Index: next})
+			series.Missing = append(series.Missing, next)
+		}
+		if n := len(series.Entries); n > 0 && series.Entries[n-1].Book != nil && series.Entries[n-1].Index == b.SeriesIndex {
+			if !series.Entries[n-1].Duplicate {
+				series.Duplicates = append(series.Duplicates, b.SeriesIndex)
+			}
+			series.Entries[n-1].Duplicate = true
+			series.Entries = append(series.Entries, SeriesEntry{Index: b.SeriesIndex, Book: b, Duplicate: true})
+			continue
+		}
+		series.Entries = append(series.Entries, SeriesEntry{Index: b.SeriesIndex, Book: b})
+		if b.SeriesIndex >= next {
+			next = math.Floor(b.SeriesIndex) + 1
+		}
+	}
+	series.Entries = append(series.Entries, unnumbered...)
+	return series, nil
+}
+
+// RenameSeries renames a series, renaming its books' files according to tmpl.
+// It returns the number of books renamed.
+// If the series has no books, ErrSeriesNotFound is returned.
+func (lib *Library) RenameSeries(oldName, newName string, tmpl *template.Template) (int, error) {
+	return lib.MergeSeries([]string{oldName}, newName, tmpl)
+}
+
+// MergeSeries moves the books in each of the series in from into the series into, keeping their indexes,
+// and renames their files according to tmpl.
+// into may be a new series, or one of the series being merged.
+// It returns the number of books moved.
+// If none of the series in from have any books, ErrSeriesNotFound is returned.
+func (lib *Library) MergeSeries(from []string, into string, tmpl *template.Template) (int, error) {
+	ctx := context.Background()
+	tx, err := lib.begin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "begin transaction")
+	}
+	n, err := moveSeries(ctx, tx, from, into, tmpl)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "commit transaction")
+	}
+	return n, nil
+}
+
+// moveSeries implements MergeSeries.
+func moveSeries(ctx context.Context, tx *sql.Tx, from []string, into string, tmpl *template.Template) (int, error) {
+	var ids []int64
+	for _, name := range from {
+		if name == into {
+			continue
+		}
+		rows, err := tx.QueryContext(ctx, "select id from books where series=?", name)
+		if err != nil {
+			return 0, errors.Wrap(err, "get books in series")
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return 0, errors.Wrap(err, "get books in series")
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return 0, errors.Wrap(err, "get books in series")
+		}
+		rows.Close()
+	}
+	if len(ids) == 0 {
+		// Nothing needs moving, which is only an error if none of the series exist.
+		for _, name := range from {
+			var exists bool
+			if err := tx.QueryRowContext(ctx, "select exists (select 1 from books where series=?)", name).Scan(&exists); err != nil {
+				return 0, errors.Wrap(err, "get books in series")
+			}
+			if exists {
+				return 0, nil
+			}
+		}
+		return 0, ErrSeriesNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "update books set updated_on=datetime(), series=? where id in ("+joinInt64s(ids, ",")+")", into); err != nil {
+		return 0, errors.Wrap(err, "update series")
+	}
+	bks, err := getBooksByID(ctx, tx, ids)
+	if err != nil {
+		return 0, errors.Wrap(err, "get books")
+	}
+	for i := range bks {
+		book := &bks[i]
+		for _, f := range book.Files {
+			newFn, err := f.Filename(tmpl, book)
+			if err != nil {
+				return 0, errors.Wrap(err, "get filename")
+			}
+			if newFn == f.CurrentFilename {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "update files set updated_on=datetime(), filename=? where id=?", newFn, f.ID); err != nil {
+				return 0, errors.Wrap(err, "update filename")
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "update books_fts set series=? where rowid=?", into, book.ID); err != nil {
+			return 0, errors.Wrap(err, "update fts")
+		}
+	}
+	return len(ids), nil
+}
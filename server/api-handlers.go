@@ -7,7 +7,11 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/tspivey/books"
@@ -20,7 +24,7 @@ func (srv *Server) getBookHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	bookList, err := srv.lib.GetBooksByID([]int64{int64(id)})
+	bookList, err := srv.lib.GetBooksByIDContext(r.Context(), []int64{int64(id)})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("error getting book by ID: %v", err)
@@ -49,7 +53,7 @@ func (srv *Server) updateBookHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, apiError{"no title/authors"})
 		return
 	}
-	err := srv.lib.UpdateBook(book, srv.outputTemplate, ub.OverwriteSeries)
+	err := srv.lib.UpdateBookContext(r.Context(), book, srv.outputTemplate, ub.OverwriteSeries)
 	if bee, ok := err.(books.BookExistsError); ok {
 		msg := fmt.Sprintf("Book exists: %d", bee.BookID)
 		writeJSON(w, apiError{msg})
@@ -74,7 +78,7 @@ func (srv *Server) mergeHandler(w http.ResponseWriter, r *http.Request) {
 	if !readPostedJSON(w, r, &ids) {
 		return
 	}
-	if err := srv.lib.MergeBooks(ids, srv.outputTemplate); err != nil {
+	if err := srv.lib.MergeBooksContext(r.Context(), ids, srv.outputTemplate); err != nil {
 		log.Printf("error merging books: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		writeJSON(w, apiError{"error merging books"})
@@ -83,14 +87,115 @@ func (srv *Server) mergeHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, success{"merged"})
 }
 
+// maxImportSize is the largest file which can be imported with the API.
+const maxImportSize = 1 << 30
+
+// apiImportHandler imports a book from a file uploaded as multipart/form-data, in the file field.
+// The book's metadata is in the title, author, series, series_index, genre, tag and source fields.
+// Author may be repeated, and genre and tag may also be repeated or hold comma-separated lists.
+func (srv *Server) apiImportHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	fp, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"no file uploaded"})
+		return
+	}
+	defer fp.Close()
+	form := url.Values(r.MultipartForm.Value)
+	book := books.Book{
+		Title:  strings.TrimSpace(form.Get("title")),
+		Series: strings.TrimSpace(form.Get("series")),
+		Genres: books.ParamList(form, "genre"),
+	}
+	for _, author := range form["author"] {
+		if author = strings.TrimSpace(author); author != "" {
+			book.Authors = append(book.Authors, author)
+		}
+	}
+	if book.Title == "" || len(book.Authors) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"a title and at least one author are required"})
+		return
+	}
+	if s := strings.TrimSpace(form.Get("series_index")); s != "" {
+		if book.SeriesIndex, err = strconv.ParseFloat(s, 64); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid series index"})
+			return
+		}
+	}
+	filename := path.Base(header.Filename)
+	ext := strings.TrimPrefix(path.Ext(filename), ".")
+	if ext == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"the file has no extension"})
+		return
+	}
+	book.Files = []books.BookFile{{
+		Extension:        ext,
+		OriginalFilename: filename,
+		FileMtime:        time.Now(),
+		Tags:             books.ParamList(form, "tag"),
+		Source:           strings.TrimSpace(form.Get("source")),
+	}}
+
+	result, err := srv.lib.ImportReaderContext(r.Context(), fp, book, srv.outputTemplate, srv.duplicatePolicy)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("error importing %s: %v", filename, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error importing book"})
+		return
+	}
+	writeJSON(w, ImportResult{Status: importStatusNames[result.Status], BookID: result.BookID, CollidingBookIDs: result.CollidingBookIDs})
+}
+
 func (srv *Server) apiSearchHandler(w http.ResponseWriter, r *http.Request) {
-	term, ok := r.URL.Query()["term"]
-	if !ok {
+	opts, err := books.ParseSearchParams(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	if _, ok := r.URL.Query()["term"]; !ok && !opts.Filtered() {
 		w.WriteHeader(http.StatusBadRequest)
 		writeJSON(w, apiError{"no term specified"})
 		return
 	}
-	bookList, err := srv.lib.Search(term[0])
+	srv.writeSearchResults(w, r, r.URL.Query().Get("term"), opts)
+}
+
+// writeSearchResults writes the books matching terms and opts, limited by the limit and cursor parameters.
+// The total number of books found and the cursor of the next page are sent in headers.
+// Cursors are only stable while the library is unchanged when sorting by relevance, the default for searches,
+// so clients paging through many results should ask for another sort order, such as sort=title.
+func (srv *Server) writeSearchResults(w http.ResponseWriter, r *http.Request, terms string, opts books.SearchOptions) {
+	var err error
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid limit"})
+			return
+		}
+	}
+	bookList, total, next, err := srv.lib.SearchAfterContext(r.Context(), terms, opts, r.URL.Query().Get("cursor"), limit)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err == books.ErrInvalidCursor {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"invalid cursor"})
+		return
+	}
+	if qe, ok := err.(*books.QueryError); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{qe.Error()})
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("error searching for book: %v", err)
@@ -100,9 +205,219 @@ func (srv *Server) apiSearchHandler(w http.ResponseWriter, r *http.Request) {
 	for i := range bookList {
 		newList = append(newList, bookToModel(bookList[i]))
 	}
+	// The results stay a plain list, so the page details go in headers.
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
 	writeJSON(w, newList)
 }
 
+// genreCount is a genre with the number of books in it or its subgenres.
+type genreCount struct {
+	Name  string `json:"name"`
+	Books int    `json:"books"`
+}
+
+func (srv *Server) apiGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := srv.lib.GetGenresContext(r.Context())
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting genres: %v", err)
+		return
+	}
+	list := []genreCount{}
+	for _, g := range genres {
+		list = append(list, genreCount{g.Name, g.Books})
+	}
+	writeJSON(w, list)
+}
+
+func (srv *Server) apiShelvesHandler(w http.ResponseWriter, r *http.Request) {
+	shelves, err := srv.lib.GetShelvesContext(r.Context())
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting shelves: %v", err)
+		return
+	}
+	list := []Shelf{}
+	for _, s := range shelves {
+		list = append(list, shelfToModel(s))
+	}
+	writeJSON(w, list)
+}
+
+func (srv *Server) apiCreateShelfHandler(w http.ResponseWriter, r *http.Request) {
+	var s Shelf
+	if !readPostedJSON(w, r, &s) {
+		return
+	}
+	shelf, err := srv.lib.CreateShelf(s.Name, s.Description)
+	if err == books.ErrShelfExists {
+		w.WriteHeader(http.StatusConflict)
+		writeJSON(w, apiError{"shelf exists"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	writeJSON(w, shelfToModel(shelf))
+}
+
+func (srv *Server) apiShelfHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, bks, err := srv.lib.GetShelfContext(r.Context(), mux.Vars(r)["name"])
+	if r.Context().Err() != nil {
+		return
+	}
+	if !shelfOK(w, err) {
+		return
+	}
+	model := ShelfBooks{Name: shelf.Name, Description: shelf.Description, Books: []Book{}}
+	for _, b := range bks {
+		model.Books = append(model.Books, bookToModel(b))
+	}
+	writeJSON(w, model)
+}
+
+func (srv *Server) apiAddToShelfHandler(w http.ResponseWriter, r *http.Request) {
+	var sc shelfChange
+	if !readPostedJSON(w, r, &sc) {
+		return
+	}
+	err := srv.lib.AddToShelf(mux.Vars(r)["name"], sc.IDs, sc.Position)
+	if err == books.ErrBookNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"book not found"})
+		return
+	}
+	if !shelfOK(w, err) {
+		return
+	}
+	writeJSON(w, success{"added"})
+}
+
+func (srv *Server) apiRemoveFromShelfHandler(w http.ResponseWriter, r *http.Request) {
+	var sc shelfChange
+	if !readPostedJSON(w, r, &sc) {
+		return
+	}
+	_, err := srv.lib.RemoveFromShelf(mux.Vars(r)["name"], sc.IDs)
+	if !shelfOK(w, err) {
+		return
+	}
+	writeJSON(w, success{"removed"})
+}
+
+func (srv *Server) apiDeleteShelfHandler(w http.ResponseWriter, r *http.Request) {
+	err := srv.lib.DeleteShelf(mux.Vars(r)["name"])
+	if !shelfOK(w, err) {
+		return
+	}
+	writeJSON(w, success{"deleted"})
+}
+
+func (srv *Server) apiSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	searches, err := srv.lib.GetSavedSearchesContext(r.Context())
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting saved searches: %v", err)
+		return
+	}
+	list := []SavedSearch{}
+	for _, s := range searches {
+		list = append(list, savedSearchToModel(s))
+	}
+	writeJSON(w, list)
+}
+
+func (srv *Server) apiSaveSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var s SavedSearch
+	if !readPostedJSON(w, r, &s) {
+		return
+	}
+	q, err := url.ParseQuery(s.Params)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"invalid params"})
+		return
+	}
+	opts, err := books.ParseSearchParams(q)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	saved, err := srv.lib.SaveSearch(s.Name, s.Query, opts)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	writeJSON(w, savedSearchToModel(saved))
+}
+
+// apiRunSavedSearchHandler writes the books found by a saved search, like apiSearchHandler.
+func (srv *Server) apiRunSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := srv.lib.GetSavedSearchContext(r.Context(), mux.Vars(r)["name"])
+	if r.Context().Err() != nil {
+		return
+	}
+	if !savedSearchOK(w, err) {
+		return
+	}
+	srv.writeSearchResults(w, r, s.Query, s.Options)
+}
+
+func (srv *Server) apiDeleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if !savedSearchOK(w, srv.lib.DeleteSavedSearch(mux.Vars(r)["name"])) {
+		return
+	}
+	writeJSON(w, success{"deleted"})
+}
+
+// savedSearchOK writes an error response and returns false if err isn't nil.
+func savedSearchOK(w http.ResponseWriter, err error) bool {
+	if err == books.ErrSavedSearchNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"saved search not found"})
+		return false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting saved search: %v", err)
+		writeJSON(w, apiError{"internal server error"})
+		return false
+	}
+	return true
+}
+
+// shelfOK writes an error response and returns false if err, from a shelf operation, isn't nil.
+func shelfOK(w http.ResponseWriter, err error) bool {
+	if err == books.ErrShelfNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"shelf not found"})
+		return false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error changing shelf: %v", err)
+		writeJSON(w, apiError{"internal server error"})
+		return false
+	}
+	return true
+}
+
 func writeJSON(w http.ResponseWriter, v interface{}) {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tspivey/books"
+)
+
+// importRequest returns a request to import contents, uploaded as filename, with the given form fields.
+func importRequest(t *testing.T, key, filename, contents string, fields map[string][]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, values := range fields {
+		for _, v := range values {
+			mw.WriteField(name, v)
+		}
+	}
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(contents))
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/api/import", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("X-API-Key", key)
+	return r
+}
+
+func TestAPIImport(t *testing.T) {
+	srv, lib := newTestServer(t)
+	if _, err := lib.AddUser("editor", "password", books.RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.AddUser("reader", "password", books.RoleReader); err != nil {
+		t.Fatal(err)
+	}
+	_, importKey, err := lib.CreateAPIKey("import", "editor", []books.Scope{books.ScopeImport}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, readKey, err := lib.CreateAPIKey("read", "editor", []books.Scope{books.ScopeRead}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, readerImportKey, err := lib.CreateAPIKey("reader import", "reader", []books.Scope{books.ScopeImport}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string][]string{
+		"title":        {"Dune"},
+		"author":       {"Frank Herbert"},
+		"series":       {"Dune"},
+		"series_index": {"1"},
+		"tag":          {"retail,v1"},
+		"genre":        {"Fiction/Science Fiction"},
+		"source":       {"upload"},
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		filename string
+		fields   map[string][]string
+		code     int
+		status   string
+	}{
+		{"import", importKey, "dune.epub", fields, http.StatusOK, "imported"},
+		{"import again", importKey, "dune copy.epub", fields, http.StatusOK, "duplicate"},
+		{"key without the import scope", readKey, "dune.epub", fields, http.StatusForbidden, ""},
+		{"key of a reader", readerImportKey, "dune.epub", fields, http.StatusForbidden, ""},
+		{"no author", importKey, "dune.epub", map[string][]string{"title": {"Dune"}}, http.StatusBadRequest, ""},
+		{"no extension", importKey, "dune", fields, http.StatusBadRequest, ""},
+	}
+	var bookID int64
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		srv.hsrv.Handler.ServeHTTP(w, importRequest(t, test.key, test.filename, "the contents of Dune", test.fields))
+		if w.Code != test.code {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.code, w.Body)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		var result ImportResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if result.Status != test.status {
+			t.Errorf("%s: got import status %s, want %s", test.name, result.Status, test.status)
+		}
+		if bookID == 0 {
+			bookID = result.BookID
+		} else if result.BookID != bookID {
+			t.Errorf("%s: imported into book %d, want %d", test.name, result.BookID, bookID)
+		}
+	}
+
+	bks, err := lib.GetBooksByID([]int64{bookID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bks) != 1 || len(bks[0].Files) != 1 {
+		t.Fatalf("Imported books are %+v", bks)
+	}
+	book, bf := bks[0], bks[0].Files[0]
+	if book.Title != "Dune" || book.Series != "Dune" || book.SeriesIndex != 1 || len(book.Genres) != 1 ||
+		bf.Extension != "epub" || bf.OriginalFilename != "dune.epub" || bf.Source != "upload" || len(bf.Tags) != 2 {
+		t.Errorf("Imported book is %+v", book)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(srv.booksRoot, bf.HashPath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "the contents of Dune" || bf.FileSize != int64(len(contents)) {
+		t.Errorf("Imported file has contents %q and size %d", contents, bf.FileSize)
+	}
+	leftovers, err := filepath.Glob(filepath.Join(srv.booksRoot, ".import-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) > 0 {
+		t.Errorf("Temporary files were left: %v", leftovers)
+	}
+}
@@ -12,11 +12,25 @@ type apiError struct {
 
 // Book represents a book in a library.
 type Book struct {
-	ID      int64      `json:"id"`
-	Authors []string   `json:"authors"`
-	Title   string     `json:"title"`
-	Series  string     `json:"series"`
-	Files   []BookFile `json:"files"`
+	ID          int64             `json:"id"`
+	Authors     []string          `json:"authors"`
+	Title       string            `json:"title"`
+	Series      string            `json:"series"`
+	SeriesIndex float64           `json:"series_index"`
+	Identifiers map[string]string `json:"identifiers"`
+	// Genres are left unchanged by an update if they're omitted, and cleared if they're an empty list.
+	Genres    []string   `json:"genres"`
+	Files     []BookFile `json:"files"`
+	Highlight *Highlight `json:"highlight,omitempty"`
+}
+
+// Highlight shows where a book matched a search.
+// Matching words are surrounded by <mark> and </mark>, and the rest of the text is HTML escaped.
+type Highlight struct {
+	Authors []string `json:"authors"`
+	Title   string   `json:"title"`
+	Series  string   `json:"series"`
+	Snippet string   `json:"snippet"`
 }
 
 // BookFile represents a file linked to a book.
@@ -29,6 +43,7 @@ type BookFile struct {
 	Filename         string    `json:"filename"`
 	Mtime            time.Time `json:"mtime"`
 	Size             int64     `json:"size"`
+	Source           string    `json:"source"`
 }
 
 type updateBook struct {
@@ -36,6 +51,63 @@ type updateBook struct {
 	OverwriteSeries bool `json:"overwrite_series"`
 }
 
+// Shelf is a named, ordered list of books.
+type Shelf struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Books is the number of books on the shelf.
+	Books int `json:"books"`
+}
+
+// ShelfBooks is a shelf with its books, in order.
+type ShelfBooks struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Books       []Book `json:"books"`
+}
+
+// shelfChange is posted to add books to, or remove them from, a shelf.
+type shelfChange struct {
+	IDs []int64 `json:"ids"`
+	// Position is where to add the books, starting from 1. 0 adds them to the end.
+	Position int `json:"position"`
+}
+
+func shelfToModel(shelf books.Shelf) Shelf {
+	return Shelf{Name: shelf.Name, Description: shelf.Description, Books: shelf.Books}
+}
+
+// SavedSearch is a named search.
+type SavedSearch struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	// Params are the search's filters and sort order, as URL query parameters accepted by /api/search, such as genre=Fantasy&sort=added.
+	Params string `json:"params"`
+}
+
+func savedSearchToModel(s books.SavedSearch) SavedSearch {
+	return SavedSearch{Name: s.Name, Query: s.Query, Params: s.Options.Params().Encode()}
+}
+
+// ImportResult describes what importing a file did.
+type ImportResult struct {
+	// Status is imported, duplicate if the book already had the file, skipped if another book had it,
+	// or attached if the file was attached to the book which had it.
+	Status string `json:"status"`
+	// BookID is the ID of the book the file was imported into, attached to, or found in.
+	BookID int64 `json:"book_id"`
+	// CollidingBookIDs are the IDs of other books which already had a file with the same contents.
+	CollidingBookIDs []int64 `json:"colliding_book_ids,omitempty"`
+}
+
+// importStatusNames are the names of the import statuses in an ImportResult.
+var importStatusNames = map[books.ImportStatus]string{
+	books.ImportImported:  "imported",
+	books.ImportDuplicate: "duplicate",
+	books.ImportSkipped:   "skipped",
+	books.ImportAttached:  "attached",
+}
+
 type success struct {
 	Success string `json:"success"`
 }
@@ -52,6 +124,7 @@ func bookToModel(book books.Book) Book {
 			Filename:         file.CurrentFilename,
 			Mtime:            file.FileMtime,
 			Size:             file.FileSize,
+			Source:           file.Source,
 		}
 		if newFile.Tags == nil {
 			newFile.Tags = make([]string, 0)
@@ -59,15 +132,35 @@ func bookToModel(book books.Book) Book {
 		modelFiles = append(modelFiles, newFile)
 	}
 	newBook := Book{
-		ID:      book.ID,
-		Authors: book.Authors,
-		Title:   book.Title,
-		Series:  book.Series,
-		Files:   modelFiles,
+		ID:          book.ID,
+		Authors:     book.Authors,
+		Title:       book.Title,
+		Series:      book.Series,
+		SeriesIndex: book.SeriesIndex,
+		Identifiers: book.Identifiers,
+		Genres:      book.Genres,
+		Files:       modelFiles,
+	}
+	if book.Highlight != nil {
+		h := book.Highlight
+		newBook.Highlight = &Highlight{
+			Title:   string(highlightHTML(h.Title)),
+			Series:  string(highlightHTML(h.Series)),
+			Snippet: string(highlightHTML(h.Snippet)),
+		}
+		for _, a := range h.Authors {
+			newBook.Highlight.Authors = append(newBook.Highlight.Authors, string(highlightHTML(a)))
+		}
 	}
 	if newBook.Authors == nil {
 		newBook.Authors = make([]string, 0)
 	}
+	if newBook.Identifiers == nil {
+		newBook.Identifiers = make(map[string]string)
+	}
+	if newBook.Genres == nil {
+		newBook.Genres = make([]string, 0)
+	}
 	return newBook
 }
 
@@ -83,15 +176,19 @@ func modelToBook(modelBook Book) books.Book {
 			CurrentFilename:  file.Filename,
 			FileMtime:        file.Mtime,
 			FileSize:         file.Size,
+			Source:           file.Source,
 		}
 		files = append(files, newFile)
 	}
 	newBook := books.Book{
-		ID:      modelBook.ID,
-		Authors: modelBook.Authors,
-		Title:   modelBook.Title,
-		Series:  modelBook.Series,
-		Files:   files,
+		ID:          modelBook.ID,
+		Authors:     modelBook.Authors,
+		Title:       modelBook.Title,
+		Series:      modelBook.Series,
+		SeriesIndex: modelBook.SeriesIndex,
+		Identifiers: modelBook.Identifiers,
+		Genres:      modelBook.Genres,
+		Files:       files,
 	}
 	return newBook
 }
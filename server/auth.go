@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tspivey/books"
+)
+
+// errUnauthorized is returned when a request has no user name and password, or they're wrong.
+var errUnauthorized = errors.New("unauthorized")
+
+type contextKey int
+
+const (
+	// userKey is the context key of the user making a request.
+	userKey contextKey = iota
+	// apiKeyKey is the context key of the API key a request was made with.
+	apiKeyKey
+	// sessionContextKey is the context key of the login session a request was made in.
+	sessionContextKey
+)
+
+// anonymousUser is the user of every request when authentication is disabled.
+// Its name is empty, so it can be told apart from a user who logged in.
+var anonymousUser = books.User{Role: books.RoleAdmin}
+
+// withUser returns a copy of r, whose context holds the user making it.
+func withUser(r *http.Request, user books.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey, user))
+}
+
+// userFromContext returns the user making the request whose context is ctx.
+// If there isn't one, ok is false.
+func userFromContext(ctx context.Context) (user books.User, ok bool) {
+	user, ok = ctx.Value(userKey).(books.User)
+	return user, ok
+}
+
+// authEnabled returns whether users must log in, and whether their passwords are checked against the library's users.
+// If the library has no users, but there's an htpasswd file, it's used instead.
+// If there's neither, authentication is disabled.
+func (srv *Server) authEnabled(ctx context.Context) (enabled, hasUsers bool, err error) {
+	hasUsers, err = srv.lib.HasUsersContext(ctx)
+	if err != nil {
+		return false, false, err
+	}
+	if hasUsers {
+		return true, true, nil
+	}
+	_, err = os.Stat(srv.htpasswdFile)
+	return err == nil, false, nil
+}
+
+// checkPassword returns the user with the given name and password.
+// Users in the htpasswd file are all admins.
+// If there's no such user, or the password is wrong, errUnauthorized is returned.
+func (srv *Server) checkPassword(ctx context.Context, hasUsers bool, name, password string) (books.User, error) {
+	if hasUsers {
+		user, err := srv.lib.AuthenticateUserContext(ctx, name, password)
+		if err == books.ErrBadLogin {
+			return user, errUnauthorized
+		}
+		return user, err
+	}
+	// go-http-auth only checks passwords given in requests.
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		return books.User{}, err
+	}
+	req.SetBasicAuth(name, password)
+	if srv.htpasswd.CheckAuth(req) == "" {
+		return books.User{}, errUnauthorized
+	}
+	return books.User{Name: name, Role: books.RoleAdmin}, nil
+}
+
+// findUser returns the user with the given name, as checkPassword would, without checking a password.
+// If there's no such user, errUnauthorized is returned.
+func (srv *Server) findUser(ctx context.Context, hasUsers bool, name string) (books.User, error) {
+	if hasUsers {
+		user, err := srv.lib.GetUserContext(ctx, name)
+		if err == books.ErrUserNotFound {
+			return user, errUnauthorized
+		}
+		return user, err
+	}
+	if srv.htpasswd.Secrets(name, srv.realm) == "" {
+		return books.User{}, errUnauthorized
+	}
+	return books.User{Name: name, Role: books.RoleAdmin}, nil
+}
+
+// basicAuthUser returns the user logging in to the request with basic authentication.
+// If authentication is disabled, anonymousUser is returned.
+func (srv *Server) basicAuthUser(r *http.Request) (books.User, error) {
+	enabled, hasUsers, err := srv.authEnabled(r.Context())
+	if err != nil {
+		return books.User{}, err
+	}
+	if !enabled {
+		return anonymousUser, nil
+	}
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return books.User{}, errUnauthorized
+	}
+	return srv.checkPassword(r.Context(), hasUsers, name, password)
+}
+
+// authMiddleware makes users log in to every page outside the API, which has its own in apiKeyMiddleware,
+// and passes the user on to the page's handler.
+// Users log in with a session from the login page, or with basic authentication, for scripts and feed readers.
+// Browsers which haven't logged in are sent to the login page, and other clients are asked for basic authentication.
+// Forms posted in a session must include its CSRF token.
+func (srv *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !sameOrigin(r) {
+			log.Printf("Denied %s %s from another site, %s", r.Method, r.URL.Path, r.Header.Get("Origin"))
+			w.WriteHeader(http.StatusForbidden)
+			srv.render("error_page", w, r, errorPage{"Forbidden", "That form was sent from another site."})
+			return
+		}
+		enabled, hasUsers, err := srv.authEnabled(r.Context())
+		if err != nil {
+			log.Printf("Error authenticating user: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			next.ServeHTTP(w, withUser(r, anonymousUser))
+			return
+		}
+		if r.URL.Path == "/login" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var user books.User
+		if s, ok := srv.sessionFromCookie(r); ok {
+			user, err = srv.findUser(r.Context(), hasUsers, s.User)
+			if err == nil && !safeMethod(r.Method) && !validCSRFToken(r, s) {
+				log.Printf("Denied %s %s to %s, without a valid CSRF token", r.Method, r.URL.Path, user.Name)
+				w.WriteHeader(http.StatusForbidden)
+				srv.render("error_page", w, r, errorPage{"Form expired", "That form has expired. Go back, reload the page, and try again."})
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), sessionContextKey, s))
+		} else if name, password, ok := r.BasicAuth(); ok {
+			user, err = srv.checkPassword(r.Context(), hasUsers, name, password)
+		} else {
+			err = errUnauthorized
+		}
+		if err == errUnauthorized {
+			if r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+srv.realm+`"`)
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error authenticating user: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, withUser(r, user))
+	})
+}
+
+// safeMethod returns true if requests with method don't change anything, so don't need CSRF protection.
+func safeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
+
+// sameOrigin returns false if a request which may change something was made by a page on another site.
+// Browsers send the page's origin with such requests, but scripts usually don't, so requests without it are allowed.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if safeMethod(r.Method) || origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// apiKeyMiddleware allows API calls with an API key created with books auth apikey, as the key's user.
+// legacyKey, from BOOKS_API_KEY, is also allowed, with every scope, as the user named by apiUser, or as an admin if it's empty.
+// Calls made with basic authentication are allowed as the user who logged in, as long as authentication isn't disabled.
+func (srv *Server) apiKeyMiddleware(legacyKey, apiUser string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user books.User
+		var err error
+		if given := r.Header.Get("x-API-key"); given != "" && legacyKey != "" && subtle.ConstantTimeCompare([]byte(given), []byte(legacyKey)) == 1 {
+			if apiUser == "" {
+				user = books.User{Name: "API key", Role: books.RoleAdmin}
+			} else if user, err = srv.lib.GetUserContext(r.Context(), apiUser); err == books.ErrUserNotFound {
+				log.Printf("The API key's user %s doesn't exist", apiUser)
+				err = errUnauthorized
+			}
+		} else if given != "" {
+			var apiKey books.APIKey
+			apiKey, user, err = srv.lib.CheckAPIKeyContext(r.Context(), given)
+			switch err {
+			case nil:
+				r = r.WithContext(context.WithValue(r.Context(), apiKeyKey, apiKey))
+			case books.ErrAPIKeyNotFound:
+				log.Printf("Denied %s %s to an unknown API key", r.Method, r.URL.Path)
+				err = errUnauthorized
+			case books.ErrAPIKeyExpired:
+				log.Printf("Denied %s %s to API key %s of %s, which has expired", r.Method, r.URL.Path, apiKey.Name, apiKey.User)
+				err = errUnauthorized
+			}
+		} else if user, err = srv.basicAuthUser(r); err == nil && user == anonymousUser {
+			err = errUnauthorized
+		}
+		if err == errUnauthorized {
+			w.WriteHeader(http.StatusForbidden)
+			writeJSON(w, apiError{"forbidden"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error authenticating API call: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			writeJSON(w, apiError{"internal server error"})
+			return
+		}
+		next.ServeHTTP(w, withUser(r, user))
+	})
+}
+
+// requireScope returns a handler which calls h if the API call is allowed scope.
+// Calls made with an API key need a key with the scope, and all calls need a user with the scope's role.
+func (srv *Server) requireScope(scope books.Scope, h http.HandlerFunc) http.HandlerFunc {
+	return srv.requireRole(scope.Role(), func(w http.ResponseWriter, r *http.Request) {
+		if apiKey, ok := r.Context().Value(apiKeyKey).(books.APIKey); ok && !apiKey.HasScope(scope) {
+			log.Printf("Denied %s %s to API key %s of %s, which doesn't have the %s scope", r.Method, r.URL.Path, apiKey.Name, apiKey.User, scope)
+			w.WriteHeader(http.StatusForbidden)
+			writeJSON(w, apiError{"forbidden"})
+			return
+		}
+		h(w, r)
+	})
+}
+
+// requireRole returns a handler which calls h if the user making the request has at least the given role.
+func (srv *Server) requireRole(role books.Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := userFromContext(r.Context()); !ok || user.Role < role {
+			if apiKey, ok := r.Context().Value(apiKeyKey).(books.APIKey); ok {
+				log.Printf("Denied %s %s to API key %s of %s (%s), which requires %s", r.Method, r.URL.Path, apiKey.Name, user.Name, user.Role, role)
+			} else {
+				log.Printf("Denied %s %s to %s (%s), which requires %s", r.Method, r.URL.Path, user.Name, user.Role, role)
+			}
+			w.WriteHeader(http.StatusForbidden)
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSON(w, apiError{"forbidden"})
+			} else {
+				srv.render("error_page", w, r, errorPage{"Forbidden", "You aren't allowed to do that."})
+			}
+			return
+		}
+		h(w, r)
+	}
+}
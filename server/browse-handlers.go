@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+type browsePage struct {
+	Title   string
+	Path    string
+	Letters []string
+	Letter  string // The letter being shown, or empty for all of them
+	Entries []browseEntry
+}
+
+type browseEntry struct {
+	Name  string
+	Link  string
+	Books int
+}
+
+func (srv *Server) authorsHandler(w http.ResponseWriter, r *http.Request) {
+	srv.renderBrowse(w, r, "Authors", "author", srv.lib.GetAuthorsContext)
+}
+
+func (srv *Server) seriesHandler(w http.ResponseWriter, r *http.Request) {
+	srv.renderBrowse(w, r, "Series", "series", srv.lib.GetSeriesContext)
+}
+
+func (srv *Server) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	srv.renderBrowse(w, r, "Tags", "tags", srv.lib.GetTagsContext)
+}
+
+func (srv *Server) genresHandler(w http.ResponseWriter, r *http.Request) {
+	srv.renderBrowse(w, r, "Genres", "genres", srv.lib.GetGenresContext)
+}
+
+// renderBrowse renders an index of the names returned by get, with links to the books with each name in field.
+// If the letter parameter is given, only names starting with it are shown.
+func (srv *Server) renderBrowse(w http.ResponseWriter, r *http.Request, title, field string, get func(context.Context) ([]books.NameCount, error)) {
+	names, err := get(r.Context())
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting %s: %s", field, err)
+		srv.render("error_page", w, r, errorPage{"Error getting " + field, "An error occurred while getting the list."})
+		return
+	}
+	page := browsePage{Title: title, Path: r.URL.EscapedPath(), Letter: r.URL.Query().Get("letter")}
+	for _, nc := range names {
+		letter := indexLetter(nc.SortName)
+		if len(page.Letters) == 0 || page.Letters[len(page.Letters)-1] != letter {
+			page.Letters = append(page.Letters, letter)
+		}
+		if page.Letter != "" && page.Letter != letter {
+			continue
+		}
+		page.Entries = append(page.Entries, browseEntry{Name: nc.Name, Link: browseLink(field, nc.Name), Books: nc.Books})
+	}
+	srv.render("browse", w, r, page)
+}
+
+// indexLetter returns the letter name is listed under in an index: its first letter without diacritics, or # if it doesn't start with a letter.
+func indexLetter(name string) string {
+	for _, r := range books.FoldKey(name) {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		break
+	}
+	return "#"
+}
+
+func (srv *Server) authorBooksHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	opts, ok := srv.listOptions(w, r)
+	if !ok {
+		return
+	}
+	opts.Authors = append(opts.Authors, name)
+	srv.renderResults(w, r, "", opts, "Books by "+name)
+}
+
+func (srv *Server) seriesBooksHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	series, err := srv.lib.GetSeriesByNameContext(r.Context(), name)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err == books.ErrSeriesNotFound {
+		srv.render("error_page", w, r, errorPage{"Series not found", "There are no books in that series."})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting series %s: %s", name, err)
+		srv.render("error_page", w, r, errorPage{"Error getting series", "An error occurred while getting the books in that series."})
+		return
+	}
+	srv.render("series", w, r, series)
+}
+
+func (srv *Server) tagBooksHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	opts, ok := srv.listOptions(w, r)
+	if !ok {
+		return
+	}
+	opts.Tags = append(opts.Tags, name)
+	srv.renderResults(w, r, "", opts, "Books tagged "+name)
+}
+
+func (srv *Server) genreBooksHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	opts, ok := srv.listOptions(w, r)
+	if !ok {
+		return
+	}
+	opts.Genres = append(opts.Genres, name)
+	srv.renderResults(w, r, "", opts, "Books in "+name)
+}
+
+func (srv *Server) recentHandler(w http.ResponseWriter, r *http.Request) {
+	opts, ok := srv.listOptions(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("sort") == "" {
+		opts.Sort = books.SortAdded
+		opts.Reverse = true
+	}
+	srv.renderResults(w, r, "", opts, "Recently added")
+}
+
+// listOptions reads any filters and sort order given to a list of books.
+// If they're invalid, it renders an error page and returns false.
+func (srv *Server) listOptions(w http.ResponseWriter, r *http.Request) (books.SearchOptions, bool) {
+	opts, err := books.ParseSearchParams(r.URL.Query())
+	if err != nil {
+		srv.render("error_page", w, r, errorPage{"Invalid list", err.Error() + "."})
+		return opts, false
+	}
+	return opts, true
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/exec"
@@ -25,15 +26,19 @@ type calibreBookConverter struct {
 	fileCh        chan books.BookFile
 	booksRoot     string
 	cacheDir      string
-	closed        bool
+	// ctx is canceled by Close, to stop the workers and any conversions in progress.
+	// fileCh is never closed, so handlers still running after Close can't send on a closed channel.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 var errBookNotReady = errors.New("book not ready")
 var errQueueFull = errors.New("queue full")
+var errConverterClosed = errors.New("book converter closed")
 
 func (c *calibreBookConverter) Convert(bf books.BookFile) (string, error) {
-	if c.closed {
-		return "", errors.New("book converter closed")
+	if c.ctx.Err() != nil {
+		return "", errConverterClosed
 	}
 	epubFn := path.Join(c.cacheDir, bf.Hash+".epub")
 	_, err := os.Stat(epubFn)
@@ -61,53 +66,76 @@ func (c *calibreBookConverter) Convert(bf books.BookFile) (string, error) {
 	select {
 	case c.fileCh <- bf:
 		return "", errBookNotReady
+	case <-c.ctx.Done():
+		return "", errConverterClosed
 	default:
 		return "", errQueueFull
 	}
 }
 
+// Close stops the workers, killing any conversions in progress.
+// It's safe to call while other goroutines are calling Convert.
 func (c *calibreBookConverter) Close() {
-	c.closed = true
-	close(c.fileCh)
+	c.cancel()
 }
 
-// work listens on c.fileCh for files to convert to epub.
+// work listens on c.fileCh for files to convert to epub, until the converter is closed.
 func (c *calibreBookConverter) work() {
-	for bookFile := range c.fileCh {
-		c.convertingMtx.Lock()
-		c.converting[bookFile.ID] = errBookNotReady
-		c.convertingMtx.Unlock()
-
-		filename := path.Join(c.booksRoot, bookFile.HashPath())
-		tmpFile := path.Join(c.cacheDir, bookFile.Hash+"."+bookFile.Extension)
-		newFile := path.Join(c.cacheDir, bookFile.Hash+".epub")
-		err := os.Symlink(filename, tmpFile)
-		if err == nil {
-			cmd := exec.Command("ebook-convert", tmpFile, newFile)
-			err = cmd.Run()
-			if err := os.Remove(tmpFile); err != nil {
-				log.Printf("Unable to remove %s: %v", tmpFile, err)
-			}
+	for {
+		var bookFile books.BookFile
+		select {
+		case bookFile = <-c.fileCh:
+		case <-c.ctx.Done():
+			return
 		}
+		c.convert(bookFile)
+	}
+}
 
-		c.convertingMtx.Lock()
+// convert converts one file to epub, and records whether it succeeded in c.converting.
+func (c *calibreBookConverter) convert(bookFile books.BookFile) {
+	c.convertingMtx.Lock()
+	c.converting[bookFile.ID] = errBookNotReady
+	c.convertingMtx.Unlock()
+
+	filename := path.Join(c.booksRoot, bookFile.HashPath())
+	tmpFile := path.Join(c.cacheDir, bookFile.Hash+"."+bookFile.Extension)
+	newFile := path.Join(c.cacheDir, bookFile.Hash+".epub")
+	err := os.Symlink(filename, tmpFile)
+	if err == nil {
+		cmd := exec.CommandContext(c.ctx, "ebook-convert", tmpFile, newFile)
+		err = cmd.Run()
+		if err := os.Remove(tmpFile); err != nil {
+			log.Printf("Unable to remove %s: %v", tmpFile, err)
+		}
+		// Don't leave a partly converted file behind to be served as if it were complete.
 		if err != nil {
-			log.Printf("%v", err)
-			c.converting[bookFile.ID] = err
-		} else {
-			delete(c.converting, bookFile.ID)
+			if err := os.Remove(newFile); err != nil && !os.IsNotExist(err) {
+				log.Printf("Unable to remove %s: %v", newFile, err)
+			}
 		}
-		c.convertingMtx.Unlock()
 	}
+
+	c.convertingMtx.Lock()
+	if err != nil {
+		log.Printf("%v", err)
+		c.converting[bookFile.ID] = err
+	} else {
+		delete(c.converting, bookFile.ID)
+	}
+	c.convertingMtx.Unlock()
 }
 
 // NewCalibreBookConverter creates a new BookConverter which uses calibre.
 func NewCalibreBookConverter(booksRoot, cacheDir string, numWorkers int) BookConverter {
+	ctx, cancel := context.WithCancel(context.Background())
 	converter := &calibreBookConverter{
 		fileCh:     make(chan books.BookFile),
 		converting: make(map[int64]error),
 		booksRoot:  booksRoot,
 		cacheDir:   cacheDir,
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 
 	for i := 0; i < numWorkers; i++ {
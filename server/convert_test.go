@@ -0,0 +1,40 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/tspivey/books"
+)
+
+func TestConverterCloseWhileConverting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "books-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := NewCalibreBookConverter(dir, dir, 2)
+
+	// Handlers may still be converting books when the server shuts down and closes the converter.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				bf := books.BookFile{ID: int64(i*1000 + j), Hash: "0123456789abcdef", Extension: "txt"}
+				if _, err := c.Convert(bf); err == errConverterClosed {
+					return
+				}
+			}
+		}(i)
+	}
+	c.Close()
+	wg.Wait()
+
+	if _, err := c.Convert(books.BookFile{ID: 1, Hash: "0123456789abcdef"}); err != errConverterClosed {
+		t.Errorf("Convert after Close returned %v, want %v", err, errConverterClosed)
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tspivey/books"
+)
+
+// feedContentType is the content type of an OPDS acquisition feed, which is also an Atom feed.
+const feedContentType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+
+// acquisitionRel is the link relation OPDS clients download books from.
+const acquisitionRel = "http://opds-spec.org/acquisition"
+
+// ebookTypes are the MIME types of ebook formats the system's MIME database may not know.
+var ebookTypes = map[string]string{
+	"epub": "application/epub+zip",
+	"mobi": "application/x-mobipocket-ebook",
+	"azw3": "application/vnd.amazon.ebook",
+	"fb2":  "application/x-fictionbook+xml",
+	"cbz":  "application/vnd.comicbook+zip",
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Authors    []atomAuthor   `xml:"author"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Categories []atomCategory `xml:"category"`
+	Summary    string         `xml:"summary,omitempty"`
+	Links      []atomLink     `xml:"link"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+// renderFeed writes a page of the books found by a search as an OPDS acquisition feed, with a link to the next page if there is one.
+// feedPath is the feed's path, which identifies it, and htmlPath is the path of the same results as a web page.
+// The page parameter selects the page, starting from 1.
+// Links are absolute, since feed readers may not resolve them against the feed's URL.
+func (srv *Server) renderFeed(w http.ResponseWriter, r *http.Request, terms string, opts books.SearchOptions, title, feedPath, htmlPath string) {
+	pageNumber, limit := 1, srv.itemsPerPage
+	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && page >= 1 {
+		pageNumber = page
+	}
+	bookList, total, err := srv.lib.SearchPagedContext(r.Context(), terms, opts, (pageNumber-1)*limit, limit)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting feed for %s: %s", title, err)
+		http.Error(w, "Error getting feed", http.StatusInternalServerError)
+		return
+	}
+
+	base := baseURL(r)
+	pageLink := func(page int) string {
+		u := base + feedPath
+		if page > 1 {
+			u += "?page=" + strconv.Itoa(page)
+		}
+		return u
+	}
+	feed := atomFeed{
+		ID:    pageLink(1),
+		Title: title,
+		Links: []atomLink{
+			{Rel: "self", Href: pageLink(pageNumber), Type: feedContentType},
+			{Rel: "alternate", Href: base + htmlPath, Type: "text/html"},
+		},
+	}
+	if pageNumber*limit < total {
+		feed.Links = append(feed.Links, atomLink{Rel: "next", Href: pageLink(pageNumber + 1), Type: feedContentType})
+	}
+	var updated time.Time
+	for _, b := range bookList {
+		if b.Updated.After(updated) {
+			updated = b.Updated
+		}
+		feed.Entries = append(feed.Entries, bookEntry(base, b))
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	w.Header().Set("Content-Type", feedContentType)
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Printf("Error writing feed for %s: %s", title, err)
+	}
+}
+
+// bookEntry returns a feed entry for a book, with a link to download each of its files.
+func bookEntry(base string, b books.Book) atomEntry {
+	link := fmt.Sprintf("%s/book/%d", base, b.ID)
+	e := atomEntry{
+		ID:        link,
+		Title:     b.Title,
+		Updated:   b.Updated.UTC().Format(time.RFC3339),
+		Published: b.Added.UTC().Format(time.RFC3339),
+		Links:     []atomLink{{Rel: "alternate", Href: link, Type: "text/html"}},
+	}
+	for _, a := range b.Authors {
+		e.Authors = append(e.Authors, atomAuthor{Name: a, URI: base + browseLink("author", a)})
+	}
+	for _, g := range b.Genres {
+		e.Categories = append(e.Categories, atomCategory{Term: g, Label: g})
+	}
+	if b.Series != "" {
+		e.Summary = "Series: " + b.Series
+		if b.SeriesIndex != 0 {
+			e.Summary += " #" + books.FormatSeriesIndex(b.SeriesIndex)
+		}
+	}
+	for _, f := range b.Files {
+		e.Links = append(e.Links, atomLink{
+			Rel:   acquisitionRel,
+			Href:  fmt.Sprintf("%s/download/%d/%s", base, f.ID, url.PathEscape(path.Base(f.CurrentFilename))),
+			Type:  ebookType(f.Extension),
+			Title: f.Extension,
+		})
+	}
+	return e
+}
+
+// ebookType returns the MIME type of files with extension ext.
+func ebookType(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if t, ok := ebookTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension("." + ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// baseURL returns the scheme and host the request was made to, such as http://localhost:8000.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
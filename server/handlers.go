@@ -1,9 +1,12 @@
 package server
 
 import (
+	"html/template"
 	"log"
 	"math"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
@@ -14,7 +17,7 @@ import (
 )
 
 func (srv *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
-	srv.render("index", w, nil)
+	srv.render("index", w, r, results{})
 }
 
 func (srv *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
@@ -24,13 +27,13 @@ func (srv *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	files, err := srv.lib.GetFilesByID([]int64{int64(id)})
+	files, err := srv.lib.GetFilesByIDContext(r.Context(), []int64{int64(id)})
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 	if len(files) == 0 {
-		srv.render("error_page", w, errorPage{"File not found", "That file doesn't exist in the library."})
+		srv.render("error_page", w, r, errorPage{"File not found", "That file doesn't exist in the library."})
 		return
 	}
 	file := files[0]
@@ -39,7 +42,7 @@ func (srv *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 	base := path.Base(fn)
 	if _, err := os.Stat(fn); os.IsNotExist(err) {
 		log.Printf("File %d is in the library but the file is missing: %s", file.ID, fn)
-		srv.render("error_page", w, errorPage{"Cannot download file", "It looks like that file is in the library, but the file is missing."})
+		srv.render("error_page", w, r, errorPage{"Cannot download file", "It looks like that file is in the library, but the file is missing."})
 		return
 	}
 
@@ -47,15 +50,15 @@ func (srv *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 		epubFn, err := srv.converter.Convert(file)
 		if err == errBookNotReady {
 			w.Header().Set("Refresh", "15")
-			srv.render("converting", w, file)
+			srv.render("converting", w, r, file)
 			return
 		}
 		if err == errQueueFull {
-			srv.render("error_page", w, errorPage{"Conversion error", "The conversion queue is full. Try again later."})
+			srv.render("error_page", w, r, errorPage{"Conversion error", "The conversion queue is full. Try again later."})
 			return
 		}
 		if err != nil {
-			srv.render("error_page", w, errorPage{"Conversion error", "That file couldn't be converted."})
+			srv.render("error_page", w, r, errorPage{"Conversion error", "That file couldn't be converted."})
 			return
 		}
 
@@ -82,28 +85,45 @@ func (srv *Server) bookDetailsHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	books, err := srv.lib.GetBooksByID([]int64{int64(id)})
+	books, err := srv.lib.GetBooksByIDContext(r.Context(), []int64{int64(id)})
 	if err != nil {
 		log.Printf("Error getting books by ID: %s", err)
 		http.NotFound(w, r)
 		return
 	}
 	if len(books) == 0 {
-		srv.render("error_page", w, errorPage{"Book not found", "That book doesn't exist in the library."})
+		srv.render("error_page", w, r, errorPage{"Book not found", "That book doesn't exist in the library."})
 		return
 	}
 	book := books[0]
 
-	srv.render("book_details", w, book)
+	srv.render("book_details", w, r, book)
 }
 
 type results struct {
 	Books      []books.Book
 	PageNumber int
+	Pages      int
+	Total      int // The number of books found on all pages
 	Prev       int
 	Next       int
 	PageLinks  []int
 	Query      string
+	Heading    string // Shown instead of the query, for lists of books which weren't searched for
+	Options    books.SearchOptions
+	Feed       string // The URL of a feed of the results, if they have one
+	path       string
+	params     url.Values
+}
+
+// PageLink returns the URL of another page of the same results.
+func (res results) PageLink(page int) template.URL {
+	params := url.Values{}
+	for k, v := range res.params {
+		params[k] = v
+	}
+	params.Set("page", strconv.Itoa(page))
+	return template.URL(res.path + "?" + params.Encode())
 }
 
 type errorPage struct {
@@ -112,13 +132,31 @@ type errorPage struct {
 }
 
 func (srv *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
-	pageNumber, offset, limit := 1, 0, srv.itemsPerPage
-	maxPageLinks := 10
 	val, ok := r.URL.Query()["query"]
 	if !ok {
 		http.Redirect(w, r, "/", 301)
 		return
 	}
+	opts, err := books.ParseSearchParams(r.URL.Query())
+	if err != nil {
+		srv.render("error_page", w, r, errorPage{"Invalid search", err.Error() + "."})
+		return
+	}
+	srv.renderResults(w, r, val[0], opts, "")
+}
+
+// renderResults renders the page of books matching terms and opts given by the request's page parameter.
+// heading is shown instead of the search terms, if it isn't empty.
+func (srv *Server) renderResults(w http.ResponseWriter, r *http.Request, terms string, opts books.SearchOptions, heading string) {
+	srv.renderResultsPage(w, r, results{Query: terms, Options: opts, Heading: heading})
+}
+
+// renderResultsPage renders a page of the books found by searching for res.Query with res.Options,
+// filling in the rest of res.
+func (srv *Server) renderResultsPage(w http.ResponseWriter, r *http.Request, res results) {
+	terms, opts := res.Query, res.Options
+	pageNumber, offset, limit := 1, 0, srv.itemsPerPage
+	maxPageLinks := 10
 	if pageStrs, ok := r.URL.Query()["page"]; ok {
 		if page, err := strconv.Atoi(pageStrs[0]); err == nil && page >= 1 {
 			pageNumber = page
@@ -126,41 +164,131 @@ func (srv *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	books, moreResults, err := srv.lib.SearchPaged(val[0], offset, limit, limit*(maxPageLinks-1))
+	bookList, total, err := srv.lib.SearchPagedContext(r.Context(), terms, opts, offset, limit)
+	if r.Context().Err() != nil {
+		// The client went away, so there's no one to show results or errors to.
+		return
+	}
+	if qe, ok := err.(*books.QueryError); ok {
+		srv.render("error_page", w, r, errorPage{"Invalid search", "Your search couldn't be understood: " + qe.Error() + "."})
+		return
+	}
 	if err != nil {
-		log.Printf("Error searching for %s: %s", val[0], err)
-		srv.render("error_page", w, errorPage{"Error while searching", "An error occurred while searching."})
+		log.Printf("Error searching for %s: %s", terms, err)
+		srv.render("error_page", w, r, errorPage{"Error while searching", "An error occurred while searching."})
 		return
 	}
 
-	morePages := int(math.Ceil(float64(moreResults) / float64(limit)))
+	pages := int(math.Ceil(float64(total) / float64(limit)))
 	firstPageLink := pageNumber - int(math.Ceil(float64(maxPageLinks)/2)) + 1
+	if firstPageLink > pages-maxPageLinks+1 {
+		firstPageLink = pages - maxPageLinks + 1
+	}
 	if firstPageLink < 1 {
 		firstPageLink = 1
 	}
-	lastPageLink := maxPageLinks/2 + pageNumber
-	if lastPageLink < maxPageLinks {
-		lastPageLink = maxPageLinks
-	}
-	if lastPageLink > pageNumber+morePages {
-		lastPageLink = pageNumber + morePages
+	lastPageLink := firstPageLink + maxPageLinks - 1
+	if lastPageLink > pages {
+		lastPageLink = pages
 	}
 	pageLinks := make([]int, 0)
 	for i := firstPageLink; i <= lastPageLink; i++ {
 		pageLinks = append(pageLinks, i)
 	}
 	nextPage := 0
-	if morePages > 0 {
+	if pageNumber < pages {
 		nextPage = pageNumber + 1
 	}
 
-	res := results{
-		Books:      books,
-		PageNumber: pageNumber,
-		Prev:       pageNumber - 1,
-		Next:       nextPage,
-		PageLinks:  pageLinks,
-		Query:      val[0],
+	res.Books = bookList
+	res.PageNumber = pageNumber
+	res.Pages = pages
+	res.Total = total
+	res.Prev = pageNumber - 1
+	res.Next = nextPage
+	res.PageLinks = pageLinks
+	res.path = r.URL.EscapedPath()
+	res.params = r.URL.Query()
+	srv.render("results", w, r, res)
+}
+
+type unsortedPage struct {
+	Files []books.UnsortedFile
+	Error string
+}
+
+func (srv *Server) unsortedHandler(w http.ResponseWriter, r *http.Request) {
+	files, err := srv.lib.GetUnsortedFiles()
+	if err != nil {
+		log.Printf("Error getting unsorted files: %s", err)
+		srv.render("error_page", w, r, errorPage{"Error getting unsorted files", "An error occurred while getting the unsorted files."})
+		return
+	}
+	srv.render("unsorted", w, r, unsortedPage{Files: files})
+}
+
+func (srv *Server) assignUnsortedHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	book := books.Book{
+		Title:  strings.TrimSpace(r.PostFormValue("title")),
+		Series: strings.TrimSpace(r.PostFormValue("series")),
+	}
+	for _, author := range strings.Split(r.PostFormValue("authors"), " & ") {
+		if author = strings.TrimSpace(author); author != "" {
+			book.Authors = append(book.Authors, author)
+		}
+	}
+	if book.Title == "" || len(book.Authors) == 0 {
+		files, err := srv.lib.GetUnsortedFiles()
+		if err != nil {
+			log.Printf("Error getting unsorted files: %s", err)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		srv.render("unsorted", w, r, unsortedPage{Files: files, Error: "A title and at least one author are required."})
+		return
+	}
+
+	result, err := srv.lib.AssignUnsortedFileContext(r.Context(), id, book, srv.outputTemplate, srv.duplicatePolicy)
+	if err == books.ErrUnsortedFileNotFound {
+		srv.render("error_page", w, r, errorPage{"File not found", "That file isn't in the unsorted staging area."})
+		return
+	} else if err != nil {
+		log.Printf("Error assigning unsorted file %d: %s", id, err)
+		srv.render("error_page", w, r, errorPage{"Error importing file", "An error occurred while importing the file."})
+		return
+	}
+	http.Redirect(w, r, "/book/"+strconv.FormatInt(result.BookID, 10), http.StatusSeeOther)
+}
+
+func (srv *Server) downloadUnsortedHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	uf, err := srv.lib.GetUnsortedFileByID(id)
+	if err == books.ErrUnsortedFileNotFound {
+		srv.render("error_page", w, r, errorPage{"File not found", "That file isn't in the unsorted staging area."})
+		return
+	} else if err != nil {
+		log.Printf("Error getting unsorted file %d: %s", id, err)
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Disposition", attachmentDisposition(path.Base(uf.File.OriginalFilename)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, path.Join(srv.booksRoot, uf.StagedPath()))
+}
+
+// attachmentDisposition returns a Content-Disposition header which downloads a file named name.
+// Names with quotes or non-ASCII characters, such as those of unsorted files, are escaped or encoded.
+func attachmentDisposition(name string) string {
+	if v := mime.FormatMediaType("attachment", map[string]string{"filename": name}); v != "" {
+		return v
 	}
-	srv.render("results", w, res)
+	return "attachment"
 }
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"mime"
+	"testing"
+)
+
+func TestAttachmentDisposition(t *testing.T) {
+	for _, name := range []string{
+		"book.epub",
+		`The "Best" Book.txt`,
+		"Les Misérables.epub",
+		`back\slash; and semicolon.pdf`,
+	} {
+		v := attachmentDisposition(name)
+		disposition, params, err := mime.ParseMediaType(v)
+		if err != nil {
+			t.Errorf("attachmentDisposition(%q) = %q, which doesn't parse: %v", name, v, err)
+			continue
+		}
+		if disposition != "attachment" || params["filename"] != name {
+			t.Errorf("attachmentDisposition(%q) = %q, parsed as %s with filename %q", name, v, disposition, params["filename"])
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+func (srv *Server) savedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := srv.savedSearch(w, r)
+	if !ok {
+		return
+	}
+	srv.renderResultsPage(w, r, results{
+		Query:   s.Query,
+		Options: s.Options,
+		Heading: "Saved search: " + s.Name,
+		Feed:    "/saved/" + url.PathEscape(s.Name) + "/feed",
+	})
+}
+
+// savedFeedHandler serves the results of a saved search as an OPDS acquisition feed.
+func (srv *Server) savedFeedHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	s, err := srv.lib.GetSavedSearchContext(r.Context(), name)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err == books.ErrSavedSearchNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting saved search %s: %s", name, err)
+		http.Error(w, "Error getting saved search", http.StatusInternalServerError)
+		return
+	}
+	htmlPath := "/saved/" + url.PathEscape(s.Name)
+	feedPath := htmlPath + "/feed"
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		feedPath = "/api" + feedPath
+	}
+	srv.renderFeed(w, r, s.Query, s.Options, s.Name, feedPath, htmlPath)
+}
+
+// savedSearch gets the saved search named in the request.
+// If it can't, it renders an error page and returns false.
+func (srv *Server) savedSearch(w http.ResponseWriter, r *http.Request) (books.SavedSearch, bool) {
+	name := mux.Vars(r)["name"]
+	s, err := srv.lib.GetSavedSearchContext(r.Context(), name)
+	if r.Context().Err() != nil {
+		return s, false
+	}
+	if err == books.ErrSavedSearchNotFound {
+		srv.render("error_page", w, r, errorPage{"Saved search not found", "There is no saved search with that name."})
+		return s, false
+	}
+	if err != nil {
+		log.Printf("Error getting saved search %s: %s", name, err)
+		srv.render("error_page", w, r, errorPage{"Error getting saved search", "An error occurred while getting that saved search."})
+		return s, false
+	}
+	return s, true
+}
+
+// savedSearches returns the saved searches, for listing in the sidebar of every page rendered for a request with ctx.
+// Errors are logged, rather than stopping the page from rendering.
+func savedSearches(ctx context.Context, lib *books.Library) func() []books.SavedSearch {
+	return func() []books.SavedSearch {
+		searches, err := lib.GetSavedSearchesContext(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error getting saved searches: %s", err)
+		}
+		return searches
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"html"
 	"html/template"
@@ -10,8 +11,8 @@ import (
 	"os"
 	"path"
 	"strings"
-	"sync"
 	txtTemplate "text/template"
+	"time"
 
 	auth "github.com/abbot/go-http-auth"
 	"github.com/gorilla/mux"
@@ -21,25 +22,42 @@ import (
 
 // Server is the web server which handles searching for, downloading and converting books.
 type Server struct {
-	lib            *books.Library
-	converter      BookConverter
-	templates      *template.Template
-	hsrv           *http.Server
-	itemsPerPage   int
-	booksRoot      string
-	outputTemplate *txtTemplate.Template
+	lib             *books.Library
+	converter       BookConverter
+	templates       *template.Template
+	hsrv            *http.Server
+	itemsPerPage    int
+	booksRoot       string
+	outputTemplate  *txtTemplate.Template
+	duplicatePolicy books.DuplicatePolicy
+	htpasswdFile    string
+	htpasswd        *auth.BasicAuth
+	realm           string
+	sessionKey      []byte
+	sessionLifetime time.Duration
+	secureCookies   bool
 }
 
 // Config is the configuration of the server, used in New.
 type Config struct {
-	Lib            *books.Library
-	TemplatesDir   string
-	Converter      BookConverter
-	ItemsPerPage   int
-	Hsrv           *http.Server
-	HtpasswdFile   string
-	BooksRoot      string
-	OutputTemplate *txtTemplate.Template
+	Lib             *books.Library
+	TemplatesDir    string
+	Converter       BookConverter
+	ItemsPerPage    int
+	Hsrv            *http.Server
+	HtpasswdFile    string
+	BooksRoot       string
+	OutputTemplate  *txtTemplate.Template
+	DuplicatePolicy books.DuplicatePolicy
+	// Realm is the name browsers show when asking for basic authentication.
+	Realm string
+	// SessionKey signs the cookies holding login sessions. Changing it logs everyone out.
+	SessionKey []byte
+	// SessionLifetime is how long users stay logged in.
+	SessionLifetime time.Duration
+	// SecureCookies makes browsers only send session cookies over HTTPS.
+	// Set it when the server is behind a reverse proxy which terminates TLS, since requests then don't arrive with TLS.
+	SecureCookies bool
 }
 
 // New creates a new server.
@@ -52,43 +70,92 @@ func New(cfg *Config) *Server {
 		"pathEscape":    url.PathEscape,
 		"changeExt":     changeExt,
 		"ByteCountSI":   books.ByteCountSI,
+		"seriesIndex":   books.FormatSeriesIndex,
+		"highlight":     highlightHTML,
+		"highlighted":   func(s string) bool { return strings.Contains(s, books.HighlightStart) },
+		"join":          strings.Join,
+		"sortOrders":    func() []string { return books.SortOrderNames },
+		// currentUser, csrfToken and savedSearches are replaced for each request by render.
+		"currentUser":   func() string { return "" },
+		"csrfToken":     func() string { return "" },
+		"savedSearches": func() []books.SavedSearch { return nil },
 	}
 	srv := &Server{
-		lib:            cfg.Lib,
-		templates:      template.Must(template.New("template").Funcs(htmlFuncMap).ParseGlob(path.Join(cfg.TemplatesDir, "*.html"))),
-		converter:      cfg.Converter,
-		hsrv:           cfg.Hsrv,
-		itemsPerPage:   cfg.ItemsPerPage,
-		booksRoot:      cfg.BooksRoot,
-		outputTemplate: cfg.OutputTemplate,
+		lib:             cfg.Lib,
+		templates:       template.Must(template.New("template").Funcs(htmlFuncMap).ParseGlob(path.Join(cfg.TemplatesDir, "*.html"))),
+		converter:       cfg.Converter,
+		hsrv:            cfg.Hsrv,
+		itemsPerPage:    cfg.ItemsPerPage,
+		booksRoot:       cfg.BooksRoot,
+		outputTemplate:  cfg.OutputTemplate,
+		duplicatePolicy: cfg.DuplicatePolicy,
+		htpasswdFile:    cfg.HtpasswdFile,
+		htpasswd:        auth.NewBasicAuthenticator(cfg.Realm, auth.HtpasswdFileProvider(cfg.HtpasswdFile)),
+		realm:           cfg.Realm,
+		sessionKey:      cfg.SessionKey,
+		sessionLifetime: cfg.SessionLifetime,
+		secureCookies:   cfg.SecureCookies,
 	}
 
 	r := mux.NewRouter()
-	r.HandleFunc("/", srv.indexHandler)
-	r.HandleFunc("/book/{id:\\d+}", srv.bookDetailsHandler)
-	r.HandleFunc("/download/{id:\\d+}/{name:.+}", srv.downloadHandler)
-	r.HandleFunc("/download/{id:\\d+}", srv.downloadHandler)
-	r.HandleFunc("/search/", srv.searchHandler)
+	r.HandleFunc("/login", srv.loginHandler).Methods("GET", "POST")
+	r.HandleFunc("/logout", srv.logoutHandler).Methods("POST")
+	r.HandleFunc("/", srv.requireRole(books.RoleReader, srv.indexHandler))
+	r.HandleFunc("/book/{id:\\d+}", srv.requireRole(books.RoleReader, srv.bookDetailsHandler))
+	r.HandleFunc("/download/{id:\\d+}/{name:.+}", srv.requireRole(books.RoleReader, srv.downloadHandler))
+	r.HandleFunc("/download/{id:\\d+}", srv.requireRole(books.RoleReader, srv.downloadHandler))
+	r.HandleFunc("/search/", srv.requireRole(books.RoleReader, srv.searchHandler))
+	r.HandleFunc("/authors", srv.requireRole(books.RoleReader, srv.authorsHandler))
+	r.HandleFunc("/authors/{name:.+}", srv.requireRole(books.RoleReader, srv.authorBooksHandler))
+	r.HandleFunc("/series", srv.requireRole(books.RoleReader, srv.seriesHandler))
+	r.HandleFunc("/series/{name:.+}", srv.requireRole(books.RoleReader, srv.seriesBooksHandler))
+	r.HandleFunc("/tags", srv.requireRole(books.RoleReader, srv.tagsHandler))
+	r.HandleFunc("/tags/{name:.+}", srv.requireRole(books.RoleReader, srv.tagBooksHandler))
+	r.HandleFunc("/genres", srv.requireRole(books.RoleReader, srv.genresHandler))
+	r.HandleFunc("/genres/{name:.+}", srv.requireRole(books.RoleReader, srv.genreBooksHandler))
+	r.HandleFunc("/recent", srv.requireRole(books.RoleReader, srv.recentHandler))
+	r.HandleFunc("/shelves", srv.requireRole(books.RoleReader, srv.shelvesHandler))
+	r.HandleFunc("/shelves/{name}", srv.requireRole(books.RoleReader, srv.shelfHandler))
+	r.HandleFunc("/shelves/{name}/download", srv.requireRole(books.RoleReader, srv.downloadShelfHandler))
+	r.HandleFunc("/saved/{name}", srv.requireRole(books.RoleReader, srv.savedSearchHandler))
+	r.HandleFunc("/saved/{name}/feed", srv.requireRole(books.RoleReader, srv.savedFeedHandler))
+	r.HandleFunc("/unsorted", srv.requireRole(books.RoleEditor, srv.unsortedHandler)).Methods("GET")
+	r.HandleFunc(`/unsorted/{id:\d+}`, srv.requireRole(books.RoleEditor, srv.assignUnsortedHandler)).Methods("POST")
+	r.HandleFunc(`/unsorted/{id:\d+}/download`, srv.requireRole(books.RoleEditor, srv.downloadUnsortedHandler))
 	apiRouter := r.PathPrefix("/api/").Subrouter()
 	key := os.Getenv("BOOKS_API_KEY")
-	if key == "" {
-		log.Printf("Warning: BOOKS_API_KEY not set; API disabled")
+	apiUser := os.Getenv("BOOKS_API_USER")
+	if key != "" {
+		log.Printf("Warning: BOOKS_API_KEY is deprecated; create API keys with books auth apikey create instead")
+		if apiUser != "" {
+			log.Printf("Using BOOKS_API_KEY as user %s", apiUser)
+		}
 	}
-	apiLock := &sync.Mutex{}
 	apiRouter.Use(func(next http.Handler) http.Handler {
-		return apiKeyMiddleware(key, next, apiLock)
+		return srv.apiKeyMiddleware(key, apiUser, next)
 	})
-	apiRouter.HandleFunc(`/book/{id:\d+}`, srv.getBookHandler)
-	apiRouter.HandleFunc("/update", srv.updateBookHandler).Methods("POST")
-	apiRouter.HandleFunc("/merge", srv.mergeHandler).Methods("POST")
-	apiRouter.HandleFunc("/search", srv.apiSearchHandler)
-	secProvider := auth.HtpasswdFileProvider(cfg.HtpasswdFile)
-	authHandler := auth.NewBasicAuthenticator("Basic Realm", secProvider)
-	handler := http.Handler(r)
+	apiRouter.HandleFunc(`/book/{id:\d+}`, srv.requireScope(books.ScopeRead, srv.getBookHandler))
+	apiRouter.HandleFunc("/update", srv.requireScope(books.ScopeUpdate, srv.updateBookHandler)).Methods("POST")
+	apiRouter.HandleFunc("/merge", srv.requireScope(books.ScopeMerge, srv.mergeHandler)).Methods("POST")
+	apiRouter.HandleFunc("/import", srv.requireScope(books.ScopeImport, srv.apiImportHandler)).Methods("POST")
+	apiRouter.HandleFunc("/search", srv.requireScope(books.ScopeRead, srv.apiSearchHandler))
+	apiRouter.HandleFunc("/genres", srv.requireScope(books.ScopeRead, srv.apiGenresHandler))
+	apiRouter.HandleFunc("/shelves", srv.requireScope(books.ScopeRead, srv.apiShelvesHandler)).Methods("GET")
+	apiRouter.HandleFunc("/shelves", srv.requireScope(books.ScopeUpdate, srv.apiCreateShelfHandler)).Methods("POST")
+	apiRouter.HandleFunc("/shelves/{name}", srv.requireScope(books.ScopeRead, srv.apiShelfHandler)).Methods("GET")
+	apiRouter.HandleFunc("/shelves/{name}/add", srv.requireScope(books.ScopeUpdate, srv.apiAddToShelfHandler)).Methods("POST")
+	apiRouter.HandleFunc("/shelves/{name}/remove", srv.requireScope(books.ScopeUpdate, srv.apiRemoveFromShelfHandler)).Methods("POST")
+	apiRouter.HandleFunc("/shelves/{name}/delete", srv.requireScope(books.ScopeUpdate, srv.apiDeleteShelfHandler)).Methods("POST")
+	apiRouter.HandleFunc("/shelves/{name}/download", srv.requireScope(books.ScopeRead, srv.downloadShelfHandler))
+	apiRouter.HandleFunc("/saved", srv.requireScope(books.ScopeRead, srv.apiSavedSearchesHandler)).Methods("GET")
+	apiRouter.HandleFunc("/saved", srv.requireScope(books.ScopeUpdate, srv.apiSaveSearchHandler)).Methods("POST")
+	apiRouter.HandleFunc("/saved/{name}", srv.requireScope(books.ScopeRead, srv.apiRunSavedSearchHandler)).Methods("GET")
+	apiRouter.HandleFunc("/saved/{name}/delete", srv.requireScope(books.ScopeUpdate, srv.apiDeleteSavedSearchHandler)).Methods("POST")
+	apiRouter.HandleFunc("/saved/{name}/feed", srv.requireScope(books.ScopeRead, srv.savedFeedHandler))
 	if _, err := os.Stat(cfg.HtpasswdFile); err == nil {
-		handler = auth.JustCheck(authHandler, handler.ServeHTTP)
-		log.Printf("Using htpasswd file: %s\n", cfg.HtpasswdFile)
+		log.Printf("Using htpasswd file if the library has no users: %s\n", cfg.HtpasswdFile)
 	}
+	handler := srv.authMiddleware(r)
 	srv.hsrv.Handler = handler
 	return srv
 }
@@ -98,9 +165,32 @@ func (srv *Server) Start() error {
 	return srv.hsrv.ListenAndServe()
 }
 
+// Shutdown stops the server, waiting for requests in progress until ctx is done, and stops converting books.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	err := srv.hsrv.Shutdown(ctx)
+	srv.converter.Close()
+	return err
+}
+
 // render renders the template specified by name to w, and sets dot (.) to data.
-func (srv *Server) render(name string, w http.ResponseWriter, data interface{}) {
-	err := srv.templates.ExecuteTemplate(w, name, data)
+// The templates' currentUser and csrfToken functions return the name of the user making r, and the CSRF token of their session,
+// and savedSearches gets the saved searches with r's context.
+func (srv *Server) render(name string, w http.ResponseWriter, r *http.Request, data interface{}) {
+	tmpl, err := srv.templates.Clone()
+	if err != nil {
+		log.Println(errors.Wrap(err, "cloning templates"))
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Error")
+		return
+	}
+	user, _ := userFromContext(r.Context())
+	s, _ := sessionFromContext(r)
+	tmpl.Funcs(template.FuncMap{
+		"currentUser":   func() string { return user.Name },
+		"csrfToken":     func() string { return s.CSRFToken },
+		"savedSearches": savedSearches(r.Context(), srv.lib),
+	})
+	err = tmpl.ExecuteTemplate(w, name, data)
 	if err != nil {
 		log.Println(errors.Wrap(err, "rendering template"))
 		w.WriteHeader(http.StatusInternalServerError)
@@ -110,38 +200,47 @@ func (srv *Server) render(name string, w http.ResponseWriter, data interface{})
 }
 
 // searchFor wraps each item in a slice of strings with
-// a link to search for that item in the library.
-// Spaces will be replaced with +.
-// If field is not empty, the search will be limited to that field.
+// a link to the books in the library with that item.
+// Authors, series and tags link to their pages, and items in other fields are searched for in that field.
+// If field is empty, all fields are searched.
 func searchFor(field string, items []string) []string {
-	if field != "" {
-		field += ":"
-	}
-
 	newItems := make([]string, len(items))
 	for i := range items {
-		newItems[i] = fmt.Sprintf(`<a href="/search/?query=%s%s">%s</a>`,
-			field, html.EscapeString(strings.Replace(items[i], " ", "%2B", -1)),
-			html.EscapeString(items[i]))
+		newItems[i] = fmt.Sprintf(`<a href="%s">%s</a>`,
+			html.EscapeString(browseLink(field, items[i])), html.EscapeString(items[i]))
 	}
 
 	return newItems
 }
 
+// browseLink returns the URL of the books with item in field.
+func browseLink(field, item string) string {
+	switch field {
+	case "author":
+		return "/authors/" + url.PathEscape(item)
+	case "series":
+		return "/series/" + url.PathEscape(item)
+	case "tags":
+		return "/tags/" + url.PathEscape(item)
+	case "genres":
+		return "/genres/" + url.PathEscape(item)
+	}
+	if field != "" {
+		field += ":"
+	}
+	// Spaces are replaced with +, so the item is searched for as a phrase.
+	return "/search/?query=" + field + strings.Replace(url.QueryEscape(item), "+", "%2B", -1)
+}
+
+// highlightHTML escapes s, which comes from a books.Highlight, and marks the words which matched a search.
+func highlightHTML(s string) template.HTML {
+	s = html.EscapeString(s)
+	s = strings.Replace(s, books.HighlightStart, "<mark>", -1)
+	s = strings.Replace(s, books.HighlightEnd, "</mark>", -1)
+	return template.HTML(s)
+}
+
 // changeExt changes the extension of pathname to ext. ext must include a preceding dot.
 func changeExt(pathname string, ext string) string {
 	return strings.TrimSuffix(pathname, path.Ext(pathname)) + ext
 }
-
-func apiKeyMiddleware(key string, next http.Handler, apiLock *sync.Mutex) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if key == "" || r.Header.Get("x-API-key") != key {
-			w.WriteHeader(http.StatusForbidden)
-			writeJSON(w, apiError{"forbidden"})
-			return
-		}
-		apiLock.Lock()
-		next.ServeHTTP(w, r)
-		apiLock.Unlock()
-	})
-}
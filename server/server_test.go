@@ -0,0 +1,48 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/tspivey/books"
+)
+
+// newTestServer returns a server for an empty library in a temporary directory, which is removed when the test finishes.
+func newTestServer(t *testing.T) (*Server, *books.Library) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "books-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fn := filepath.Join(dir, "books.db")
+	if err := books.CreateLibrary(fn); err != nil {
+		t.Fatal(err)
+	}
+	lib, err := books.OpenLibrary(fn, dir, books.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lib.Close() })
+	converter := NewCalibreBookConverter(dir, dir, 1)
+	t.Cleanup(converter.Close)
+	srv := New(&Config{
+		Lib:             lib,
+		TemplatesDir:    filepath.Join("..", "templates"),
+		Converter:       converter,
+		ItemsPerPage:    10,
+		Hsrv:            &http.Server{},
+		HtpasswdFile:    filepath.Join(dir, "htpasswd"),
+		BooksRoot:       dir,
+		OutputTemplate:  template.Must(template.New("filename").Parse("{{.AuthorsShort}} - {{.Title}}.{{.Extension}}")),
+		Realm:           "Books",
+		SessionKey:      []byte("0123456789abcdef0123456789abcdef"),
+		SessionLifetime: time.Hour,
+	})
+	return srv, lib
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tspivey/books"
+)
+
+// sessionCookie is the name of the cookie holding a login session.
+const sessionCookie = "books_session"
+
+// session is a user's login session, stored in a cookie signed with the server's session key,
+// so it can't be changed or forged without the key.
+// The session is also recorded in the library by its token, so that logging out ends it even if the cookie is kept.
+type session struct {
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires"`
+	Token   string    `json:"token"`
+	// CSRFToken must be sent with every form posted in the session,
+	// so that other sites can't post forms as the user.
+	CSRFToken string `json:"csrf_token"`
+}
+
+type loginPage struct {
+	Next  string
+	Error string
+}
+
+// sign returns the signature of payload, made with the session key.
+func (srv *Server) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, srv.sessionKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// encodeSession returns the value of a cookie holding s: its payload and signature, each base64 encoded, separated by a dot.
+func (srv *Server) encodeSession(s session) (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(srv.sign(payload)), nil
+}
+
+// decodeSession returns the session held in a cookie, if its signature is valid and it hasn't expired.
+func (srv *Server) decodeSession(value string) (session, error) {
+	var s session
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 {
+		return s, errors.New("malformed session")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, srv.sign(parts[0])) {
+		return s, errors.New("invalid session signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return s, errors.Wrap(err, "decode session")
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, errors.Wrap(err, "decode session")
+	}
+	if !time.Now().Before(s.Expires) {
+		return s, errors.New("session expired")
+	}
+	return s, nil
+}
+
+// sessionFromCookie returns the session the request was made in, if it has a valid one which hasn't ended.
+func (srv *Server) sessionFromCookie(r *http.Request) (session, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return session{}, false
+	}
+	s, err := srv.decodeSession(c.Value)
+	if err != nil {
+		return s, false
+	}
+	user, err := srv.lib.CheckSessionContext(r.Context(), s.Token)
+	if err != nil {
+		if err != books.ErrSessionNotFound {
+			log.Printf("Error checking session of %s: %s", s.User, err)
+		}
+		return s, false
+	}
+	return s, strings.EqualFold(user, s.User)
+}
+
+// sessionFromContext returns the session a request was made in, if it was made in one.
+func sessionFromContext(r *http.Request) (session, bool) {
+	s, ok := r.Context().Value(sessionContextKey).(session)
+	return s, ok
+}
+
+// validCSRFToken returns true if a form posted in s has its CSRF token,
+// either in the csrf_token field, or the X-CSRF-Token header.
+func validCSRFToken(r *http.Request, s session) bool {
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.PostFormValue("csrf_token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
+
+// setSessionCookie sends the cookie holding s, or deletes it if s has already expired.
+// The cookie is only sent over HTTPS if the server is configured with secure cookies, or the request was made with TLS.
+func (srv *Server) setSessionCookie(w http.ResponseWriter, r *http.Request, s session) error {
+	value := ""
+	maxAge := -1
+	if s.Expires.After(time.Now()) {
+		var err error
+		if value, err = srv.encodeSession(s); err != nil {
+			return err
+		}
+		maxAge = int(time.Until(s.Expires) / time.Second)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   srv.secureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// loginHandler shows the login page, and starts a session for users who log in with it.
+func (srv *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
+	page := loginPage{Next: safeRedirect(r.FormValue("next"))}
+	if r.Method != "POST" {
+		srv.render("login", w, r, page)
+		return
+	}
+	enabled, hasUsers, err := srv.authEnabled(r.Context())
+	if err != nil {
+		log.Printf("Error logging in: %s", err)
+		srv.render("error_page", w, r, errorPage{"Error logging in", "An error occurred while logging in."})
+		return
+	}
+	if !enabled {
+		http.Redirect(w, r, page.Next, http.StatusSeeOther)
+		return
+	}
+	name := strings.TrimSpace(r.PostFormValue("user"))
+	user, err := srv.checkPassword(r.Context(), hasUsers, name, r.PostFormValue("password"))
+	if err == errUnauthorized {
+		log.Printf("Failed login for %s from %s", name, r.RemoteAddr)
+		page.Error = "Incorrect user name or password."
+		w.WriteHeader(http.StatusUnauthorized)
+		srv.render("login", w, r, page)
+		return
+	}
+	if err != nil {
+		log.Printf("Error logging in %s: %s", name, err)
+		srv.render("error_page", w, r, errorPage{"Error logging in", "An error occurred while logging in."})
+		return
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		log.Printf("Error creating CSRF token: %s", err)
+		srv.render("error_page", w, r, errorPage{"Error logging in", "An error occurred while logging in."})
+		return
+	}
+	s := session{User: user.Name, Expires: time.Now().Add(srv.sessionLifetime), CSRFToken: hex.EncodeToString(token)}
+	if s.Token, err = srv.lib.CreateSessionContext(r.Context(), s.User, s.Expires); err != nil {
+		log.Printf("Error creating session: %s", err)
+		srv.render("error_page", w, r, errorPage{"Error logging in", "An error occurred while logging in."})
+		return
+	}
+	if err := srv.setSessionCookie(w, r, s); err != nil {
+		log.Printf("Error creating session: %s", err)
+		srv.render("error_page", w, r, errorPage{"Error logging in", "An error occurred while logging in."})
+		return
+	}
+	http.Redirect(w, r, page.Next, http.StatusSeeOther)
+}
+
+// logoutHandler ends the session the request was made in.
+func (srv *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if s, ok := sessionFromContext(r); ok {
+		if err := srv.lib.EndSession(s.Token); err != nil {
+			log.Printf("Error ending session of %s: %s", s.User, err)
+			srv.render("error_page", w, r, errorPage{"Error logging out", "An error occurred while logging out."})
+			return
+		}
+		srv.setSessionCookie(w, r, session{})
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// safeRedirect returns next if it's a path on this site, so that the login page can't be used to send users elsewhere.
+// Otherwise, it returns the home page.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, `/\`) {
+		return "/"
+	}
+	return next
+}
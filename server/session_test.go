@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/tspivey/books"
+)
+
+// serve makes a request to srv, with the given cookie if it isn't nil, and returns the response.
+func serve(srv *Server, method, target string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	srv.hsrv.Handler.ServeHTTP(w, r)
+	return w
+}
+
+// sessionCookieFrom returns the session cookie set by a response, or nil if it didn't set one.
+func sessionCookieFrom(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+// login logs in to srv, and returns the session cookie and its CSRF token.
+func login(t *testing.T, srv *Server, user, password string) (*http.Cookie, string) {
+	t.Helper()
+	w := serve(srv, "POST", "/login", url.Values{"user": {user}, "password": {password}, "next": {"/unsorted"}}, nil)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/unsorted" {
+		t.Fatalf("Logging in returned status %d, redirecting to %q", w.Code, w.Header().Get("Location"))
+	}
+	c := sessionCookieFrom(w)
+	if c == nil {
+		t.Fatal("Logging in didn't set a session cookie")
+	}
+	s, err := srv.decodeSession(c.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, s.CSRFToken
+}
+
+// newAuthTestServer returns a server whose library has an editor, alice, with the password secret.
+func newAuthTestServer(t *testing.T) *Server {
+	t.Helper()
+	srv, lib := newTestServer(t)
+	if _, err := lib.AddUser("alice", "secret", books.RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestLogin(t *testing.T) {
+	srv := newAuthTestServer(t)
+	if w := serve(srv, "GET", "/unsorted", nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Without logging in, got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	for _, password := range []string{"", "wrong"} {
+		w := serve(srv, "POST", "/login", url.Values{"user": {"alice"}, "password": {password}}, nil)
+		if w.Code != http.StatusUnauthorized || sessionCookieFrom(w) != nil {
+			t.Errorf("Logging in with password %q returned status %d and cookie %v", password, w.Code, sessionCookieFrom(w))
+		}
+	}
+
+	cookie, _ := login(t, srv, "ALICE", "secret")
+	if w := serve(srv, "GET", "/unsorted", nil, cookie); w.Code != http.StatusOK {
+		t.Errorf("After logging in, got status %d, want %d", w.Code, http.StatusOK)
+	}
+	forged := *cookie
+	forged.Value = strings.Replace(forged.Value, ".", ".x", 1)
+	if w := serve(srv, "GET", "/unsorted", nil, &forged); w.Code != http.StatusUnauthorized {
+		t.Errorf("With a forged cookie, got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSecureCookies(t *testing.T) {
+	srv := newAuthTestServer(t)
+	for _, secure := range []bool{false, true} {
+		srv.secureCookies = secure
+		// The request is made without TLS, as it is behind a reverse proxy.
+		cookie, _ := login(t, srv, "alice", "secret")
+		if cookie.Secure != secure {
+			t.Errorf("With secure cookies %v, the session cookie's Secure = %v", secure, cookie.Secure)
+		}
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	srv := newAuthTestServer(t)
+	cookie, token := login(t, srv, "alice", "secret")
+	for _, test := range []struct {
+		name string
+		form url.Values
+	}{
+		{"no token", url.Values{}},
+		{"wrong token", url.Values{"csrf_token": {"0123"}}},
+	} {
+		w := serve(srv, "POST", "/logout", test.form, cookie)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, http.StatusForbidden)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/logout", strings.NewReader(url.Values{"csrf_token": {token}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", "https://other.example")
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	srv.hsrv.Handler.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("From another site: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	// None of the rejected requests logged out.
+	if w := serve(srv, "GET", "/unsorted", nil, cookie); w.Code != http.StatusOK {
+		t.Errorf("After rejected requests, got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	srv := newAuthTestServer(t)
+	cookie, token := login(t, srv, "alice", "secret")
+	other, _ := login(t, srv, "alice", "secret")
+
+	w := serve(srv, "POST", "/logout", url.Values{"csrf_token": {token}}, cookie)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Fatalf("Logging out returned status %d, redirecting to %q", w.Code, w.Header().Get("Location"))
+	}
+	if c := sessionCookieFrom(w); c == nil || c.MaxAge >= 0 {
+		t.Errorf("Logging out didn't delete the session cookie: %v", c)
+	}
+	// A copy of the cookie kept after logging out doesn't work.
+	if w := serve(srv, "GET", "/unsorted", nil, cookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("With the cookie of a session which was logged out, got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := serve(srv, "GET", "/unsorted", nil, other); w.Code != http.StatusOK {
+		t.Errorf("In another session, got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// Changing the password ends every session.
+	if err := srv.lib.SetUserPassword("alice", "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(srv, "GET", "/unsorted", nil, other); w.Code != http.StatusUnauthorized {
+		t.Errorf("After changing the password, got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	cookie, _ = login(t, srv, "alice", "new secret")
+	if err := srv.lib.RemoveUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.lib.AddUser("alice", "new secret", books.RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(srv, "GET", "/unsorted", nil, cookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("After removing the user, got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
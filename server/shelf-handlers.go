@@ -0,0 +1,70 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+type shelfPage struct {
+	Shelf books.Shelf
+	Books []books.Book
+}
+
+func (srv *Server) shelvesHandler(w http.ResponseWriter, r *http.Request) {
+	shelves, err := srv.lib.GetShelvesContext(r.Context())
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting shelves: %s", err)
+		srv.render("error_page", w, r, errorPage{"Error getting shelves", "An error occurred while getting the list."})
+		return
+	}
+	srv.render("shelves", w, r, shelves)
+}
+
+func (srv *Server) shelfHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	shelf, bks, err := srv.lib.GetShelfContext(r.Context(), name)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err == books.ErrShelfNotFound {
+		srv.render("error_page", w, r, errorPage{"Shelf not found", "There is no shelf with that name."})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting shelf %s: %s", name, err)
+		srv.render("error_page", w, r, errorPage{"Error getting shelf", "An error occurred while getting the books on that shelf."})
+		return
+	}
+	srv.render("shelf", w, r, shelfPage{shelf, bks})
+}
+
+// downloadShelfHandler sends the files of the books on a shelf as a zip file.
+// The format parameter limits it to one file per book, in the first of the given formats the book has.
+func (srv *Server) downloadShelfHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	files, _, err := srv.lib.ShelfFilesContext(r.Context(), name, books.ParamList(r.URL.Query(), "format"))
+	if r.Context().Err() != nil {
+		return
+	}
+	if err == books.ErrShelfNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting files on shelf %s: %s", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", attachmentDisposition(name+".zip"))
+	if err := books.WriteShelfZip(w, files); err != nil {
+		// The headers have already been sent, so the download is left incomplete.
+		log.Printf("Error sending shelf %s: %s", name, err)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSessionNotFound is returned by CheckSessionContext when a session doesn't exist, or has ended.
+var ErrSessionNotFound = errors.New("session not found")
+
+// CreateSessionContext starts a login session for a user, which lasts until expires, and returns its token.
+// The user doesn't have to be in the library, so that users from an htpasswd file can have sessions too.
+// Only a hash of the token is stored. Sessions which have expired are removed.
+func (lib *Library) CreateSessionContext(ctx context.Context, user string, expires time.Time) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", errors.Wrap(err, "generate session token")
+	}
+	tx, err := lib.begin(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "delete from sessions where expires_on <= datetime()"); err != nil {
+		return "", errors.Wrap(err, "delete expired sessions")
+	}
+	if _, err := tx.ExecContext(ctx, "insert into sessions (token_hash, user_name, expires_on) values(?, ?, ?)",
+		hashToken(token), user, expires.UTC().Format(dbTimeFormat)); err != nil {
+		return "", errors.Wrap(err, "insert session")
+	}
+	return token, errors.Wrap(tx.Commit(), "commit transaction")
+}
+
+// CheckSessionContext returns the name of the user whose session has the given token.
+// If the session has ended, because it expired, the user logged out, or their password was changed, ErrSessionNotFound is returned.
+func (lib *Library) CheckSessionContext(ctx context.Context, token string) (string, error) {
+	var user string
+	err := lib.readDB.QueryRowContext(ctx, "select user_name from sessions where token_hash=? and expires_on > datetime()", hashToken(token)).Scan(&user)
+	if err == sql.ErrNoRows {
+		return "", ErrSessionNotFound
+	}
+	return user, errors.Wrap(err, "get session")
+}
+
+// EndSession ends the session with the given token, such as when its user logs out.
+// Ending a session which has already ended isn't an error.
+func (lib *Library) EndSession(token string) error {
+	_, err := lib.Exec("delete from sessions where token_hash=?", hashToken(token))
+	return errors.Wrap(err, "delete session")
+}
+
+// EndUserSessions ends every session of a user, logging them out everywhere.
+func (lib *Library) EndUserSessions(user string) error {
+	_, err := lib.Exec("delete from sessions where user_name=?", user)
+	return errors.Wrap(err, "delete sessions")
+}
@@ -0,0 +1,66 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	lib := newTestLibrary(t)
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+	first, err := lib.CreateSessionContext(ctx, "alice", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := lib.CreateSessionContext(ctx, "alice", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := lib.CreateSessionContext(ctx, "bob", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := lib.CreateSessionContext(ctx, "bob", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(token, user string) {
+		t.Helper()
+		got, err := lib.CheckSessionContext(ctx, token)
+		if user == "" {
+			if err != ErrSessionNotFound {
+				t.Errorf("Checking an ended session returned %q and error %v, want %v", got, err, ErrSessionNotFound)
+			}
+			return
+		}
+		if err != nil || got != user {
+			t.Errorf("Checking a session of %s returned %q and error %v", user, got, err)
+		}
+	}
+	check(first, "alice")
+	check(second, "alice")
+	check(bob, "bob")
+	check(expired, "")
+	check("not a token", "")
+
+	if err := lib.EndSession(first); err != nil {
+		t.Fatal(err)
+	}
+	check(first, "")
+	check(second, "alice")
+	if err := lib.EndSession(first); err != nil {
+		t.Errorf("Ending a session twice returned error %v", err)
+	}
+
+	if err := lib.EndUserSessions("ALICE"); err != nil {
+		t.Fatal(err)
+	}
+	check(second, "")
+	check(bob, "bob")
+}
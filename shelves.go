@@ -0,0 +1,365 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrShelfNotFound is returned when a shelf doesn't exist.
+var ErrShelfNotFound = errors.New("shelf not found")
+
+// ErrShelfExists is returned when creating a shelf with the same name as an existing one.
+var ErrShelfExists = errors.New("shelf already exists")
+
+// Shelf is a named, ordered list of books, such as a reading list.
+type Shelf struct {
+	ID          int64
+	Name        string
+	Description string
+	// Books is the number of books on the shelf.
+	Books int
+}
+
+// ShelfFile is a file of a book on a shelf, to be exported.
+type ShelfFile struct {
+	// Name is the file's name in the export: its book's position on the shelf, followed by its name in the library,
+	// so that the files sort in the shelf's order.
+	Name string
+	// Path is where the file's contents are stored.
+	Path string
+	File BookFile
+}
+
+// checkName returns an error if name can't be used for a shelf, saved search or user, which kind says.
+// Names can't contain slashes, so they can be used in URLs.
+func checkName(kind, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.Errorf("%s name must not be empty", kind)
+	}
+	if strings.Contains(name, "/") {
+		return errors.Errorf("%s name must not contain /", kind)
+	}
+	return nil
+}
+
+// CreateShelf creates an empty shelf.
+// If a shelf with the same name already exists, ignoring case, ErrShelfExists is returned.
+func (lib *Library) CreateShelf(name, description string) (Shelf, error) {
+	shelf := Shelf{Name: strings.TrimSpace(name), Description: strings.TrimSpace(description)}
+	if err := checkName("shelf", shelf.Name); err != nil {
+		return shelf, err
+	}
+	ctx := context.Background()
+	tx, err := lib.begin(ctx)
+	if err != nil {
+		return shelf, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	if _, err := getShelf(ctx, tx, shelf.Name); err == nil {
+		return shelf, ErrShelfExists
+	} else if err != ErrShelfNotFound {
+		return shelf, err
+	}
+	res, err := tx.ExecContext(ctx, "insert into shelves (name, description) values(?, ?)", shelf.Name, shelf.Description)
+	if err != nil {
+		return shelf, errors.Wrap(err, "insert shelf")
+	}
+	if shelf.ID, err = res.LastInsertId(); err != nil {
+		return shelf, errors.Wrap(err, "get shelf ID")
+	}
+	return shelf, errors.Wrap(tx.Commit(), "commit transaction")
+}
+
+// DeleteShelf deletes a shelf. The books on it stay in the library.
+func (lib *Library) DeleteShelf(name string) error {
+	res, err := lib.Exec("delete from shelves where name=?", name)
+	if err != nil {
+		return errors.Wrap(err, "delete shelf")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "delete shelf")
+	} else if n == 0 {
+		return ErrShelfNotFound
+	}
+	return nil
+}
+
+// GetShelves returns every shelf, sorted by name.
+func (lib *Library) GetShelves() ([]Shelf, error) {
+	return lib.GetShelvesContext(context.Background())
+}
+
+// GetShelvesContext is like GetShelves, but stops if ctx is canceled.
+func (lib *Library) GetShelvesContext(ctx context.Context) ([]Shelf, error) {
+	rows, err := lib.readDB.QueryContext(ctx, `select s.id, s.name, s.description, count(sb.id)
+	from shelves s
+	left join shelves_books sb on sb.shelf_id=s.id
+	group by s.id
+	order by s.name`)
+	if err != nil {
+		return nil, errors.Wrap(err, "query shelves")
+	}
+	defer rows.Close()
+	var shelves []Shelf
+	for rows.Next() {
+		var s Shelf
+		if err := rows.Scan(&s.ID, &s.Name, &s.Description, &s.Books); err != nil {
+			return nil, errors.Wrap(err, "scan shelves")
+		}
+		shelves = append(shelves, s)
+	}
+	return shelves, errors.Wrap(rows.Err(), "query shelves")
+}
+
+// GetShelf returns a shelf and its books, in order.
+// If the shelf doesn't exist, ErrShelfNotFound is returned.
+func (lib *Library) GetShelf(name string) (Shelf, []Book, error) {
+	return lib.GetShelfContext(context.Background(), name)
+}
+
+// GetShelfContext is like GetShelf, but stops if ctx is canceled.
+func (lib *Library) GetShelfContext(ctx context.Context, name string) (Shelf, []Book, error) {
+	tx, err := lib.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return Shelf{}, nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	shelf, err := getShelf(ctx, tx, name)
+	if err != nil {
+		return shelf, nil, err
+	}
+	ids, err := getShelfBookIDs(ctx, tx, shelf.ID)
+	if err != nil {
+		return shelf, nil, err
+	}
+	bks, err := getBooksByID(ctx, tx, ids)
+	if err != nil {
+		return shelf, nil, errors.Wrap(err, "get books")
+	}
+	return shelf, bks, nil
+}
+
+// getShelf gets a shelf by name, ignoring case.
+func getShelf(ctx context.Context, tx *sql.Tx, name string) (Shelf, error) {
+	var s Shelf
+	err := tx.QueryRowContext(ctx, `select s.id, s.name, s.description, (select count(*) from shelves_books where shelf_id=s.id)
+	from shelves s where s.name=?`, name).Scan(&s.ID, &s.Name, &s.Description, &s.Books)
+	if err == sql.ErrNoRows {
+		return s, ErrShelfNotFound
+	}
+	return s, errors.Wrap(err, "get shelf")
+}
+
+// getShelfBookIDs gets the IDs of the books on a shelf, in order.
+func getShelfBookIDs(ctx context.Context, tx *sql.Tx, shelfID int64) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, "select book_id from shelves_books where shelf_id=? order by position", shelfID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get books on shelf")
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "get books on shelf")
+		}
+		ids = append(ids, id)
+	}
+	return ids, errors.Wrap(rows.Err(), "get books on shelf")
+}
+
+// AddToShelf adds books to a shelf, in the order given, so that the first of them is at position.
+// Positions start at 1, and 0 or a position past the end of the shelf adds them to the end.
+// Books already on the shelf are moved, so AddToShelf can also reorder a shelf.
+// If a book doesn't exist, ErrBookNotFound is returned, and if the shelf doesn't, ErrShelfNotFound is returned.
+func (lib *Library) AddToShelf(name string, ids []int64, position int) error {
+	return lib.changeShelf(name, func(ctx context.Context, tx *sql.Tx, current []int64) ([]int64, error) {
+		bks, err := getBooksByID(ctx, tx, ids)
+		if err != nil {
+			return nil, errors.Wrap(err, "get books")
+		}
+		adding := make(map[int64]bool)
+		for _, b := range bks {
+			adding[b.ID] = true
+		}
+		for _, id := range ids {
+			if !adding[id] {
+				return nil, ErrBookNotFound
+			}
+		}
+		var kept []int64
+		for _, id := range current {
+			if !adding[id] {
+				kept = append(kept, id)
+			}
+		}
+		if position <= 0 || position > len(kept) {
+			position = len(kept) + 1
+		}
+		var newIDs []int64
+		newIDs = append(newIDs, kept[:position-1]...)
+		for _, id := range ids {
+			// A book listed twice is only added once.
+			if adding[id] {
+				newIDs = append(newIDs, id)
+				delete(adding, id)
+			}
+		}
+		return append(newIDs, kept[position-1:]...), nil
+	})
+}
+
+// RemoveFromShelf removes books from a shelf, and returns the number of books which were on it.
+// If the shelf doesn't exist, ErrShelfNotFound is returned.
+func (lib *Library) RemoveFromShelf(name string, ids []int64) (int, error) {
+	removed := 0
+	err := lib.changeShelf(name, func(ctx context.Context, tx *sql.Tx, current []int64) ([]int64, error) {
+		removing := make(map[int64]bool)
+		for _, id := range ids {
+			removing[id] = true
+		}
+		var newIDs []int64
+		for _, id := range current {
+			if removing[id] {
+				removed++
+				continue
+			}
+			newIDs = append(newIDs, id)
+		}
+		return newIDs, nil
+	})
+	return removed, err
+}
+
+// changeShelf replaces the books on a shelf with those returned by change, which is given the books currently on it.
+func (lib *Library) changeShelf(name string, change func(ctx context.Context, tx *sql.Tx, current []int64) ([]int64, error)) error {
+	ctx := context.Background()
+	tx, err := lib.begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	shelf, err := getShelf(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	current, err := getShelfBookIDs(ctx, tx, shelf.ID)
+	if err != nil {
+		return err
+	}
+	ids, err := change(ctx, tx, current)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "delete from shelves_books where shelf_id=?", shelf.ID); err != nil {
+		return errors.Wrap(err, "clear shelf")
+	}
+	for i, id := range ids {
+		if _, err := tx.ExecContext(ctx, "insert into shelves_books (shelf_id, book_id, position) values(?, ?, ?)", shelf.ID, id, i+1); err != nil {
+			return errors.Wrap(err, "add book to shelf")
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "update shelves set updated_on=datetime() where id=?", shelf.ID); err != nil {
+		return errors.Wrap(err, "update shelf")
+	}
+	return errors.Wrap(tx.Commit(), "commit transaction")
+}
+
+// ShelfFiles returns the files of the books on a shelf, to be exported in order.
+// If formats are given, such as epub, only one file is exported for each book, in the first of the formats it has,
+// and books which have none of them are returned in missing.
+// If the shelf doesn't exist, ErrShelfNotFound is returned.
+func (lib *Library) ShelfFiles(name string, formats []string) (files []ShelfFile, missing []Book, err error) {
+	return lib.ShelfFilesContext(context.Background(), name, formats)
+}
+
+// ShelfFilesContext is like ShelfFiles, but stops if ctx is canceled.
+func (lib *Library) ShelfFilesContext(ctx context.Context, name string, formats []string) (files []ShelfFile, missing []Book, err error) {
+	_, bks, err := lib.GetShelfContext(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	width := len(strconv.Itoa(len(bks)))
+	for i, book := range bks {
+		bookFiles := book.Files
+		if len(formats) > 0 {
+			bookFiles = nil
+			if f, ok := fileInFormats(book.Files, formats); ok {
+				bookFiles = []BookFile{f}
+			}
+		}
+		if len(bookFiles) == 0 {
+			missing = append(missing, book)
+			continue
+		}
+		for _, f := range bookFiles {
+			files = append(files, ShelfFile{
+				Name: fmt.Sprintf("%0*d - %s", width, i+1, path.Base(f.CurrentFilename)),
+				Path: filepath.Join(lib.booksRoot, f.HashPath()),
+				File: f,
+			})
+		}
+	}
+	return files, missing, nil
+}
+
+// fileInFormats returns the file in the first of formats found in files.
+func fileInFormats(files []BookFile, formats []string) (BookFile, bool) {
+	for _, format := range formats {
+		for _, f := range files {
+			if strings.EqualFold(f.Extension, strings.TrimPrefix(format, ".")) {
+				return f, true
+			}
+		}
+	}
+	return BookFile{}, false
+}
+
+// WriteShelfZip writes files, from ShelfFiles, to w as a zip archive.
+func WriteShelfZip(w io.Writer, files []ShelfFile) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: f.File.FileMtime})
+		if err != nil {
+			return errors.Wrapf(err, "add %s to zip", f.Name)
+		}
+		fp, err := os.Open(f.Path)
+		if err != nil {
+			return errors.Wrapf(err, "add %s to zip", f.Name)
+		}
+		_, err = io.Copy(fw, fp)
+		fp.Close()
+		if err != nil {
+			return errors.Wrapf(err, "add %s to zip", f.Name)
+		}
+	}
+	return errors.Wrap(zw.Close(), "finish zip")
+}
+
+// CopyShelfFiles copies files, from ShelfFiles, into dir, such as a reading device's storage.
+// Files already in dir with the same names are replaced.
+func CopyShelfFiles(dir string, files []ShelfFile) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "create directory")
+	}
+	for _, f := range files {
+		if err := copyFile(f.Path, filepath.Join(dir, f.Name)); err != nil {
+			return errors.Wrapf(err, "copy %s", f.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnsortedFileNotFound is returned when an unsorted file is not found in the database.
+var ErrUnsortedFileNotFound = errors.New("unsorted file not found")
+
+// UnsortedFile is a file which couldn't be imported because no metadata parser matched it.
+// It is kept in the unsorted staging area until it is assigned a title and authors.
+type UnsortedFile struct {
+	ID        int64
+	CreatedOn time.Time
+	File      BookFile
+}
+
+// StagedPath gets the path of an unsorted file in the staging area, relative to books root.
+func (uf *UnsortedFile) StagedPath() string {
+	return filepath.Join("unsorted", uf.File.Hash)
+}
+
+// StageUnsortedFile copies or moves bf.OriginalFilename into the unsorted staging area,
+// and records it in the library so it can be assigned metadata later with AssignUnsortedFile.
+// If a file with the same hash is already staged, its ID is returned and nothing is copied.
+func (lib *Library) StageUnsortedFile(bf BookFile, move bool) (int64, error) {
+	var id int64
+	err := lib.QueryRow("select id from unsorted_files where hash=?", bf.Hash).Scan(&id)
+	if err == nil {
+		log.Printf("%s is already in the unsorted staging area with ID %d", bf.OriginalFilename, id)
+		return id, nil
+	} else if err != sql.ErrNoRows {
+		return 0, errors.Wrap(err, "find staged file")
+	}
+
+	uf := UnsortedFile{File: bf}
+	stagedPath := filepath.Join(lib.booksRoot, uf.StagedPath())
+	if err := moveOrCopyFile(bf.OriginalFilename, stagedPath+".tmp", move); err != nil {
+		return 0, errors.Wrap(err, "move or copy file")
+	}
+	if err := os.Rename(stagedPath+".tmp", stagedPath); err != nil {
+		return 0, errors.Wrap(err, "rename temporary file")
+	}
+
+	res, err := lib.Exec(`insert into unsorted_files (extension, original_filename, file_size, file_mtime, hash, tags, source)
+	values (?, ?, ?, ?, ?, ?, ?)`,
+		bf.Extension, bf.OriginalFilename, bf.FileSize, bf.FileMtime, bf.Hash, strings.Join(bf.Tags, "\n"), bf.Source)
+	if err != nil {
+		return 0, errors.Wrap(err, "insert unsorted file")
+	}
+	id, err = res.LastInsertId()
+	if err != nil {
+		return 0, errors.Wrap(err, "get unsorted file ID")
+	}
+	log.Printf("Staged %s as unsorted file %d", bf.OriginalFilename, id)
+	return id, nil
+}
+
+// GetUnsortedFiles gets all files in the unsorted staging area, oldest first.
+func (lib *Library) GetUnsortedFiles() ([]UnsortedFile, error) {
+	return lib.getUnsortedFiles("select id, created_on, extension, original_filename, file_size, file_mtime, hash, tags, source from unsorted_files order by id")
+}
+
+// GetUnsortedFileByID gets a file in the unsorted staging area by its ID.
+func (lib *Library) GetUnsortedFileByID(id int64) (UnsortedFile, error) {
+	files, err := lib.getUnsortedFiles("select id, created_on, extension, original_filename, file_size, file_mtime, hash, tags, source from unsorted_files where id=?", id)
+	if err != nil {
+		return UnsortedFile{}, err
+	}
+	if len(files) == 0 {
+		return UnsortedFile{}, ErrUnsortedFileNotFound
+	}
+	return files[0], nil
+}
+
+func (lib *Library) getUnsortedFiles(query string, args ...interface{}) ([]UnsortedFile, error) {
+	rows, err := lib.readDB.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "query unsorted files")
+	}
+	defer rows.Close()
+
+	files := []UnsortedFile{}
+	for rows.Next() {
+		var uf UnsortedFile
+		var tags string
+		var source sql.NullString
+		bf := &uf.File
+		if err := rows.Scan(&uf.ID, &uf.CreatedOn, &bf.Extension, &bf.OriginalFilename, &bf.FileSize, &bf.FileMtime, &bf.Hash, &tags, &source); err != nil {
+			return nil, errors.Wrap(err, "scan unsorted file")
+		}
+		if tags != "" {
+			bf.Tags = strings.Split(tags, "\n")
+		}
+		bf.Source = source.String
+		files = append(files, uf)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "get unsorted files")
+	}
+	return files, nil
+}
+
+// AssignUnsortedFile finishes importing an unsorted file, using the authors, title and series of book.
+// The file keeps the tags, source and original filename it was staged with.
+// Once imported, it is removed from the unsorted staging area.
+func (lib *Library) AssignUnsortedFile(id int64, book Book, tmpl *template.Template, policy DuplicatePolicy) (ImportResult, error) {
+	return lib.AssignUnsortedFileContext(context.Background(), id, book, tmpl, policy)
+}
+
+// AssignUnsortedFileContext is like AssignUnsortedFile, but stops if ctx is canceled before the file is imported.
+func (lib *Library) AssignUnsortedFileContext(ctx context.Context, id int64, book Book, tmpl *template.Template, policy DuplicatePolicy) (ImportResult, error) {
+	uf, err := lib.GetUnsortedFileByID(id)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	// Put the staged file in place first, so ImportBook finds it and doesn't copy from the original filename.
+	stagedPath := filepath.Join(lib.booksRoot, uf.StagedPath())
+	hashPath := filepath.Join(lib.booksRoot, uf.File.HashPath())
+	moved := false
+	if _, err := os.Stat(hashPath); os.IsNotExist(err) {
+		if err := moveOrCopyFile(stagedPath, hashPath+".tmp", true); err != nil {
+			return ImportResult{}, errors.Wrap(err, "move staged file")
+		}
+		if err := os.Rename(hashPath+".tmp", hashPath); err != nil {
+			return ImportResult{}, errors.Wrap(err, "rename temporary file")
+		}
+		moved = true
+	} else if err != nil {
+		return ImportResult{}, errors.Wrap(err, "stat")
+	}
+
+	book.Files = []BookFile{uf.File}
+	result, err := lib.ImportBookContext(ctx, book, tmpl, false, policy)
+	if err != nil {
+		if moved {
+			if err := os.Rename(hashPath, stagedPath); err != nil {
+				log.Printf("Error moving %s back to %s: %v", hashPath, stagedPath, err)
+			}
+		}
+		return result, err
+	}
+
+	if _, err := lib.Exec("delete from unsorted_files where id=?", id); err != nil {
+		return result, errors.Wrap(err, "delete unsorted file")
+	}
+	if !moved {
+		if err := os.Remove(stagedPath); err != nil {
+			log.Printf("Error deleting %s: %v", stagedPath, err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,247 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUserNotFound is returned when a user doesn't exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserExists is returned when adding a user with the same name as an existing one.
+var ErrUserExists = errors.New("user already exists")
+
+// ErrBadLogin is returned by AuthenticateUser when the user doesn't exist, or the password is wrong.
+var ErrBadLogin = errors.New("incorrect user name or password")
+
+// Role decides what a user of the web server may do. Each role may do everything the roles before it may.
+type Role int
+
+const (
+	// RoleReader may search for, browse and download books.
+	RoleReader Role = iota
+	// RoleEditor may also change books, such as updating and merging them, and manage shelves and saved searches.
+	RoleEditor
+	// RoleAdmin may do anything.
+	RoleAdmin
+)
+
+// RoleNames are the names accepted by ParseRole.
+var RoleNames = []string{"reader", "editor", "admin"}
+
+// ParseRole parses a role by name: reader, editor, or admin.
+func ParseRole(name string) (Role, error) {
+	name = strings.ToLower(name)
+	for i, n := range RoleNames {
+		if n == name {
+			return Role(i), nil
+		}
+	}
+	return RoleReader, errors.Errorf("unknown role %s; roles are %s", name, strings.Join(RoleNames, ", "))
+}
+
+// String returns the name of the role.
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(RoleNames) {
+		return "unknown"
+	}
+	return RoleNames[r]
+}
+
+// User is a user of the web server.
+type User struct {
+	ID   int64
+	Name string
+	Role Role
+}
+
+// dummyHash is compared against when authenticating a user who doesn't exist,
+// so that the time taken doesn't reveal whether they do.
+// It's a bcrypt hash with the same cost as users' passwords, precomputed so that starting the program doesn't have to hash a password.
+const dummyHash = "$2a$10$tGydOVx1dSsaRgLrezrc1ui1bNHz4q8r3UlCnZD43sw2k8w.JidH6"
+
+// AddUser adds a user with a password, which is stored hashed with bcrypt.
+// If a user with the same name already exists, ignoring case, ErrUserExists is returned.
+func (lib *Library) AddUser(name, password string, role Role) (User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, errors.Wrap(err, "hash password")
+	}
+	return lib.AddUserWithHash(name, string(hash), role)
+}
+
+// AddUserWithHash is like AddUser, but takes a password which has already been hashed with bcrypt,
+// such as one from an htpasswd file.
+func (lib *Library) AddUserWithHash(name, hash string, role Role) (User, error) {
+	user := User{Name: strings.TrimSpace(name), Role: role}
+	if err := checkName("user", user.Name); err != nil {
+		return user, err
+	}
+	if strings.Contains(user.Name, ":") {
+		// Basic authentication separates the name from the password with a colon.
+		return user, errors.New("user name must not contain :")
+	}
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return user, errors.Wrap(err, "password hash isn't bcrypt")
+	}
+	ctx := context.Background()
+	tx, err := lib.begin(ctx)
+	if err != nil {
+		return user, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	if _, _, err := getUser(ctx, tx, user.Name); err == nil {
+		return user, ErrUserExists
+	} else if err != ErrUserNotFound {
+		return user, err
+	}
+	res, err := tx.ExecContext(ctx, "insert into users (name, password_hash, role) values(?, ?, ?)", user.Name, hash, role.String())
+	if err != nil {
+		return user, errors.Wrap(err, "insert user")
+	}
+	if user.ID, err = res.LastInsertId(); err != nil {
+		return user, errors.Wrap(err, "get user ID")
+	}
+	return user, errors.Wrap(tx.Commit(), "commit transaction")
+}
+
+// SetUserPassword changes a user's password, and ends their sessions, so they have to log in again with it.
+func (lib *Library) SetUserPassword(name, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "hash password")
+	}
+	if err := lib.updateUser(name, "password_hash", string(hash)); err != nil {
+		return err
+	}
+	return lib.EndUserSessions(name)
+}
+
+// SetUserRole changes a user's role.
+func (lib *Library) SetUserRole(name string, role Role) error {
+	return lib.updateUser(name, "role", role.String())
+}
+
+// updateUser sets column to value for the user with the given name.
+func (lib *Library) updateUser(name, column string, value interface{}) error {
+	res, err := lib.Exec("update users set updated_on=datetime(), "+column+"=? where name=?", value, name)
+	if err != nil {
+		return errors.Wrap(err, "update user")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "update user")
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// RemoveUser removes a user, and ends their sessions.
+func (lib *Library) RemoveUser(name string) error {
+	res, err := lib.Exec("delete from users where name=?", name)
+	if err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "delete user")
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return lib.EndUserSessions(name)
+}
+
+// GetUsers returns every user, sorted by name.
+func (lib *Library) GetUsers() ([]User, error) {
+	return lib.GetUsersContext(context.Background())
+}
+
+// GetUsersContext is like GetUsers, but stops if ctx is canceled.
+func (lib *Library) GetUsersContext(ctx context.Context) ([]User, error) {
+	rows, err := lib.readDB.QueryContext(ctx, "select id, name, role from users order by name")
+	if err != nil {
+		return nil, errors.Wrap(err, "query users")
+	}
+	defer rows.Close()
+	var users []User
+	for rows.Next() {
+		var u User
+		var role string
+		if err := rows.Scan(&u.ID, &u.Name, &role); err != nil {
+			return nil, errors.Wrap(err, "scan users")
+		}
+		if u.Role, err = ParseRole(role); err != nil {
+			return nil, errors.Wrapf(err, "role of user %s", u.Name)
+		}
+		users = append(users, u)
+	}
+	return users, errors.Wrap(rows.Err(), "query users")
+}
+
+// HasUsersContext returns true if the library has any users.
+func (lib *Library) HasUsersContext(ctx context.Context) (bool, error) {
+	var exists bool
+	err := lib.readDB.QueryRowContext(ctx, "select exists (select 1 from users)").Scan(&exists)
+	return exists, errors.Wrap(err, "query users")
+}
+
+// GetUserContext returns a user by name, ignoring case.
+// If the user doesn't exist, ErrUserNotFound is returned.
+func (lib *Library) GetUserContext(ctx context.Context, name string) (User, error) {
+	tx, err := lib.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	user, _, err := getUser(ctx, tx, name)
+	return user, err
+}
+
+// AuthenticateUser returns the user with the given name and password.
+// If there's no such user, or the password is wrong, ErrBadLogin is returned.
+func (lib *Library) AuthenticateUser(name, password string) (User, error) {
+	return lib.AuthenticateUserContext(context.Background(), name, password)
+}
+
+// AuthenticateUserContext is like AuthenticateUser, but stops if ctx is canceled.
+func (lib *Library) AuthenticateUserContext(ctx context.Context, name, password string) (User, error) {
+	tx, err := lib.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	user, hash, err := getUser(ctx, tx, name)
+	if err == ErrUserNotFound {
+		bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+		return user, ErrBadLogin
+	} else if err != nil {
+		return user, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return User{}, ErrBadLogin
+	}
+	return user, nil
+}
+
+// getUser gets a user and their password hash by name, ignoring case.
+func getUser(ctx context.Context, tx *sql.Tx, name string) (User, string, error) {
+	var u User
+	var hash, role string
+	err := tx.QueryRowContext(ctx, "select id, name, password_hash, role from users where name=?", name).Scan(&u.ID, &u.Name, &hash, &role)
+	if err == sql.ErrNoRows {
+		return u, "", ErrUserNotFound
+	} else if err != nil {
+		return u, "", errors.Wrap(err, "get user")
+	}
+	if u.Role, err = ParseRole(role); err != nil {
+		return u, "", errors.Wrapf(err, "role of user %s", u.Name)
+	}
+	return u, hash, nil
+}
@@ -0,0 +1,65 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDummyHash(t *testing.T) {
+	// Logging in as a user who doesn't exist must take as long as logging in as one who does.
+	cost, err := bcrypt.Cost([]byte(dummyHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Errorf("dummyHash has cost %d, want %d", cost, bcrypt.DefaultCost)
+	}
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	lib := newTestLibrary(t)
+	if _, err := lib.AddUser("Alice", "secret", RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.AddUser("alice", "other", RoleReader); err != ErrUserExists {
+		t.Errorf("Adding a user with the same name returned error %v, want %v", err, ErrUserExists)
+	}
+
+	user, err := lib.AuthenticateUser("ALICE", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Alice" || user.Role != RoleEditor {
+		t.Errorf("AuthenticateUser returned %+v", user)
+	}
+	for _, login := range []struct{ name, password string }{
+		{"Alice", "Secret"},
+		{"Alice", ""},
+		{"Bob", "secret"},
+	} {
+		if _, err := lib.AuthenticateUser(login.name, login.password); err != ErrBadLogin {
+			t.Errorf("AuthenticateUser(%q, %q) returned error %v, want %v", login.name, login.password, err, ErrBadLogin)
+		}
+	}
+
+	if err := lib.SetUserPassword("alice", "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.AuthenticateUser("Alice", "secret"); err != ErrBadLogin {
+		t.Errorf("Old password returned error %v, want %v", err, ErrBadLogin)
+	}
+	if _, err := lib.AuthenticateUser("Alice", "new secret"); err != nil {
+		t.Errorf("New password returned error %v", err)
+	}
+	if err := lib.RemoveUser("Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.AuthenticateUser("Alice", "new secret"); err != ErrBadLogin {
+		t.Errorf("Removed user returned error %v, want %v", err, ErrBadLogin)
+	}
+}